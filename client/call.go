@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Join joins a room and waits for the gateway to confirm it. The room is
+// rejoined after every reconnect until Leave.
+func (c *Client) Join(ctx context.Context, room string) error {
+	id := c.id()
+	reply, err := c.request(ctx, &ClientMessage{Type: "join", ID: id, Room: room}, id, "join:"+room)
+	if err != nil {
+		return err
+	}
+	if reply.msg.Type == "error" {
+		return frameError(reply.msg, reply.raw)
+	}
+	c.mu.Lock()
+	c.rooms[room] = true
+	c.mu.Unlock()
+	return nil
+}
+
+// Leave leaves a room and waits for the gateway to confirm it
+func (c *Client) Leave(ctx context.Context, room string) error {
+	c.mu.Lock()
+	delete(c.rooms, room)
+	c.mu.Unlock()
+
+	id := c.id()
+	reply, err := c.request(ctx, &ClientMessage{Type: "leave", ID: id, Room: room}, id, "leave:"+room)
+	if err != nil {
+		return err
+	}
+	if reply.msg.Type == "error" {
+		return frameError(reply.msg, reply.raw)
+	}
+	return nil
+}
+
+// Call makes an RPC call to a method of the gateway's rpc.methods. req is
+// marshalled to JSON; the result is unmarshalled into resp unless resp is
+// nil. A failed call returns an *Error, e.g. TIMEOUT or UNKNOWN_METHOD.
+func (c *Client) Call(ctx context.Context, method string, req, resp interface{}) error {
+	msg := &ClientMessage{Type: "rpc", ID: c.id(), Method: method}
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		msg.Payload = data
+	}
+	reply, err := c.request(ctx, msg, msg.ID)
+	if err != nil {
+		return err
+	}
+	if reply.msg.Type != "rpc_result" {
+		return frameError(reply.msg, reply.raw)
+	}
+	if resp == nil || len(reply.msg.Payload) == 0 {
+		return nil
+	}
+	return json.Unmarshal(reply.msg.Payload, resp)
+}
+
+// request sends msg and waits for the first frame resolving one of keys
+func (c *Client) request(ctx context.Context, msg *ClientMessage, keys ...string) (reply, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.RequestTimeout)
+		defer cancel()
+	}
+
+	ch := make(chan reply, 1)
+	c.mu.Lock()
+	for _, k := range keys {
+		c.waiters[k] = ch
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		for _, k := range keys {
+			if c.waiters[k] == ch {
+				delete(c.waiters, k)
+			}
+		}
+		c.mu.Unlock()
+	}()
+
+	if err := c.SendMessage(msg); err != nil {
+		return reply{}, err
+	}
+	select {
+	case r := <-ch:
+		if r.msg == nil {
+			return reply{}, ErrNotConnected
+		}
+		return r, nil
+	case <-ctx.Done():
+		return reply{}, ctx.Err()
+	}
+}
+
+// resolve hands a frame to the request waiting on key, if any
+func (c *Client) resolve(key string, msg *ServerMessage, raw []byte) {
+	c.mu.Lock()
+	ch, ok := c.waiters[key]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case ch <- reply{msg: msg, raw: raw}:
+	default:
+	}
+}
+
+// failWaiters fails the pending requests of a dropped connection; their
+// replies can no longer arrive
+func (c *Client) failWaiters() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, ch := range c.waiters {
+		select {
+		case ch <- reply{}:
+		default:
+		}
+		delete(c.waiters, k)
+	}
+}
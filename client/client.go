@@ -0,0 +1,485 @@
+// Package client is a Go client for the gateway's WebSocket protocol: it
+// authenticates, reconnects with backoff, rejoins rooms, dispatches frames
+// to handlers by type and makes RPC calls.
+//
+// The gateway does not replay frames missed while disconnected; use the
+// reconnect callback to resync state from the backend.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/protocol"
+	fastws "github.com/fasthttp/websocket"
+)
+
+// TokenSource returns the token for a connection attempt. It is called on
+// every dial, so it can fetch short-lived tokens or one-time tickets.
+type TokenSource func(ctx context.Context) (string, error)
+
+// StaticToken always returns the same token
+func StaticToken(token string) TokenSource {
+	return func(context.Context) (string, error) { return token, nil }
+}
+
+// Handler receives a frame. Handlers run on the read goroutine and must
+// not block; raw is the frame as JSON.
+type Handler func(msg *ServerMessage, raw []byte)
+
+// Options configure a client. URL and a token are required.
+type Options struct {
+	URL         string      // e.g. ws://localhost:8086/ws
+	Token       string      // used when TokenSource is nil
+	TokenSource TokenSource // called on every dial
+	Protocol    string      // subprotocol (JSON, MsgPack or Proto), default JSON
+	Header      http.Header // extra handshake headers, e.g. Origin
+
+	NoReconnect    bool          // fail instead of reconnecting when the connection drops
+	BackoffMin     time.Duration // default 500ms
+	BackoffMax     time.Duration // default 30s
+	ReadTimeout    time.Duration // without any frame or ping, default 75s
+	RequestTimeout time.Duration // Join, Leave and Call without a context deadline, default 10s
+
+	// OnConnect is called after every successful (re)connect, once rooms
+	// have been rejoined; reconnected is false the first time
+	OnConnect func(welcome Welcome, reconnected bool)
+	// OnDisconnect is called when a connection drops
+	OnDisconnect func(err error)
+}
+
+// Client is a connection to the gateway that survives reconnects
+type Client struct {
+	opts  Options
+	codec protocol.Codec
+
+	mu      sync.Mutex
+	conn    *fastws.Conn // nil while reconnecting
+	welcome Welcome
+	rooms   map[string]bool
+	rejoins map[string]string // request ID -> room, for the current connection
+	waiters map[string]chan reply
+	hint    *reconnectHint
+	closed  bool
+
+	writeMu   sync.Mutex
+	handlers  sync.Map // type -> []Handler, copied on write under handlerMu
+	handlerMu sync.Mutex
+	any       atomic.Pointer[[]Handler]
+	nextID    atomic.Uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type reply struct {
+	msg *ServerMessage
+	raw []byte
+}
+
+// Dial connects to the gateway. The first connection must succeed; later
+// drops are retried with backoff unless NoReconnect is set.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	if opts.URL == "" {
+		return nil, errors.New("client: URL is required")
+	}
+	if opts.TokenSource == nil {
+		if opts.Token == "" {
+			return nil, errors.New("client: Token or TokenSource is required")
+		}
+		opts.TokenSource = StaticToken(opts.Token)
+	}
+	if opts.Protocol == "" {
+		opts.Protocol = JSON
+	}
+	if opts.BackoffMin <= 0 {
+		opts.BackoffMin = 500 * time.Millisecond
+	}
+	if opts.BackoffMax < opts.BackoffMin {
+		opts.BackoffMax = max(30*time.Second, opts.BackoffMin)
+	}
+	if opts.ReadTimeout <= 0 {
+		opts.ReadTimeout = 75 * time.Second
+	}
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = 10 * time.Second
+	}
+
+	c := &Client{
+		opts:    opts,
+		codec:   protocol.ForName(opts.Protocol),
+		rooms:   make(map[string]bool),
+		waiters: make(map[string]chan reply),
+		done:    make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	conn, err := c.connect(ctx, opts.URL)
+	if err != nil {
+		c.cancel()
+		return nil, err
+	}
+	go c.run(conn)
+	return c, nil
+}
+
+// On registers a handler for frames of one type, e.g. "message" or "typing"
+func (c *Client) On(msgType string, h Handler) {
+	c.handlerMu.Lock()
+	defer c.handlerMu.Unlock()
+	var hs []Handler
+	if v, ok := c.handlers.Load(msgType); ok {
+		hs = v.([]Handler)
+	}
+	c.handlers.Store(msgType, append(hs[:len(hs):len(hs)], h))
+}
+
+// OnAny registers a handler for every frame
+func (c *Client) OnAny(h Handler) {
+	c.handlerMu.Lock()
+	defer c.handlerMu.Unlock()
+	var hs []Handler
+	if p := c.any.Load(); p != nil {
+		hs = *p
+	}
+	hs = append(hs[:len(hs):len(hs)], h)
+	c.any.Store(&hs)
+}
+
+// Welcome returns the "connected" payload of the current connection
+func (c *Client) Welcome() Welcome {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome
+}
+
+// Connected reports whether a connection is up
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// Rooms returns the rooms joined through Join; they are rejoined after a reconnect
+func (c *Client) Rooms() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rooms := make([]string, 0, len(c.rooms))
+	for r := range c.rooms {
+		rooms = append(rooms, r)
+	}
+	return rooms
+}
+
+// Close closes the connection and stops reconnecting
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	conn := c.conn
+	c.mu.Unlock()
+
+	c.cancel()
+	if conn != nil {
+		c.writeMu.Lock()
+		conn.WriteControl(fastws.CloseMessage,
+			fastws.FormatCloseMessage(fastws.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		c.writeMu.Unlock()
+		conn.Close()
+	}
+	<-c.done
+	return nil
+}
+
+// connect dials, authenticates and waits for the "connected" frame
+func (c *Client) connect(ctx context.Context, url string) (*fastws.Conn, error) {
+	token, err := c.opts.TokenSource(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("client: token: %w", err)
+	}
+	header := http.Header{}
+	for k, v := range c.opts.Header {
+		header[k] = v
+	}
+	header.Set("Authorization", "Bearer "+token)
+
+	dialer := fastws.Dialer{
+		HandshakeTimeout: c.opts.RequestTimeout,
+		Subprotocols:     []string{c.codec.Name()},
+	}
+	conn, resp, err := dialer.DialContext(ctx, url, header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("client: dial %s: HTTP %d: %w", url, resp.StatusCode, err)
+		}
+		return nil, fmt.Errorf("client: dial %s: %w", url, err)
+	}
+
+	// The first frame is "connected", or an AUTH_FAILED error
+	conn.SetReadDeadline(time.Now().Add(c.opts.RequestTimeout))
+	msg, raw, err := c.read(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("client: handshake: %w", err)
+	}
+	if msg.Type != "connected" {
+		conn.Close()
+		if msg.Type == "error" {
+			return nil, frameError(msg, raw)
+		}
+		return nil, fmt.Errorf("client: unexpected first frame %q", msg.Type)
+	}
+	var welcome Welcome
+	json.Unmarshal(msg.Payload, &welcome)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return nil, ErrClosed
+	}
+	reconnected := c.welcome.ConnID != ""
+	c.conn = conn
+	c.welcome = welcome
+	c.hint = nil
+	c.rejoins = make(map[string]string, len(c.rooms))
+	rejoins := make([]*ClientMessage, 0, len(c.rooms))
+	for r := range c.rooms {
+		msg := &ClientMessage{Type: "join", ID: c.id(), Room: r}
+		c.rejoins[msg.ID] = r
+		rejoins = append(rejoins, msg)
+	}
+	c.mu.Unlock()
+
+	// Rejoin; confirmations and refusals arrive through the read loop
+	for _, msg := range rejoins {
+		c.write(conn, msg)
+	}
+	c.dispatch(msg, raw)
+	if c.opts.OnConnect != nil {
+		c.opts.OnConnect(welcome, reconnected)
+	}
+	return conn, nil
+}
+
+// run reads from conn and reconnects until Close
+func (c *Client) run(conn *fastws.Conn) {
+	defer close(c.done)
+	for {
+		err := c.readLoop(conn)
+
+		c.mu.Lock()
+		c.conn = nil
+		hint := c.hint
+		closed := c.closed
+		c.mu.Unlock()
+		c.failWaiters()
+		if closed {
+			return
+		}
+		if c.opts.OnDisconnect != nil {
+			c.opts.OnDisconnect(err)
+		}
+		if c.opts.NoReconnect {
+			c.mu.Lock()
+			c.closed = true
+			c.mu.Unlock()
+			return
+		}
+
+		if conn = c.reconnect(hint); conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials with exponential backoff and jitter; a draining node's
+// hint sets the first delay and URL. It returns nil once the client is closed.
+func (c *Client) reconnect(hint *reconnectHint) *fastws.Conn {
+	url, delay := c.opts.URL, c.opts.BackoffMin
+	if hint != nil {
+		if hint.URL != "" {
+			url = hint.URL
+		}
+		delay = time.Duration(hint.DelayMS) * time.Millisecond
+	}
+	backoff := c.opts.BackoffMin
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		conn, err := c.connect(c.ctx, url)
+		if err == nil {
+			return conn
+		}
+		if errors.Is(err, ErrClosed) || c.ctx.Err() != nil {
+			return nil
+		}
+		// A hinted node may be gone; fall back to the configured URL
+		url = c.opts.URL
+		delay = backoff/2 + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		backoff = min(backoff*2, c.opts.BackoffMax)
+	}
+}
+
+func (c *Client) readLoop(conn *fastws.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(c.opts.ReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(c.opts.ReadTimeout))
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+		return conn.WriteControl(fastws.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	for {
+		msg, raw, err := c.read(conn)
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(c.opts.ReadTimeout))
+		c.dispatch(msg, raw)
+	}
+}
+
+// read reads one frame and decodes it through the negotiated codec
+func (c *Client) read(conn *fastws.Conn) (*ServerMessage, []byte, error) {
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil, nil, err
+	}
+	raw, err := c.codec.DecodeServer(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := &ServerMessage{}
+	if err := json.Unmarshal(raw, msg); err != nil {
+		return nil, nil, err
+	}
+	return msg, raw, nil
+}
+
+// dispatch resolves waiting requests and calls the handlers
+func (c *Client) dispatch(msg *ServerMessage, raw []byte) {
+	switch msg.Type {
+	case "joined":
+		c.resolve("join:"+msg.Room, msg, raw)
+	case "left":
+		c.resolve("leave:"+msg.Room, msg, raw)
+	case "reconnect":
+		hint := &reconnectHint{}
+		json.Unmarshal(msg.Payload, hint)
+		c.mu.Lock()
+		c.hint = hint
+		c.mu.Unlock()
+	}
+	if msg.ID != "" {
+		c.rejoined(msg, raw)
+		c.resolve(msg.ID, msg, raw)
+	}
+
+	if v, ok := c.handlers.Load(msg.Type); ok {
+		for _, h := range v.([]Handler) {
+			h(msg, raw)
+		}
+	}
+	if p := c.any.Load(); p != nil {
+		for _, h := range *p {
+			h(msg, raw)
+		}
+	}
+}
+
+// rejoined settles a rejoin after a reconnect. A room the gateway now
+// refuses is forgotten, so it is not retried on every reconnect; the error
+// frame still goes to the handlers.
+func (c *Client) rejoined(msg *ServerMessage, raw []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	room, ok := c.rejoins[msg.ID]
+	if !ok {
+		return
+	}
+	delete(c.rejoins, msg.ID)
+	if msg.Type != "error" {
+		return
+	}
+	switch frameError(msg, raw).Code {
+	case "FORBIDDEN_ROOM", "INVALID_ROOM":
+		delete(c.rooms, room)
+	}
+}
+
+// Send sends a message; the gateway forwards unknown types to NATS
+// according to its routing table. payload is marshalled to JSON unless it
+// is already a json.RawMessage or nil.
+func (c *Client) Send(msgType, room string, payload interface{}) error {
+	msg := &ClientMessage{Type: msgType, Room: room}
+	if payload != nil {
+		data, ok := payload.(json.RawMessage)
+		if !ok {
+			var err error
+			if data, err = json.Marshal(payload); err != nil {
+				return err
+			}
+		}
+		msg.Payload = data
+	}
+	return c.SendMessage(msg)
+}
+
+// SendMessage sends a frame as it is
+func (c *Client) SendMessage(msg *ClientMessage) error {
+	c.mu.Lock()
+	conn, closed := c.conn, c.closed
+	c.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return c.write(conn, msg)
+}
+
+// Typing sends a typing indicator to a room
+func (c *Client) Typing(room string) error {
+	return c.SendMessage(&ClientMessage{Type: "typing", Room: room})
+}
+
+// Ping sends an application-level ping; the "pong" frame goes to handlers
+func (c *Client) Ping() error {
+	return c.SendMessage(&ClientMessage{Type: "ping"})
+}
+
+func (c *Client) write(conn *fastws.Conn, msg *ClientMessage) error {
+	data, err := c.codec.EncodeClient(msg)
+	if err != nil {
+		return err
+	}
+	kind := fastws.TextMessage
+	if c.codec.Binary() {
+		kind = fastws.BinaryMessage
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(c.opts.RequestTimeout))
+	return conn.WriteMessage(kind, data)
+}
+
+// id returns a request ID unique within the client
+func (c *Client) id() string {
+	return "c" + strconv.FormatUint(c.nextID.Add(1), 10)
+}
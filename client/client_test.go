@@ -0,0 +1,302 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/auth"
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/attchat/attchat-gateway/internal/server"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testGateway is a gateway node on a loopback listener, without NATS
+type testGateway struct {
+	url     string
+	manager *room.Manager
+	key     string // PEM private key its tokens are signed with
+}
+
+func startGateway(t *testing.T) *testGateway {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GATEWAY_JWT_PUBLIC_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})))
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RPC.Methods = []config.RPCMethod{{Name: "echo", Subject: "rpc.echo"}}
+
+	manager := room.NewManager()
+	srv, err := server.New(config.NewLive(cfg), manager, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	return &testGateway{
+		url:     "ws://" + ln.Addr().String() + "/ws",
+		manager: manager,
+		key:     string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+	}
+}
+
+// token signs a token for a user of brand b1
+func (g *testGateway) token(t *testing.T, userID uint, role string) string {
+	t.Helper()
+	token, err := auth.GenerateToken(g.key, &auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "attchat",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		UserID:  userID,
+		BrandID: "b1",
+		Role:    role,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func (g *testGateway) dial(t *testing.T, opts Options) *Client {
+	t.Helper()
+	opts.URL = g.url
+	opts.RequestTimeout = 5 * time.Second
+	c, err := Dial(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// frames returns a channel receiving c's frames of one type
+func frames(c *Client, msgType string) <-chan *ServerMessage {
+	ch := make(chan *ServerMessage, 16)
+	c.On(msgType, func(msg *ServerMessage, raw []byte) {
+		select {
+		case ch <- msg:
+		default:
+		}
+	})
+	return ch
+}
+
+func next(t *testing.T, ch <-chan *ServerMessage) *ServerMessage {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no frame")
+		return nil
+	}
+}
+
+// eventually polls cond until it holds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func gatewayError(t *testing.T, err error, code string) {
+	t.Helper()
+	var gwErr *Error
+	if !errors.As(err, &gwErr) || gwErr.Code != code {
+		t.Errorf("err = %v, want %s", err, code)
+	}
+}
+
+func TestDialJoinSend(t *testing.T) {
+	g := startGateway(t)
+	ctx := context.Background()
+
+	for _, proto := range []string{JSON, MsgPack, Proto} {
+		t.Run(proto, func(t *testing.T) {
+			alice := g.dial(t, Options{Token: g.token(t, 7, ""), Protocol: proto})
+			bob := g.dial(t, Options{Token: g.token(t, 8, ""), Protocol: proto})
+			if w := alice.Welcome(); w.ConnID == "" || w.UserID != "7" || w.BrandID != "b1" || w.Protocol != proto {
+				t.Errorf("welcome = %+v", w)
+			}
+
+			if err := alice.Join(ctx, "chat:1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := bob.Join(ctx, "chat:1"); err != nil {
+				t.Fatal(err)
+			}
+			if n := g.manager.RoomSize("b1/chat:1"); n != 2 {
+				t.Errorf("room has %d members, want 2", n)
+			}
+			gatewayError(t, alice.Join(ctx, `chat:1","x":"`), "INVALID_ROOM")
+			gatewayError(t, alice.Join(ctx, "b2/chat:1"), "FORBIDDEN_ROOM")
+			if rooms := alice.Rooms(); len(rooms) != 1 || rooms[0] != "chat:1" {
+				t.Errorf("Rooms = %v", rooms)
+			}
+
+			// Typing goes from client to client through the gateway
+			typing := frames(bob, "typing")
+			if err := alice.Typing("chat:1"); err != nil {
+				t.Fatal(err)
+			}
+			msg := next(t, typing)
+			var who struct {
+				UserID string `json:"user_id"`
+			}
+			json.Unmarshal(msg.Payload, &who)
+			if msg.Room != "chat:1" || who.UserID != "7" {
+				t.Errorf("typing = %+v", msg)
+			}
+
+			// Events reach both members, as if consumed from NATS
+			aliceMsgs, bobMsgs := frames(alice, "message"), frames(bob, "message")
+			g.manager.BroadcastToRoom("b1", "chat:1", []byte(`{"type":"message","room":"chat:1","payload":{"text":"hi"}}`), "")
+			for _, ch := range []<-chan *ServerMessage{aliceMsgs, bobMsgs} {
+				if msg := next(t, ch); string(msg.Payload) != `{"text":"hi"}` {
+					t.Errorf("message payload = %s", msg.Payload)
+				}
+			}
+
+			pongs := frames(alice, "pong")
+			if err := alice.Ping(); err != nil {
+				t.Fatal(err)
+			}
+			next(t, pongs)
+
+			if err := bob.Leave(ctx, "chat:1"); err != nil {
+				t.Fatal(err)
+			}
+			if n := g.manager.RoomSize("b1/chat:1"); n != 1 {
+				t.Errorf("room has %d members after leave, want 1", n)
+			}
+			alice.Leave(ctx, "chat:1")
+		})
+	}
+}
+
+func TestCall(t *testing.T) {
+	g := startGateway(t)
+	c := g.dial(t, Options{Token: g.token(t, 7, "")})
+	ctx := context.Background()
+
+	// Without NATS a configured method is unavailable
+	var resp json.RawMessage
+	gatewayError(t, c.Call(ctx, "echo", map[string]string{"q": "x"}, &resp), "UNAVAILABLE")
+	gatewayError(t, c.Call(ctx, "nope", nil, nil), "UNKNOWN_METHOD")
+
+	// Concurrent calls are matched to their replies by ID
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func(i int) { errs <- c.Call(ctx, fmt.Sprint("m", i), nil, nil) }(i)
+	}
+	for i := 0; i < cap(errs); i++ {
+		gatewayError(t, <-errs, "UNKNOWN_METHOD")
+	}
+}
+
+// After a drop the client reconnects and rejoins its rooms; a room the
+// gateway no longer allows is forgotten
+func TestReconnectRejoin(t *testing.T) {
+	g := startGateway(t)
+	ctx := context.Background()
+
+	// The first token is a platform admin's, later ones are not
+	var dials atomic.Int32
+	admin, member := g.token(t, 7, "platform_admin"), g.token(t, 7, "")
+	reconnected := make(chan Welcome, 1)
+	c := g.dial(t, Options{
+		TokenSource: func(context.Context) (string, error) {
+			if dials.Add(1) == 1 {
+				return admin, nil
+			}
+			return member, nil
+		},
+		BackoffMin: 10 * time.Millisecond,
+		OnConnect: func(w Welcome, again bool) {
+			if again {
+				reconnected <- w
+			}
+		},
+	})
+	if err := c.Join(ctx, "chat:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Join(ctx, "b2/chat:9"); err != nil {
+		t.Fatal(err)
+	}
+	first := c.Welcome().ConnID
+
+	refused := frames(c, "error")
+	g.manager.Disconnect(first, room.CloseGoingAway, "test")
+
+	var w Welcome
+	select {
+	case w = <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+	if w.ConnID == first {
+		t.Fatal("reconnected with the same connection ID")
+	}
+
+	if msg := next(t, refused); msg.Room != "b2/chat:9" {
+		t.Errorf("refused rejoin = %+v", msg)
+	}
+	eventually(t, "chat:1 rejoined", func() bool {
+		for _, conn := range g.manager.GetRoomConnections("b1/chat:1") {
+			if conn.ID == w.ConnID {
+				return true
+			}
+		}
+		return false
+	})
+	rooms := c.Rooms()
+	sort.Strings(rooms)
+	if fmt.Sprint(rooms) != "[chat:1]" {
+		t.Errorf("Rooms after refused rejoin = %v, want [chat:1]", rooms)
+	}
+	if n := g.manager.RoomSize("b2/chat:9"); n != 0 {
+		t.Errorf("b2/chat:9 has %d members", n)
+	}
+
+	// The new connection works
+	if err := c.Join(ctx, "chat:2"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/attchat/attchat-gateway/internal/brand"
+	"github.com/attchat/attchat-gateway/internal/protocol"
+)
+
+// Message types shared with the gateway
+type (
+	// ClientMessage is a frame sent to the gateway
+	ClientMessage = protocol.ClientMessage
+	// ServerMessage is a frame received from the gateway
+	ServerMessage = protocol.ServerMessage
+	// Settings are the brand settings of the connection (see Welcome)
+	Settings = brand.Settings
+)
+
+// Subprotocols the client can ask for
+const (
+	JSON    = protocol.JSONProtocol
+	MsgPack = protocol.MsgPackProtocol
+	Proto   = protocol.ProtoProtocol
+)
+
+var (
+	// ErrClosed is returned after Close
+	ErrClosed = errors.New("client: closed")
+	// ErrNotConnected is returned while the client is reconnecting
+	ErrNotConnected = errors.New("client: not connected")
+)
+
+// Welcome is the payload of the "connected" frame
+type Welcome struct {
+	ConnID    string    `json:"conn_id"`
+	Transport string    `json:"transport"`
+	Protocol  string    `json:"protocol"`
+	UserID    string    `json:"user_id"`
+	BrandID   string    `json:"brand_id"`
+	Role      string    `json:"role"`
+	UserType  string    `json:"user_type"`
+	Settings  *Settings `json:"settings"`
+}
+
+// Error is an error frame from the gateway, e.g. FORBIDDEN_ROOM, RATE_LIMITED
+// or an rpc_error
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return "gateway: " + e.Code + ": " + e.Message
+}
+
+// frameError decodes an error frame. Most carry {"code","message"} as
+// payload; a few have them at the top level.
+func frameError(msg *ServerMessage, raw []byte) *Error {
+	e := &Error{}
+	if len(msg.Payload) > 0 {
+		json.Unmarshal(msg.Payload, e)
+	}
+	if e.Code == "" {
+		json.Unmarshal(raw, e)
+	}
+	if e.Code == "" {
+		e.Code = "UNKNOWN"
+	}
+	return e
+}
+
+// reconnectHint is the payload of the "reconnect" frame a draining node sends
+type reconnectHint struct {
+	DelayMS int64  `json:"delay_ms"`
+	URL     string `json:"url"`
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const configUsage = `Usage:
+  gateway config check [--strict]          validate config.yaml and GATEWAY_* variables
+  gateway config print [--effective] [--json]
+                                           print the settings with secrets redacted`
+
+// runConfig implements "gateway config check|print" and returns the exit code
+func runConfig(args []string) int {
+	setupLogger()
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+	switch args[0] {
+	case "check":
+		return configCheck(args[1:])
+	case "print":
+		return configPrint(args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+}
+
+func configCheck(args []string) int {
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "treat unknown and deprecated keys as errors")
+	fs.Parse(args)
+
+	_, err := config.Load()
+	var invalid *config.ValidationError
+	if err != nil && !errors.As(err, &invalid) {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	warnings := config.Warnings()
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s: %s\n", w.Key, w.Message)
+	}
+	if invalid != nil {
+		for _, p := range invalid.Problems {
+			fmt.Fprintln(os.Stderr, "error:", p)
+		}
+		return 1
+	}
+	if *strict && len(warnings) > 0 {
+		return 1
+	}
+
+	source := viper.ConfigFileUsed()
+	if source == "" {
+		source = "defaults and environment"
+	}
+	fmt.Printf("configuration OK (%s)\n", source)
+	return 0
+}
+
+func configPrint(args []string) int {
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	effective := fs.Bool("effective", false, "merge defaults and GATEWAY_* variables into the file's settings")
+	asJSON := fs.Bool("json", false, "print JSON instead of YAML")
+	fs.Parse(args)
+
+	// Invalid settings are still printed, to help find them
+	var invalid *config.ValidationError
+	if _, err := config.Load(); err != nil && !errors.As(err, &invalid) {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	settings, err := config.Settings(*effective)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(settings)
+	} else {
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		err = enc.Encode(settings)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+)
+
+const connectHelp = `Commands:
+  /join <room>              join a room
+  /leave <room>             leave a room
+  /typing <room>            send a typing indicator
+  /send <type> [room] [json]  send a message (forwarded to NATS by the gateway)
+  /rpc <method> [json]      call an rpc.methods entry
+  /ping                     ping
+  /quit                     close the connection
+  {...}                     send a raw JSON frame`
+
+// runConnect is an interactive client: it authenticates, joins rooms and
+// prints every frame the gateway sends
+func runConnect(args []string) int {
+	fs := flag.NewFlagSet("connect", flag.ExitOnError)
+	url := fs.String("url", "ws://localhost:"+envOr("GATEWAY_SERVER_PORT", "8086")+"/ws", "WebSocket URL")
+	token := fs.String("token", os.Getenv("GATEWAY_TOKEN"), "JWT (default $GATEWAY_TOKEN), e.g. from gateway token mint")
+	rooms := fs.String("rooms", "", "comma-separated rooms to join after connecting")
+	origin := fs.String("origin", "", "Origin header, to test origins.allowed")
+	fs.Parse(args)
+
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "error: --token or GATEWAY_TOKEN is required")
+		return 2
+	}
+	header := http.Header{"Authorization": {"Bearer " + *token}}
+	if *origin != "" {
+		header.Set("Origin", *origin)
+	}
+
+	conn, resp, err := fastws.DefaultDialer.Dial(*url, header)
+	if err != nil {
+		if resp != nil {
+			fmt.Fprintf(os.Stderr, "error: %v (HTTP %d)\n", err, resp.StatusCode)
+		} else {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		return 1
+	}
+	defer conn.Close()
+	fmt.Fprintf(os.Stderr, "connected to %s; /help for commands\n", *url)
+
+	closed := make(chan int, 1)
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				if ce, ok := err.(*fastws.CloseError); ok {
+					fmt.Fprintf(os.Stderr, "closed: %d %s\n", ce.Code, ce.Text)
+				} else {
+					fmt.Fprintln(os.Stderr, "closed:", err)
+				}
+				closed <- 1
+				return
+			}
+			fmt.Printf("%s < %s\n", time.Now().Format("15:04:05.000"), data)
+		}
+	}()
+
+	send := func(frame map[string]interface{}) {
+		data, _ := json.Marshal(frame)
+		if err := conn.WriteMessage(fastws.TextMessage, data); err != nil {
+			fmt.Fprintln(os.Stderr, "send:", err)
+		}
+	}
+	for _, room := range strings.Split(*rooms, ",") {
+		if room = strings.TrimSpace(room); room != "" {
+			send(map[string]interface{}{"type": "join", "room": room})
+		}
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	for {
+		select {
+		case code := <-closed:
+			return code
+		case line, ok := <-lines:
+			if !ok || strings.TrimSpace(line) == "/quit" {
+				conn.WriteMessage(fastws.CloseMessage, fastws.FormatCloseMessage(fastws.CloseNormalClosure, ""))
+				select {
+				case <-closed:
+				case <-time.After(time.Second):
+				}
+				return 0
+			}
+			if frame, err := parseCommand(line); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			} else if frame != nil {
+				send(frame)
+			}
+		}
+	}
+}
+
+// parseCommand turns an input line into a client frame; nil means nothing to send
+func parseCommand(line string) (map[string]interface{}, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(line, "{") {
+		var frame map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &frame); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		return frame, nil
+	}
+
+	fields := strings.SplitN(line, " ", 2)
+	cmd, rest := fields[0], ""
+	if len(fields) > 1 {
+		rest = strings.TrimSpace(fields[1])
+	}
+	switch cmd {
+	case "/ping":
+		return map[string]interface{}{"type": "ping"}, nil
+	case "/join", "/leave", "/typing":
+		if rest == "" {
+			return nil, fmt.Errorf("usage: %s <room>", cmd)
+		}
+		return map[string]interface{}{"type": strings.TrimPrefix(cmd, "/"), "room": rest}, nil
+	case "/send":
+		// /send <type> [room] [json]
+		parts := strings.SplitN(rest, " ", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("usage: /send <type> [room] [json]")
+		}
+		frame := map[string]interface{}{"type": parts[0]}
+		if len(parts) > 1 {
+			rest = strings.TrimSpace(parts[1])
+			if !strings.HasPrefix(rest, "{") {
+				room, payload, _ := strings.Cut(rest, " ")
+				frame["room"], rest = room, strings.TrimSpace(payload)
+			}
+			if rest != "" {
+				frame["payload"] = json.RawMessage(rest)
+				if !json.Valid([]byte(rest)) {
+					return nil, fmt.Errorf("invalid JSON payload")
+				}
+			}
+		}
+		return frame, nil
+	case "/rpc":
+		method, payload, _ := strings.Cut(rest, " ")
+		if method == "" {
+			return nil, fmt.Errorf("usage: /rpc <method> [json]")
+		}
+		frame := map[string]interface{}{"type": "rpc", "id": fmt.Sprintf("cli-%d", time.Now().UnixNano()), "method": method}
+		if payload = strings.TrimSpace(payload); payload != "" {
+			if !json.Valid([]byte(payload)) {
+				return nil, fmt.Errorf("invalid JSON payload")
+			}
+			frame["payload"] = json.RawMessage(payload)
+		}
+		return frame, nil
+	case "/help":
+		fmt.Fprintln(os.Stderr, connectHelp)
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown command %s; /help for commands", cmd)
+	}
+}
@@ -0,0 +1,756 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/bits"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/attchat/attchat-gateway/client"
+	"github.com/attchat/attchat-gateway/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// loadgenEventType is the event type published to NATS and expected back on the clients
+const loadgenEventType = "loadgen"
+
+type loadgenOptions struct {
+	urls         []string
+	natsURL      string
+	subject      string
+	keyFile      string
+	issuer       string
+	protocol     string
+	conns        int
+	ramp         time.Duration
+	concurrency  int
+	brands       int
+	rooms        int
+	roomsPerConn int
+	dist         string
+	userType     string
+	userOffset   int
+	eventRate    float64
+	msgRate      float64
+	msgType      string
+	duration     time.Duration
+	drain        time.Duration
+	metricsURL   string
+	asJSON       bool
+}
+
+// runLoadgen opens many authenticated connections, publishes timestamped
+// events into NATS and reports connect success, delivery latency, drops
+// and memory per connection
+func runLoadgen(args []string) int {
+	setupLogger()
+	fs := flag.NewFlagSet("loadgen", flag.ExitOnError)
+	o := loadgenOptions{}
+	urls := fs.String("url", "ws://localhost:8086/ws", "gateway /ws URL; comma-separated to spread connections over nodes")
+	fs.StringVar(&o.natsURL, "nats", envOr("GATEWAY_NATS_URL", "nats://localhost:4222"), "NATS server the gateway consumes from")
+	fs.StringVar(&o.subject, "subject", "NOTIFY.{brand}.loadgen", "subject of the published events, within a gateway stream")
+	fs.StringVar(&o.keyFile, "key", envOr("GATEWAY_JWT_PRIVATE_KEY_FILE", "jwt_dev_private.pem"), "RSA private key (PEM) matching jwt.public_key")
+	fs.StringVar(&o.issuer, "issuer", "attchat", "iss; must be in jwt.allowed_issuers")
+	fs.StringVar(&o.protocol, "protocol", client.JSON, "subprotocol")
+	fs.IntVar(&o.conns, "conns", 1000, "connections to open")
+	fs.DurationVar(&o.ramp, "ramp", 10*time.Second, "time over which connections are opened")
+	fs.IntVar(&o.concurrency, "concurrency", 200, "connection attempts in flight")
+	fs.IntVar(&o.brands, "brands", 1, "brands the connections are spread over (loadgen-1, loadgen-2, ...)")
+	fs.IntVar(&o.rooms, "rooms", 100, "rooms per brand")
+	fs.IntVar(&o.roomsPerConn, "rooms-per-conn", 1, "rooms each connection joins")
+	fs.StringVar(&o.dist, "dist", "uniform", "room distribution: uniform or zipf (a few large rooms)")
+	fs.StringVar(&o.userType, "type", "customer", "user type of the tokens")
+	fs.IntVar(&o.userOffset, "user-offset", 1000000, "first user_id; give parallel runs distinct ranges")
+	fs.Float64Var(&o.eventRate, "event-rate", 100, "events per second published to NATS, each to one room")
+	fs.Float64Var(&o.msgRate, "msg-rate", 0, "client messages per second per connection")
+	fs.StringVar(&o.msgType, "msg-type", "message", "type of the client messages")
+	fs.DurationVar(&o.duration, "duration", 30*time.Second, "how long to publish once connections are open")
+	fs.DurationVar(&o.drain, "drain", 3*time.Second, "wait for deliveries after publishing stops")
+	fs.StringVar(&o.metricsURL, "metrics-url", "http://localhost:9090/metrics", "gateway metrics, for its memory per connection; empty to skip")
+	fs.BoolVar(&o.asJSON, "json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	o.urls = strings.Split(*urls, ",")
+	if o.conns <= 0 || o.brands <= 0 || o.rooms <= 0 || o.concurrency <= 0 {
+		fmt.Fprintln(os.Stderr, "error: --conns, --brands, --rooms and --concurrency must be positive")
+		return 2
+	}
+	o.roomsPerConn = min(max(o.roomsPerConn, 0), o.rooms)
+	if o.dist != "uniform" && o.dist != "zipf" {
+		fmt.Fprintln(os.Stderr, "error: --dist must be uniform or zipf")
+		return 2
+	}
+
+	key, err := os.ReadFile(o.keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	lg, err := newLoadgen(ctx, o, string(key))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	report := lg.run(ctx)
+	if o.asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		report.print(os.Stdout)
+	}
+	if report.Connect.OK == 0 {
+		return 1
+	}
+	return 0
+}
+
+// loadgenRoom is a room and the number of load connections in it
+type loadgenRoom struct {
+	brand   string
+	id      string
+	members atomic.Int64
+}
+
+type loadgen struct {
+	o          loadgenOptions
+	privateKey string
+	nc         *natsgo.Conn
+	rooms      []*loadgenRoom // brand-major: brands × rooms
+	runID      string
+
+	mu      sync.Mutex
+	clients []*client.Client
+	errors  map[string]int // connect failures by code
+
+	connectLatency histogram
+	eventLatency   histogram
+
+	connectOK, connectFailed atomic.Int64
+	joinOK, joinFailed       atomic.Int64
+	disconnects              atomic.Int64
+	published, publishFailed atomic.Int64
+	expected, received       atomic.Int64
+	msgSent, msgFailed       atomic.Int64
+	rejected                 atomic.Int64
+}
+
+func newLoadgen(ctx context.Context, o loadgenOptions, privateKey string) (*loadgen, error) {
+	lg := &loadgen{
+		o:          o,
+		privateKey: privateKey,
+		errors:     map[string]int{},
+		runID:      strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	for b := 1; b <= o.brands; b++ {
+		for r := 1; r <= o.rooms; r++ {
+			lg.rooms = append(lg.rooms, &loadgenRoom{
+				brand: "loadgen-" + strconv.Itoa(b),
+				id:    "loadgen:" + strconv.Itoa(r),
+			})
+		}
+	}
+
+	// Check the token first: a wrong key would fail every connection
+	if _, err := lg.token(o.userOffset); err != nil {
+		return nil, fmt.Errorf("sign token: %w", err)
+	}
+
+	if o.eventRate > 0 {
+		nc, err := natsgo.Connect(o.natsURL, natsgo.Name("attchat-loadgen"))
+		if err != nil {
+			return nil, fmt.Errorf("connect to NATS: %w", err)
+		}
+		// Events outside a stream would silently never reach the gateway
+		js, err := jetstream.New(nc)
+		if err == nil {
+			_, err = js.StreamNameBySubject(ctx, lg.subject("loadgen-1"))
+		}
+		if err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("no stream for %s (is the gateway running against %s?): %w", lg.subject("loadgen-1"), o.natsURL, err)
+		}
+		lg.nc = nc
+	}
+	return lg, nil
+}
+
+func (lg *loadgen) subject(brand string) string {
+	return strings.ReplaceAll(lg.o.subject, "{brand}", brand)
+}
+
+func (lg *loadgen) token(userID int) (string, error) {
+	now := time.Now()
+	return auth.GenerateToken(lg.privateKey, &auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    lg.o.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(24 * time.Hour)),
+		},
+		UserID:  uint(userID),
+		BrandID: lg.rooms[(userID-lg.o.userOffset)%lg.o.brands*lg.o.rooms].brand,
+		Type:    lg.o.userType,
+	})
+}
+
+// run ramps up, publishes for the configured duration, waits for the last
+// deliveries and reports. Cancelling ctx skips to the report.
+func (lg *loadgen) run(ctx context.Context) *loadgenReport {
+	rssBefore := lg.gatewayRSS()
+	heapBefore := loadgenHeap()
+
+	progressDone := make(chan struct{})
+	go lg.progress(ctx, progressDone)
+
+	rampStart := time.Now()
+	lg.rampUp(ctx)
+	rampTook := time.Since(rampStart)
+
+	rssAfter := lg.gatewayRSS()
+	heapAfter := loadgenHeap()
+
+	var steady time.Duration
+	if ctx.Err() == nil {
+		steadyCtx, cancel := context.WithTimeout(ctx, lg.o.duration)
+		start := time.Now()
+		var wg sync.WaitGroup
+		if lg.nc != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lg.publish(steadyCtx)
+			}()
+		}
+		if lg.o.msgRate > 0 {
+			lg.mu.Lock()
+			clients := lg.clients
+			lg.mu.Unlock()
+			for i, c := range clients {
+				wg.Add(1)
+				go func(i int, c *client.Client) {
+					defer wg.Done()
+					lg.sendMessages(steadyCtx, i, c)
+				}(i, c)
+			}
+		}
+		wg.Wait()
+		cancel()
+		steady = time.Since(start)
+		if lg.nc != nil {
+			lg.nc.Flush()
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(lg.o.drain):
+		}
+	}
+	close(progressDone)
+
+	report := lg.report(rampTook, steady)
+	if open := report.Connect.OK; open > 0 {
+		if rssBefore > 0 && rssAfter > 0 {
+			report.Memory.GatewayBytesPerConn = (rssAfter - rssBefore) / open
+			report.Memory.GatewayRSS = rssAfter
+		}
+		report.Memory.LoadgenBytesPerConn = (heapAfter - heapBefore) / open
+	}
+
+	lg.mu.Lock()
+	clients := lg.clients
+	lg.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *client.Client) {
+			defer wg.Done()
+			c.Close()
+		}(c)
+	}
+	wg.Wait()
+	if lg.nc != nil {
+		lg.nc.Close()
+	}
+	return report
+}
+
+// rampUp opens the connections at an even pace over the ramp, with at
+// most concurrency attempts in flight
+func (lg *loadgen) rampUp(ctx context.Context) {
+	interval := lg.o.ramp / time.Duration(lg.o.conns)
+	sem := make(chan struct{}, lg.o.concurrency)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var zipf *rand.Zipf
+	if lg.o.dist == "zipf" && lg.o.rooms > 1 {
+		zipf = rand.NewZipf(rnd, 1.1, 1, uint64(lg.o.rooms-1))
+	}
+
+	var wg sync.WaitGroup
+	next := time.Now()
+	for i := 0; i < lg.o.conns && ctx.Err() == nil; i++ {
+		// Rooms are picked here so the random source stays single-threaded
+		brand := i % lg.o.brands
+		picked := map[int]bool{}
+		for len(picked) < lg.o.roomsPerConn {
+			r := rnd.Intn(lg.o.rooms)
+			if zipf != nil {
+				r = int(zipf.Uint64())
+			} else if lg.o.roomsPerConn == 1 {
+				r = i / lg.o.brands % lg.o.rooms
+			}
+			picked[r] = true
+		}
+		rooms := make([]*loadgenRoom, 0, len(picked))
+		for r := range picked {
+			rooms = append(rooms, lg.rooms[brand*lg.o.rooms+r])
+		}
+
+		if d := time.Until(next); d > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(d):
+			}
+		}
+		next = next.Add(interval)
+		select {
+		case <-ctx.Done():
+			continue
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(i int, rooms []*loadgenRoom) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			lg.open(ctx, i, rooms)
+		}(i, rooms)
+	}
+	wg.Wait()
+}
+
+// open dials one connection and joins its rooms
+func (lg *loadgen) open(ctx context.Context, i int, rooms []*loadgenRoom) {
+	userID := lg.o.userOffset + i
+
+	// Rooms count a member from the join until the connection drops
+	var mu sync.Mutex
+	var joined []*loadgenRoom
+	dropped := false
+
+	// Signed up front so connect latency is the gateway's alone
+	token, err := lg.token(userID)
+	if err != nil {
+		lg.connectFailed.Add(1)
+		lg.mu.Lock()
+		lg.errors["TOKEN"]++
+		lg.mu.Unlock()
+		return
+	}
+	start := time.Now()
+	c, err := client.Dial(ctx, client.Options{
+		URL:         lg.o.urls[i%len(lg.o.urls)],
+		Token:       token,
+		Protocol:    lg.o.protocol,
+		NoReconnect: true,
+		OnDisconnect: func(error) {
+			lg.disconnects.Add(1)
+			mu.Lock()
+			defer mu.Unlock()
+			dropped = true
+			for _, r := range joined {
+				r.members.Add(-1)
+			}
+		},
+	})
+	if err != nil {
+		lg.connectFailed.Add(1)
+		code := "DIAL"
+		var gwErr *client.Error
+		if errors.As(err, &gwErr) {
+			code = gwErr.Code
+		} else if ctx.Err() != nil {
+			code = "CANCELLED"
+		}
+		lg.mu.Lock()
+		lg.errors[code]++
+		lg.mu.Unlock()
+		return
+	}
+	lg.connectLatency.record(time.Since(start))
+	lg.connectOK.Add(1)
+
+	c.On(loadgenEventType, func(msg *client.ServerMessage, raw []byte) {
+		var p struct {
+			SentAt int64 `json:"sent_at"`
+		}
+		if json.Unmarshal(msg.Payload, &p) == nil && p.SentAt > 0 {
+			lg.eventLatency.record(time.Duration(time.Now().UnixNano() - p.SentAt))
+			lg.received.Add(1)
+		}
+	})
+	c.On("error", func(msg *client.ServerMessage, raw []byte) {
+		lg.rejected.Add(1)
+	})
+
+	for _, r := range rooms {
+		if err := c.Join(ctx, r.id); err != nil {
+			lg.joinFailed.Add(1)
+			continue
+		}
+		lg.joinOK.Add(1)
+		mu.Lock()
+		if !dropped {
+			joined = append(joined, r)
+			r.members.Add(1)
+		}
+		mu.Unlock()
+	}
+
+	lg.mu.Lock()
+	lg.clients = append(lg.clients, c)
+	lg.mu.Unlock()
+}
+
+// publish sends events at the configured rate, each to a random room that
+// has members, and counts the deliveries the members should receive
+func (lg *loadgen) publish(ctx context.Context) {
+	const tick = 10 * time.Millisecond
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	perTick, owed := lg.o.eventRate*tick.Seconds(), 0.0
+	var seq int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		owed += perTick
+		for ; owed >= 1; owed-- {
+			r := lg.rooms[rnd.Intn(len(lg.rooms))]
+			members := r.members.Load()
+			if members <= 0 {
+				continue
+			}
+			seq++
+			data, _ := json.Marshal(map[string]interface{}{
+				"id":        "loadgen-" + lg.runID + "-" + strconv.FormatInt(seq, 10),
+				"type":      loadgenEventType,
+				"room":      r.id,
+				"brand_id":  r.brand,
+				"payload":   map[string]int64{"sent_at": time.Now().UnixNano(), "seq": seq},
+				"timestamp": time.Now(),
+			})
+			if err := lg.nc.Publish(lg.subject(r.brand), data); err != nil {
+				lg.publishFailed.Add(1)
+				continue
+			}
+			lg.published.Add(1)
+			lg.expected.Add(members)
+		}
+	}
+}
+
+// sendMessages sends client messages at msg-rate to the connection's first room
+func (lg *loadgen) sendMessages(ctx context.Context, i int, c *client.Client) {
+	rooms := c.Rooms()
+	if len(rooms) == 0 {
+		return
+	}
+	interval := time.Duration(float64(time.Second) / lg.o.msgRate)
+	// Spread the connections over the first interval
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Duration(rand.Int63n(int64(interval) + 1))):
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Send(lg.o.msgType, rooms[0], map[string]interface{}{"text": "loadgen", "sent_at": time.Now().UnixNano()}); err != nil {
+			lg.msgFailed.Add(1)
+		} else {
+			lg.msgSent.Add(1)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// progress prints a status line to stderr every few seconds
+func (lg *loadgen) progress(ctx context.Context, done <-chan struct{}) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		fmt.Fprintf(os.Stderr, "conns %d ok / %d failed, events %d, received %d/%d, p99 %s\n",
+			lg.connectOK.Load(), lg.connectFailed.Load(), lg.published.Load(),
+			lg.received.Load(), lg.expected.Load(), fmtDuration(lg.eventLatency.percentile(0.99)))
+	}
+}
+
+// gatewayRSS reads process_resident_memory_bytes from the gateway's
+// metrics; 0 when unavailable
+func (lg *loadgen) gatewayRSS() int64 {
+	if lg.o.metricsURL == "" {
+		return 0
+	}
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Get(lg.o.metricsURL)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "process_resident_memory_bytes "); ok {
+			v, _ := strconv.ParseFloat(value, 64)
+			return int64(v)
+		}
+	}
+	return 0
+}
+
+// loadgenHeap is the memory held by this process after a collection
+func loadgenHeap() int64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapInuse + m.StackInuse)
+}
+
+type loadgenReport struct {
+	Conns    int    `json:"conns"`
+	Rooms    int    `json:"rooms"`
+	Dist     string `json:"dist"`
+	Protocol string `json:"protocol"`
+	Ramp     string `json:"ramp"`
+	Duration string `json:"duration"`
+	Connect  struct {
+		OK      int64          `json:"ok"`
+		Failed  int64          `json:"failed"`
+		Errors  map[string]int `json:"errors,omitempty"`
+		Latency latencySummary `json:"latency"`
+	} `json:"connect"`
+	Joins struct {
+		OK     int64 `json:"ok"`
+		Failed int64 `json:"failed"`
+	} `json:"joins"`
+	Events struct {
+		Published     int64          `json:"published"`
+		PublishFailed int64          `json:"publish_failed"`
+		Rate          float64        `json:"rate"`
+		Expected      int64          `json:"expected"`
+		Received      int64          `json:"received"`
+		Dropped       int64          `json:"dropped"`
+		DropRate      float64        `json:"drop_rate"`
+		Latency       latencySummary `json:"latency"`
+	} `json:"events"`
+	Messages struct {
+		Sent     int64 `json:"sent"`
+		Failed   int64 `json:"failed"`
+		Rejected int64 `json:"rejected"`
+	} `json:"messages"`
+	Disconnects int64 `json:"disconnects"`
+	Memory      struct {
+		GatewayRSS          int64 `json:"gateway_rss,omitempty"`
+		GatewayBytesPerConn int64 `json:"gateway_bytes_per_conn,omitempty"`
+		LoadgenBytesPerConn int64 `json:"loadgen_bytes_per_conn"`
+	} `json:"memory"`
+}
+
+type latencySummary struct {
+	Count int64  `json:"count"`
+	P50   string `json:"p50"`
+	P90   string `json:"p90"`
+	P99   string `json:"p99"`
+	P999  string `json:"p999"`
+	Max   string `json:"max"`
+}
+
+func (lg *loadgen) report(ramp, steady time.Duration) *loadgenReport {
+	r := &loadgenReport{
+		Conns:    lg.o.conns,
+		Rooms:    len(lg.rooms),
+		Dist:     lg.o.dist,
+		Protocol: lg.o.protocol,
+		Ramp:     ramp.Round(time.Millisecond).String(),
+		Duration: steady.Round(time.Millisecond).String(),
+	}
+	r.Connect.OK = lg.connectOK.Load()
+	r.Connect.Failed = lg.connectFailed.Load()
+	lg.mu.Lock()
+	if len(lg.errors) > 0 {
+		r.Connect.Errors = lg.errors
+	}
+	lg.mu.Unlock()
+	r.Connect.Latency = lg.connectLatency.summary()
+	r.Joins.OK = lg.joinOK.Load()
+	r.Joins.Failed = lg.joinFailed.Load()
+
+	r.Events.Published = lg.published.Load()
+	r.Events.PublishFailed = lg.publishFailed.Load()
+	if steady > 0 {
+		r.Events.Rate = float64(r.Events.Published) / steady.Seconds()
+	}
+	r.Events.Expected = lg.expected.Load()
+	r.Events.Received = lg.received.Load()
+	// Members that dropped after an event was published make this an upper bound
+	r.Events.Dropped = max(r.Events.Expected-r.Events.Received, 0)
+	if r.Events.Expected > 0 {
+		r.Events.DropRate = float64(r.Events.Dropped) / float64(r.Events.Expected)
+	}
+	r.Events.Latency = lg.eventLatency.summary()
+
+	r.Messages.Sent = lg.msgSent.Load()
+	r.Messages.Failed = lg.msgFailed.Load()
+	r.Messages.Rejected = lg.rejected.Load()
+	r.Disconnects = lg.disconnects.Load()
+	return r
+}
+
+func (r *loadgenReport) print(w io.Writer) {
+	fmt.Fprintf(w, "connections: %d ok, %d failed of %d (ramp %s)\n", r.Connect.OK, r.Connect.Failed, r.Conns, r.Ramp)
+	codes := make([]string, 0, len(r.Connect.Errors))
+	for code := range r.Connect.Errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "  %-20s %d\n", code, r.Connect.Errors[code])
+	}
+	fmt.Fprintf(w, "connect:     %s\n", r.Connect.Latency)
+	fmt.Fprintf(w, "joins:       %d ok, %d failed (%d rooms, %s)\n", r.Joins.OK, r.Joins.Failed, r.Rooms, r.Dist)
+	fmt.Fprintf(w, "events:      %d published (%.1f/s over %s), %d failed\n", r.Events.Published, r.Events.Rate, r.Duration, r.Events.PublishFailed)
+	fmt.Fprintf(w, "deliveries:  %d received of %d expected, %d dropped (%.3f%%)\n",
+		r.Events.Received, r.Events.Expected, r.Events.Dropped, r.Events.DropRate*100)
+	fmt.Fprintf(w, "latency:     %s\n", r.Events.Latency)
+	if r.Messages.Sent+r.Messages.Failed > 0 {
+		fmt.Fprintf(w, "messages:    %d sent, %d failed, %d error frames\n", r.Messages.Sent, r.Messages.Failed, r.Messages.Rejected)
+	}
+	fmt.Fprintf(w, "disconnects: %d\n", r.Disconnects)
+	if r.Memory.GatewayBytesPerConn > 0 {
+		fmt.Fprintf(w, "memory:      gateway %s/conn (RSS %s), loadgen %s/conn\n",
+			fmtBytes(r.Memory.GatewayBytesPerConn), fmtBytes(r.Memory.GatewayRSS), fmtBytes(r.Memory.LoadgenBytesPerConn))
+	} else {
+		fmt.Fprintf(w, "memory:      loadgen %s/conn (gateway metrics unavailable)\n", fmtBytes(r.Memory.LoadgenBytesPerConn))
+	}
+}
+
+func (s latencySummary) String() string {
+	if s.Count == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("p50 %s  p90 %s  p99 %s  p99.9 %s  max %s", s.P50, s.P90, s.P99, s.P999, s.Max)
+}
+
+func fmtDuration(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return strconv.FormatInt(d.Microseconds(), 10) + "µs"
+	case d < time.Second:
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64) + "ms"
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+func fmtBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return strconv.FormatFloat(float64(n)/(1<<20), 'f', 1, 64) + " MiB"
+	case n >= 1<<10:
+		return strconv.FormatFloat(float64(n)/(1<<10), 'f', 1, 64) + " KiB"
+	}
+	return strconv.FormatInt(n, 10) + " B"
+}
+
+// histogram counts durations in microseconds in log-linear buckets: 8 per
+// power of two, so percentiles are within 12.5%. Safe for concurrent use.
+type histogram struct {
+	counts [64 * 8]atomic.Int64
+	n, max atomic.Int64
+}
+
+func (h *histogram) record(d time.Duration) {
+	us := max(d.Microseconds(), 0)
+	h.counts[histogramBucket(us)].Add(1)
+	h.n.Add(1)
+	for {
+		m := h.max.Load()
+		if us <= m || h.max.CompareAndSwap(m, us) {
+			break
+		}
+	}
+}
+
+// percentile returns the upper bound of the bucket holding quantile q
+func (h *histogram) percentile(q float64) time.Duration {
+	n := h.n.Load()
+	if n == 0 {
+		return 0
+	}
+	rank, seen := int64(float64(n)*q+0.5), int64(0)
+	for i := range h.counts {
+		if seen += h.counts[i].Load(); seen >= max(rank, 1) {
+			return time.Duration(min(histogramUpper(i), h.max.Load())) * time.Microsecond
+		}
+	}
+	return time.Duration(h.max.Load()) * time.Microsecond
+}
+
+func (h *histogram) summary() latencySummary {
+	return latencySummary{
+		Count: h.n.Load(),
+		P50:   fmtDuration(h.percentile(0.5)),
+		P90:   fmtDuration(h.percentile(0.9)),
+		P99:   fmtDuration(h.percentile(0.99)),
+		P999:  fmtDuration(h.percentile(0.999)),
+		Max:   fmtDuration(time.Duration(h.max.Load()) * time.Microsecond),
+	}
+}
+
+// histogramBucket: values below 8 have their own bucket; above, the
+// bucket is the power of two and the next three bits
+func histogramBucket(v int64) int {
+	if v < 8 {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - 4
+	return (shift+1)*8 + int(v>>shift)&7
+}
+
+func histogramUpper(i int) int64 {
+	if i < 8 {
+		return int64(i)
+	}
+	shift := i/8 - 1
+	return (int64(8+i%8+1) << shift) - 1
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// runStats queries a running node and prints its connection, room and
+// probe state; with an admin token it also lists the largest rooms
+func runStats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	url := fs.String("url", localURL(), "base URL of the node")
+	adminToken := fs.String("admin-token", os.Getenv("GATEWAY_ADMIN_TOKEN"), "admin token, to list the largest rooms")
+	adminPrefix := fs.String("admin-prefix", "/admin", "admin.path_prefix of the node")
+	top := fs.Int("top", 10, "number of rooms to list")
+	asJSON := fs.Bool("json", false, "print the raw JSON responses")
+	fs.Parse(args)
+	base := strings.TrimRight(*url, "/")
+
+	var stats map[string]int64
+	if _, err := getJSON(base+"/stats", "", &stats); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	var ready, live probeResult
+	readyCode, _ := getJSON(base+"/ready", "", &ready)
+	liveCode, _ := getJSON(base+"/live", "", &live)
+
+	var rooms struct {
+		Total int `json:"total"`
+		Rooms []struct {
+			ID      string `json:"id"`
+			Members int    `json:"members"`
+		} `json:"rooms"`
+	}
+	var roomsErr error
+	if *adminToken != "" {
+		_, roomsErr = getJSON(fmt.Sprintf("%s%s/rooms?limit=%d", base, *adminPrefix, *top), *adminToken, &rooms)
+	}
+
+	if *asJSON {
+		out := map[string]interface{}{"stats": stats, "ready": ready, "live": live}
+		if *adminToken != "" && roomsErr == nil {
+			out["rooms"] = rooms
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+		return 0
+	}
+
+	fmt.Printf("node:        %s\n", base)
+	fmt.Printf("connections: %d current, %d since start\n", stats["current_connections"], stats["total_connections"])
+	fmt.Printf("rooms:       %d\n", stats["total_rooms"])
+	printProbe("ready", readyCode, ready)
+	printProbe("live", liveCode, live)
+	switch {
+	case roomsErr != nil:
+		fmt.Fprintln(os.Stderr, "rooms:", roomsErr)
+	case *adminToken != "":
+		fmt.Printf("largest rooms (%d total):\n", rooms.Total)
+		for _, r := range rooms.Rooms {
+			fmt.Printf("  %6d  %s\n", r.Members, r.ID)
+		}
+	}
+	return 0
+}
+
+// runHealth exits 0 when the node's liveness probe passes; used by the
+// Docker HEALTHCHECK, where no curl is available
+func runHealth(args []string) int {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	url := fs.String("url", localURL(), "base URL of the node")
+	probe := fs.String("probe", "live", "live or ready")
+	fs.Parse(args)
+
+	var result probeResult
+	code, err := getJSON(strings.TrimRight(*url, "/")+"/"+*probe, "", &result)
+	if err != nil && code == 0 {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	printProbe(*probe, code, result)
+	if code != http.StatusOK {
+		return 1
+	}
+	return 0
+}
+
+type probeResult struct {
+	Status     string `json:"status"`
+	Components map[string]struct {
+		Status string `json:"status"`
+		Detail string `json:"detail"`
+	} `json:"components"`
+}
+
+func printProbe(name string, code int, r probeResult) {
+	if code == 0 {
+		fmt.Printf("%-12s unreachable\n", name+":")
+		return
+	}
+	fmt.Printf("%-12s %s (HTTP %d)\n", name+":", r.Status, code)
+	for component, cs := range r.Components {
+		if cs.Status != "ok" {
+			fmt.Printf("  %s: %s %s\n", component, cs.Status, cs.Detail)
+		}
+	}
+}
+
+// getJSON fetches url into v and returns the HTTP status; non-2xx bodies
+// are still decoded (probes describe failures in JSON)
+func getJSON(url, bearer string, v interface{}) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return resp.StatusCode, fmt.Errorf("%s: HTTP %d: %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusServiceUnavailable {
+		return resp.StatusCode, fmt.Errorf("%s: HTTP %d", url, resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// localURL is the node on this host, from GATEWAY_SERVER_PORT
+func localURL() string {
+	return "http://localhost:" + envOr("GATEWAY_SERVER_PORT", "8086")
+}
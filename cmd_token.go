@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/auth"
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const tokenUsage = `Usage:
+  gateway token mint --key private.pem --user 7 [--brand b1] [--type cskh] [--ttl 1h] ...
+  gateway token inspect [--key public.pem] <token|->`
+
+// runToken implements "gateway token mint|inspect"
+func runToken(args []string) int {
+	setupLogger()
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, tokenUsage)
+		return 2
+	}
+	switch args[0] {
+	case "mint":
+		return tokenMint(args[1:])
+	case "inspect":
+		return tokenInspect(args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, tokenUsage)
+		return 2
+	}
+}
+
+// tokenMint signs a token for development; the gateway only needs the
+// matching public key
+func tokenMint(args []string) int {
+	fs := flag.NewFlagSet("token mint", flag.ExitOnError)
+	keyFile := fs.String("key", envOr("GATEWAY_JWT_PRIVATE_KEY_FILE", "jwt_dev_private.pem"), "RSA private key (PEM)")
+	userID := fs.Uint("user", 0, "user_id (required)")
+	username := fs.String("username", "", "username")
+	brandID := fs.String("brand", "", "brand_id")
+	role := fs.String("role", "", "role, e.g. platform_admin")
+	userType := fs.String("type", "", "user type: cskh or customer")
+	rooms := fs.String("rooms", "", "comma-separated rooms to join on connect")
+	issuer := fs.String("issuer", "attchat", "iss; must be in jwt.allowed_issuers")
+	ttl := fs.Duration("ttl", time.Hour, "lifetime; 0 = no exp")
+	fs.Parse(args)
+
+	if *userID == 0 {
+		fmt.Fprintln(os.Stderr, "error: --user is required")
+		return 2
+	}
+	key, err := os.ReadFile(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	now := time.Now()
+	claims := &auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   *issuer,
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+		UserID:   *userID,
+		Username: *username,
+		BrandID:  *brandID,
+		Role:     *role,
+		Type:     *userType,
+	}
+	if *ttl > 0 {
+		claims.ExpiresAt = jwt.NewNumericDate(now.Add(*ttl))
+	}
+	if *rooms != "" {
+		claims.Rooms = strings.Split(*rooms, ",")
+	}
+
+	token, err := auth.GenerateToken(string(key), claims)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: sign token:", err)
+		return 1
+	}
+	fmt.Println(token)
+	return 0
+}
+
+// tokenInspect prints a token's header and claims, then validates it with
+// the configured key and checks, or with --key
+func tokenInspect(args []string) int {
+	fs := flag.NewFlagSet("token inspect", flag.ExitOnError)
+	keyFile := fs.String("key", "", "RSA public key (PEM); default: the configured jwt.public_key")
+	fs.Parse(args)
+
+	token := fs.Arg(0)
+	if token == "" || token == "-" {
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		token = line
+	}
+	token = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(token), "Bearer "))
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		fmt.Fprintln(os.Stderr, "error: not a JWT (expected header.payload.signature)")
+		return 1
+	}
+	for i, name := range []string{"header", "claims"} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s is not base64url: %v\n", name, err)
+			return 1
+		}
+		var v map[string]interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s is not JSON: %v\n", name, err)
+			return 1
+		}
+		pretty, _ := json.MarshalIndent(v, "", "  ")
+		fmt.Printf("%s: %s\n", name, pretty)
+		if name == "claims" {
+			printTimes(v)
+		}
+	}
+
+	cfg, err := inspectConfig(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	validator, err := auth.NewJWTValidator(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	claims, err := validator.Validate(token)
+	if err != nil {
+		fmt.Println("INVALID:", err)
+		switch {
+		case errors.Is(err, auth.ErrExpiredToken):
+			fmt.Println("  mint a new one: gateway token mint --user ...")
+		case strings.Contains(err.Error(), "issuer"):
+			fmt.Printf("  allowed issuers: %s\n", strings.Join(cfg.AllowedIssuers, ", "))
+		case strings.Contains(err.Error(), "signature"):
+			fmt.Println("  the token was signed with another key than the configured public key")
+		}
+		return 1
+	}
+	fmt.Printf("VALID: user_id=%d brand_id=%s type=%s role=%s\n", claims.UserID, claims.BrandID, claims.Type, claims.Role)
+	return 0
+}
+
+// inspectConfig returns the configured JWT checks, with the key from keyFile if set
+func inspectConfig(keyFile string) (config.JWTConfig, error) {
+	cfg, err := config.Load()
+	var invalid *config.ValidationError
+	if err != nil && !(errors.As(err, &invalid) && keyFile != "") {
+		return config.JWTConfig{}, err
+	}
+	jwtCfg := config.JWTConfig{ValidateExp: true, AllowedIssuers: []string{"attchat"}}
+	if cfg != nil {
+		jwtCfg = cfg.JWT
+	}
+	if keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return config.JWTConfig{}, err
+		}
+		jwtCfg.PublicKeyPEM = string(key)
+	}
+	return jwtCfg, nil
+}
+
+// printTimes shows exp, iat and nbf relative to now
+func printTimes(claims map[string]interface{}) {
+	for _, name := range []string{"iat", "nbf", "exp"} {
+		n, ok := claims[name].(float64)
+		if !ok {
+			continue
+		}
+		t := time.Unix(int64(n), 0)
+		rel := time.Until(t).Round(time.Second)
+		when := "in " + rel.String()
+		if rel < 0 {
+			when = (-rel).String() + " ago"
+		}
+		fmt.Printf("  %s: %s (%s)\n", name, t.Format(time.RFC3339), when)
+	}
+}
+
+// envOr returns an environment variable or a default
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/attchat/attchat-gateway/internal/config"
@@ -18,8 +19,21 @@ var (
 	ErrExpiredToken  = errors.New("token expired")
 	ErrInvalidClaims = errors.New("invalid claims")
 	ErrMissingUserID = errors.New("missing user_id in token")
+	ErrInvalidBrand  = errors.New("invalid brand_id")
 )
 
+func init() {
+	config.RegisterCheck(func(c *config.Config) error {
+		if c.JWT.PublicKeyPEM == "" {
+			return nil // reported by Validate
+		}
+		if _, err := newJWTKeys(c.JWT); err != nil {
+			return fmt.Errorf("jwt.public_key: %w", err)
+		}
+		return nil
+	})
+}
+
 // Claims represents JWT claims for ATTChat
 type Claims struct {
 	jwt.RegisteredClaims
@@ -33,8 +47,13 @@ type Claims struct {
 	Type         string   `json:"type,omitempty"` // "cskh" or "customer"
 }
 
-// JWTValidator validates JWT tokens
+// JWTValidator validates JWT tokens. The key and checks are swapped as a
+// whole on config reload.
 type JWTValidator struct {
+	keys atomic.Pointer[jwtKeys]
+}
+
+type jwtKeys struct {
 	publicKey      *rsa.PublicKey
 	validateExp    bool
 	allowedIssuers []string
@@ -42,6 +61,16 @@ type JWTValidator struct {
 
 // NewJWTValidator creates a new JWT validator
 func NewJWTValidator(cfg config.JWTConfig) (*JWTValidator, error) {
+	k, err := newJWTKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	v := &JWTValidator{}
+	v.keys.Store(k)
+	return v, nil
+}
+
+func newJWTKeys(cfg config.JWTConfig) (*jwtKeys, error) {
 	if cfg.PublicKeyPEM == "" {
 		return nil, fmt.Errorf("jwt.public_key is required (RS256 only)")
 	}
@@ -49,21 +78,31 @@ func NewJWTValidator(cfg config.JWTConfig) (*JWTValidator, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse JWT public key: %w", err)
 	}
-	return &JWTValidator{
+	return &jwtKeys{
 		publicKey:      pk,
 		validateExp:    cfg.ValidateExp,
 		allowedIssuers: cfg.AllowedIssuers,
 	}, nil
 }
 
+// Reload parses the key of a new configuration (config.Reloader)
+func (v *JWTValidator) Reload(next *config.Config) (func(), error) {
+	k, err := newJWTKeys(next.JWT)
+	if err != nil {
+		return nil, err
+	}
+	return func() { v.keys.Store(k) }, nil
+}
+
 // Validate validates a JWT token and returns claims
 func (v *JWTValidator) Validate(tokenString string) (*Claims, error) {
+	k := v.keys.Load()
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		// Validate signing method
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return v.publicKey, nil
+		return k.publicKey, nil
 	})
 
 	if err != nil {
@@ -83,17 +122,22 @@ func (v *JWTValidator) Validate(tokenString string) (*Claims, error) {
 		return nil, ErrMissingUserID
 	}
 
+	// "/" separates brand and room in brand-qualified room IDs
+	if !ValidBrandID(claims.BrandID) {
+		return nil, ErrInvalidBrand
+	}
+
 	// Validate expiration
-	if v.validateExp {
+	if k.validateExp {
 		if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(time.Now()) {
 			return nil, ErrExpiredToken
 		}
 	}
 
 	// Validate issuer
-	if len(v.allowedIssuers) > 0 {
+	if len(k.allowedIssuers) > 0 {
 		issuerValid := false
-		for _, iss := range v.allowedIssuers {
+		for _, iss := range k.allowedIssuers {
 			if claims.Issuer == iss {
 				issuerValid = true
 				break
@@ -107,6 +151,12 @@ func (v *JWTValidator) Validate(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
+// ValidBrandID reports whether id can be used as a brand: it must not
+// contain "/", which would make its rooms look like another brand's
+func ValidBrandID(id string) bool {
+	return !strings.Contains(id, "/")
+}
+
 // GenerateToken generates a JWT token (for testing purposes)
 func GenerateToken(secretKey string, claims *Claims) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
@@ -115,10 +165,18 @@ func GenerateToken(secretKey string, claims *Claims) (string, error) {
 	if block == nil {
 		return "", fmt.Errorf("failed to decode private key PEM")
 	}
-	pk, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	// PKCS1 (BEGIN RSA PRIVATE KEY) or PKCS8 (BEGIN PRIVATE KEY)
+	if pk, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return token.SignedString(pk)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
 		return "", err
 	}
+	pk, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("not RSA private key")
+	}
 	return token.SignedString(pk)
 }
 
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testKeys returns a PEM private key and a validator for its public key
+func testKeys(t *testing.T) (string, *JWTValidator) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewJWTValidator(config.JWTConfig{
+		PublicKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})),
+		ValidateExp:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return string(priv), v
+}
+
+func TestValidateBrandID(t *testing.T) {
+	priv, v := testKeys(t)
+	exp := jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	tests := []struct {
+		brand string
+		want  error
+	}{
+		{"", nil},
+		{"b1", nil},
+		{"brand-1:vn", nil},
+		{"b1/chat:1", ErrInvalidBrand},
+		{"/", ErrInvalidBrand},
+	}
+	for _, tt := range tests {
+		token, err := GenerateToken(priv, &Claims{
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: exp},
+			UserID:           7,
+			BrandID:          tt.brand,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := v.Validate(token)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("brand_id %q: err = %v, want %v", tt.brand, err, tt.want)
+		}
+		if err == nil && claims.BrandID != tt.brand {
+			t.Errorf("brand_id %q: claims.BrandID = %q", tt.brand, claims.BrandID)
+		}
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	priv, v := testKeys(t)
+	otherPriv, _ := testKeys(t)
+
+	sign := func(key string, claims *Claims) string {
+		token, err := GenerateToken(key, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+	expired := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour))}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"other key", sign(otherPriv, &Claims{RegisteredClaims: valid, UserID: 7}), ErrInvalidToken},
+		{"expired", sign(priv, &Claims{RegisteredClaims: expired, UserID: 7}), ErrExpiredToken},
+		{"no user", sign(priv, &Claims{RegisteredClaims: valid}), ErrMissingUserID},
+		{"garbage", "not.a.token", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		if _, err := v.Validate(tt.token); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package brand
+
+import (
+	"path"
+)
+
+// Settings are the effective per-connection settings of a brand: the
+// global defaults with the brand's overlays applied
+type Settings struct {
+	MaxTabs      int             `json:"max_tabs"`                // connections per user, 0 = unlimited
+	Typing       bool            `json:"typing"`                  // typing indicators are relayed
+	AllowedTypes []string        `json:"allowed_types,omitempty"` // client message types forwarded to NATS, empty = all
+	Features     map[string]bool `json:"features,omitempty"`      // flags for clients and backends, e.g. history_replay
+}
+
+// AllowsType reports whether a client message type may be forwarded.
+// Patterns use * and ? wildcards like routing.routes.
+func (s *Settings) AllowsType(msgType string) bool {
+	if len(s.AllowedTypes) == 0 {
+		return true
+	}
+	for _, pattern := range s.AllowedTypes {
+		if ok, _ := path.Match(pattern, msgType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Feature reports whether a feature flag is on
+func (s *Settings) Feature(name string) bool {
+	return s.Features[name]
+}
+
+// Overlay overrides some settings for one brand; unset fields keep the
+// value from the layer below
+type Overlay struct {
+	MaxTabs      *int            `json:"max_tabs,omitempty" yaml:"max_tabs"`
+	Typing       *bool           `json:"typing,omitempty" yaml:"typing"`
+	AllowedTypes []string        `json:"allowed_types,omitempty" yaml:"allowed_types"`
+	Features     map[string]bool `json:"features,omitempty" yaml:"features"`
+}
+
+// validate checks the values and patterns of an overlay
+func (o *Overlay) validate() error {
+	if o.MaxTabs != nil && *o.MaxTabs < 0 {
+		return errNegativeMaxTabs
+	}
+	for _, pattern := range o.AllowedTypes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply returns s with the overlay's fields set over it
+func (o *Overlay) apply(s Settings) Settings {
+	if o == nil {
+		return s
+	}
+	if o.MaxTabs != nil {
+		s.MaxTabs = *o.MaxTabs
+	}
+	if o.Typing != nil {
+		s.Typing = *o.Typing
+	}
+	if o.AllowedTypes != nil {
+		s.AllowedTypes = o.AllowedTypes
+	}
+	if len(o.Features) > 0 {
+		features := make(map[string]bool, len(s.Features)+len(o.Features))
+		for k, v := range s.Features {
+			features[k] = v
+		}
+		for k, v := range o.Features {
+			features[k] = v
+		}
+		s.Features = features
+	}
+	return s
+}
@@ -0,0 +1,240 @@
+package brand
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// fileDebounce coalesces the burst of events an editor or a ConfigMap
+// update produces into one reload
+const fileDebounce = 200 * time.Millisecond
+
+var errNegativeMaxTabs = errors.New("max_tabs must not be negative")
+
+// Store resolves brand settings from the global defaults, an overlay file
+// and a KV bucket (highest priority), and reports brands whose effective
+// settings change while running
+type Store struct {
+	defaults Settings
+	file     string
+
+	mu           sync.RWMutex
+	fileOverlays map[string]*Overlay
+	kvOverlays   map[string]*Overlay
+	resolved     map[string]*Settings // brands with an overlay
+	onChange     func(brandID string, settings *Settings)
+}
+
+// overlayFile is the layout of brands.file (YAML or JSON)
+type overlayFile struct {
+	Brands map[string]*Overlay `yaml:"brands"`
+}
+
+// NewStore creates a store and loads the overlay file, if any
+func NewStore(defaults Settings, file string) (*Store, error) {
+	s := &Store{
+		defaults:     defaults,
+		file:         file,
+		fileOverlays: make(map[string]*Overlay),
+		kvOverlays:   make(map[string]*Overlay),
+		resolved:     make(map[string]*Settings),
+	}
+	if file != "" {
+		overlays, err := loadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		s.fileOverlays = overlays
+		for brandID := range overlays {
+			s.resolved[brandID] = s.resolve(brandID)
+		}
+		log.Info().Str("file", file).Int("brands", len(overlays)).Msg("Loaded brand overlays")
+	}
+	return s, nil
+}
+
+// OnChange registers fn to be called with a brand's new settings whenever
+// an overlay change alters them. Call it before watching.
+func (s *Store) OnChange(fn func(brandID string, settings *Settings)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
+
+// Resolve returns the effective settings of a brand. The result is shared
+// and must not be modified.
+func (s *Store) Resolve(brandID string) *Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if settings, ok := s.resolved[brandID]; ok {
+		return settings
+	}
+	return &s.defaults
+}
+
+// resolve merges the layers of a brand; callers hold mu
+func (s *Store) resolve(brandID string) *Settings {
+	fileOverlay, inFile := s.fileOverlays[brandID]
+	kvOverlay, inKV := s.kvOverlays[brandID]
+	if !inFile && !inKV {
+		return &s.defaults
+	}
+	settings := kvOverlay.apply(fileOverlay.apply(s.defaults))
+	return &settings
+}
+
+// SetKV applies an entry of the overlay bucket (key = brand ID, value =
+// JSON overlay); a nil value removes the brand's overlay
+func (s *Store) SetKV(brandID string, value []byte) {
+	var overlay *Overlay
+	if value != nil {
+		overlay = &Overlay{}
+		err := json.Unmarshal(value, overlay)
+		if err == nil {
+			err = overlay.validate()
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("brand_id", brandID).Msg("Ignoring invalid brand overlay from KV")
+			return
+		}
+	}
+
+	s.update(func() {
+		if overlay == nil {
+			delete(s.kvOverlays, brandID)
+		} else {
+			s.kvOverlays[brandID] = overlay
+		}
+	}, []string{brandID})
+}
+
+// update changes the overlays and notifies about brands whose settings changed
+func (s *Store) update(change func(), brands []string) {
+	type changed struct {
+		brandID  string
+		settings *Settings
+	}
+	var notify []changed
+
+	s.mu.Lock()
+	change()
+	for _, brandID := range brands {
+		old, ok := s.resolved[brandID]
+		if !ok {
+			old = &s.defaults
+		}
+		settings := s.resolve(brandID)
+		if settings == &s.defaults {
+			delete(s.resolved, brandID)
+		} else {
+			s.resolved[brandID] = settings
+		}
+		if !reflect.DeepEqual(old, settings) {
+			notify = append(notify, changed{brandID, settings})
+		}
+	}
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	for _, c := range notify {
+		log.Info().Str("brand_id", c.brandID).Msg("Brand settings changed")
+		if onChange != nil {
+			onChange(c.brandID, c.settings)
+		}
+	}
+}
+
+// WatchFile reloads the overlay file whenever its directory changes. An
+// invalid file is logged and the previous overlays are kept.
+func (s *Store) WatchFile() error {
+	if s.file == "" {
+		return nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// Watch the directory: editors and ConfigMaps replace the file
+	if err := w.Add(filepath.Dir(s.file)); err != nil {
+		w.Close()
+		return err
+	}
+
+	go func() {
+		defer w.Close()
+		var reload <-chan time.Time
+		for {
+			select {
+			case _, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				reload = time.After(fileDebounce)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Warn().Err(err).Str("file", s.file).Msg("Brand overlay watch error")
+			case <-reload:
+				reload = nil
+				s.reloadFile()
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Store) reloadFile() {
+	overlays, err := loadFile(s.file)
+	if err != nil {
+		log.Warn().Err(err).Str("file", s.file).Msg("Invalid brand overlay file, keeping previous overlays")
+		return
+	}
+
+	s.mu.RLock()
+	brands := make([]string, 0, len(overlays)+len(s.fileOverlays))
+	for brandID := range s.fileOverlays {
+		if _, ok := overlays[brandID]; !ok {
+			brands = append(brands, brandID)
+		}
+	}
+	s.mu.RUnlock()
+	for brandID := range overlays {
+		brands = append(brands, brandID)
+	}
+
+	s.update(func() { s.fileOverlays = overlays }, brands)
+}
+
+func loadFile(file string) (map[string]*Overlay, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// YAML is a superset of JSON, so both formats parse here
+	var f overlayFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	overlays := make(map[string]*Overlay, len(f.Brands))
+	for brandID, overlay := range f.Brands {
+		if overlay == nil {
+			continue
+		}
+		if err := overlay.validate(); err != nil {
+			return nil, fmt.Errorf("brand %s: %w", brandID, err)
+		}
+		overlays[brandID] = overlay
+	}
+	return overlays, nil
+}
@@ -7,15 +7,33 @@ import (
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server  ServerConfig
-	JWT     JWTConfig
-	NATS    NATSConfig
-	Metrics MetricsConfig
-	WS      WebSocketConfig
+	Log       LogConfig
+	Server    ServerConfig
+	JWT       JWTConfig
+	NATS      NATSConfig
+	Metrics   MetricsConfig
+	WS        WebSocketConfig
+	RPC       RPCConfig
+	Routing   RoutingConfig
+	Health    HealthConfig
+	Shutdown  ShutdownConfig
+	Admin     AdminConfig
+	Publish   PublishConfig
+	Fallback  FallbackConfig
+	RateLimit RateLimitConfig
+	Admission AdmissionConfig
+	Origins   OriginsConfig
+	Tenancy   TenancyConfig
+	Brands    BrandsConfig
+}
+
+type LogConfig struct {
+	Level string // debug, info, warn or error
 }
 
 type ServerConfig struct {
@@ -37,6 +55,17 @@ type NATSConfig struct {
 	ReconnectWait time.Duration
 	MaxReconnects int
 	Streams       []string
+	DedupWindow   time.Duration
+	ExposeHeaders bool
+	BrandToken    int // 1-based subject token holding the brand ID of incoming events, 0 = off
+	Outbox        OutboxConfig
+}
+
+// OutboxConfig limits the buffer of publishes made while NATS is unreachable
+type OutboxConfig struct {
+	MaxMessages int
+	MaxBytes    int
+	Path        string // optional file to persist the buffer across restarts
 }
 
 type MetricsConfig struct {
@@ -53,28 +82,181 @@ type WebSocketConfig struct {
 	WriteBufferSize   int
 	EnableCompression bool
 	MaxMessageSize    int64
+	SequenceNumbers   bool // add a per-connection "seq" to every frame
+}
+
+type HealthConfig struct {
+	MaxConsumerLag   uint64        // pending messages before a stream consumer counts as lagging
+	StallTimeout     time.Duration // a NATS message handled longer than this marks the node wedged
+	LivenessInterval time.Duration // watchdog heartbeat; missing 3 beats fails liveness
+}
+
+type ShutdownConfig struct {
+	Timeout           time.Duration // overall deadline for the shutdown sequence
+	DrainGrace        time.Duration // not-ready before the first batch, for load balancers to notice
+	DrainWindow       time.Duration // connections are closed in batches over this window
+	BatchInterval     time.Duration
+	ReconnectDelayMin time.Duration // clients are told to reconnect after a random delay in [min, max]
+	ReconnectDelayMax time.Duration
+	ReconnectURL      string // optional node URL suggested to clients
+}
+
+type AdminConfig struct {
+	Enabled    bool
+	Port       string // separate listener; empty mounts the API on the main server
+	PathPrefix string
+	Tokens     []string // accepted bearer tokens
+}
+
+type PublishConfig struct {
+	Enabled        bool
+	Mode           string // "stream" (publish to JetStream) or "local" (deliver on this node)
+	DefaultSubject string // used when an event has no subject; {brand} is the event's brand
+	MaxEvents      int
+	Services       []PublishService
+}
+
+// RateLimitConfig limits client messages with token buckets
+type RateLimitConfig struct {
+	Enabled         bool
+	Rules           []RateLimitRule // per connection; the most specific matching rule applies
+	UserRate        float64         // messages/s across all of a user's connections, 0 = unlimited
+	UserBurst       int
+	MaxViolations   int           // rejected messages within ViolationWindow before disconnecting, 0 = never
+	ViolationWindow time.Duration // window for MaxViolations
+}
+
+// RateLimitRule is a token bucket for one message type and user type
+type RateLimitRule struct {
+	Type     string  `mapstructure:"type"`      // message type or "*"
+	UserType string  `mapstructure:"user_type"` // JWT type claim; empty matches any
+	Rate     float64 `mapstructure:"rate"`      // messages per second
+	Burst    int     `mapstructure:"burst"`
+}
+
+// AdmissionConfig limits who may open connections; checks run before the
+// WebSocket upgrade and before SSE/poll sessions are opened
+type AdmissionConfig struct {
+	MaxPerIP          int           // connections per client IP, 0 = unlimited
+	TrustedProxies    []string      // IPs or CIDRs whose X-Forwarded-For is trusted
+	MaxPerUser        int           // connections (tabs) per user, 0 = unlimited
+	UserPolicy        string        // "reject_new" or "evict_oldest" once MaxPerUser is reached
+	BrandQuotas       []BrandQuota  // per-brand connection limits
+	DefaultBrandQuota int           // limit for brands without a quota, 0 = unlimited
+	QuotaBucket       string        // optional JetStream KV bucket of brand quotas, overrides BrandQuotas
+	RetryAfter        time.Duration // Retry-After sent with 429/503
+}
+
+// BrandQuota caps the connections of one brand
+type BrandQuota struct {
+	Brand string `mapstructure:"brand"`
+	Max   int    `mapstructure:"max"`
+}
+
+// OriginsConfig lists the browser origins allowed to connect (checked at
+// the WebSocket upgrade and SSE/poll/send) and drives the CORS middleware.
+// Entries are scheme://host[:port], optionally with a leading "*." wildcard.
+type OriginsConfig struct {
+	Allowed          []string       // origins for every brand; "*" allows any
+	Brands           []BrandOrigins // extra origins allowed only for one brand's clients
+	AllowMissing     bool           // accept requests without an Origin header (native apps, servers)
+	AllowCredentials bool           // CORS Access-Control-Allow-Credentials; requires an explicit list
+}
+
+// BrandOrigins are the origins of one brand's web apps
+type BrandOrigins struct {
+	Brand   string   `mapstructure:"brand"`
+	Origins []string `mapstructure:"origins"`
+}
+
+// TenancyConfig controls brand isolation
+type TenancyConfig struct {
+	AdminRoles []string // JWT roles that may join and publish to any brand's rooms
 }
 
+// BrandsConfig locates the per-brand settings overlays
+type BrandsConfig struct {
+	File   string // YAML/JSON file of overlays, reloaded on change
+	Bucket string // JetStream KV bucket: key = brand ID, value = JSON overlay
+}
+
+// FallbackConfig controls the SSE and long-poll transports
+type FallbackConfig struct {
+	Enabled        bool
+	PollTimeout    time.Duration // how long a poll waits for the first frame
+	SessionTimeout time.Duration // poll sessions without a poll for this long are closed
+	MaxBatch       int           // frames returned by one poll
+}
+
+// PublishService is a backend allowed to use the HTTP publish endpoint
+type PublishService struct {
+	Name string `mapstructure:"name"`
+	Key  string `mapstructure:"key"`
+}
+
+type RPCConfig struct {
+	Timeout     time.Duration
+	MaxInFlight int // RPCs a connection may have waiting for a reply
+	Methods     []RPCMethod
+}
+
+// RPCMethod maps a client RPC method to a NATS request subject
+type RPCMethod struct {
+	Name         string        `mapstructure:"name"`
+	Subject      string        `mapstructure:"subject"`
+	AllowedTypes []string      `mapstructure:"allowed_types"` // empty = any user type
+	Timeout      time.Duration `mapstructure:"timeout"`       // 0 = rpc.timeout
+}
+
+type RoutingConfig struct {
+	Routes []Route
+}
+
+// Route maps client message types to a NATS subject template.
+// Type supports * and ? wildcards; Subject may use {brand}, {room}, {user}, {type} and {stream}.
+type Route struct {
+	Type    string `mapstructure:"type"`
+	Subject string `mapstructure:"subject"`
+}
+
+// devPublicKeyFiles are tried when no JWT public key is configured
+var devPublicKeyFiles = []string{"jwt_dev_public.pem", "./attchat-gateway-websocket/jwt_dev_public.pem"}
+
+// Load sets up viper and reads the configuration
 func Load() (*Config, error) {
 	// Load local env file if present (for dev)
 	_ = godotenv.Load("env.local")
 	_ = godotenv.Load("./attchat-gateway-websocket/env.local")
 
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("./config")
+	// Set defaults
+	setDefaults()
+	configure(viper.GetViper())
+
+	return read(viper.GetViper())
+}
+
+// configure points v at config.yaml, the GATEWAY_* variables and the
+// defaults
+func configure(v *viper.Viper) {
+	v.SetConfigName("config")
+	v.SetConfigType("yaml")
+	v.AddConfigPath(".")
+	v.AddConfigPath("./config")
 
 	// Environment variable overrides
-	viper.SetEnvPrefix("GATEWAY")
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	viper.AutomaticEnv()
+	v.SetEnvPrefix("GATEWAY")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
 
-	// Set defaults
-	setDefaults()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+}
 
+// read reads config.yaml and the environment into a new Config
+func read(v *viper.Viper) (*Config, error) {
 	// Read config file (optional)
-	if err := viper.ReadInConfig(); err != nil {
+	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
 			return nil, err
 		}
@@ -82,114 +264,308 @@ func Load() (*Config, error) {
 	}
 
 	cfg := &Config{
+		Log: LogConfig{
+			Level: strings.ToLower(v.GetString("log.level")),
+		},
 		Server: ServerConfig{
-			Port:         viper.GetString("server.port"),
-			ReadTimeout:  viper.GetDuration("server.read_timeout"),
-			WriteTimeout: viper.GetDuration("server.write_timeout"),
+			Port:         v.GetString("server.port"),
+			ReadTimeout:  v.GetDuration("server.read_timeout"),
+			WriteTimeout: v.GetDuration("server.write_timeout"),
 		},
 		JWT: JWTConfig{
-			PublicKeyPEM:   viper.GetString("jwt.public_key"),
-			ValidateExp:    viper.GetBool("jwt.validate_exp"),
-			AllowedIssuers: viper.GetStringSlice("jwt.allowed_issuers"),
+			PublicKeyPEM:   v.GetString("jwt.public_key"),
+			ValidateExp:    v.GetBool("jwt.validate_exp"),
+			AllowedIssuers: v.GetStringSlice("jwt.allowed_issuers"),
 		},
 		NATS: NATSConfig{
-			URL:           viper.GetString("nats.url"),
-			ClusterID:     viper.GetString("nats.cluster_id"),
-			ClientID:      viper.GetString("nats.client_id"),
-			ReconnectWait: viper.GetDuration("nats.reconnect_wait"),
-			MaxReconnects: viper.GetInt("nats.max_reconnects"),
-			Streams:       viper.GetStringSlice("nats.streams"),
+			URL:           v.GetString("nats.url"),
+			ClusterID:     v.GetString("nats.cluster_id"),
+			ClientID:      v.GetString("nats.client_id"),
+			ReconnectWait: v.GetDuration("nats.reconnect_wait"),
+			MaxReconnects: v.GetInt("nats.max_reconnects"),
+			Streams:       v.GetStringSlice("nats.streams"),
+			DedupWindow:   v.GetDuration("nats.dedup_window"),
+			ExposeHeaders: v.GetBool("nats.expose_headers"),
+			BrandToken:    v.GetInt("nats.subject_brand_token"),
+			Outbox: OutboxConfig{
+				MaxMessages: v.GetInt("nats.outbox.max_messages"),
+				MaxBytes:    v.GetInt("nats.outbox.max_bytes"),
+				Path:        v.GetString("nats.outbox.path"),
+			},
 		},
 		Metrics: MetricsConfig{
-			Port:    viper.GetString("metrics.port"),
-			Enabled: viper.GetBool("metrics.enabled"),
+			Port:    v.GetString("metrics.port"),
+			Enabled: v.GetBool("metrics.enabled"),
 		},
 		WS: WebSocketConfig{
-			MaxConnections:    viper.GetInt("ws.max_connections"),
-			PingInterval:      viper.GetDuration("ws.ping_interval"),
-			PongTimeout:       viper.GetDuration("ws.pong_timeout"),
-			WriteTimeout:      viper.GetDuration("ws.write_timeout"),
-			ReadBufferSize:    viper.GetInt("ws.read_buffer_size"),
-			WriteBufferSize:   viper.GetInt("ws.write_buffer_size"),
-			EnableCompression: viper.GetBool("ws.enable_compression"),
-			MaxMessageSize:    viper.GetInt64("ws.max_message_size"),
+			MaxConnections:    v.GetInt("ws.max_connections"),
+			PingInterval:      v.GetDuration("ws.ping_interval"),
+			PongTimeout:       v.GetDuration("ws.pong_timeout"),
+			WriteTimeout:      v.GetDuration("ws.write_timeout"),
+			ReadBufferSize:    v.GetInt("ws.read_buffer_size"),
+			WriteBufferSize:   v.GetInt("ws.write_buffer_size"),
+			EnableCompression: v.GetBool("ws.enable_compression"),
+			MaxMessageSize:    v.GetInt64("ws.max_message_size"),
+			SequenceNumbers:   v.GetBool("ws.sequence_numbers"),
 		},
 	}
 
-	// Allow loading public key from file if provided
-	if path := strings.TrimSpace(viper.GetString("jwt.public_key_file")); path != "" {
-		if data, err := os.ReadFile(path); err == nil {
-			fmt.Printf("[DEBUG] loaded jwt.public_key_file=%s len=%d\n", path, len(data))
-			cfg.JWT.PublicKeyPEM = string(data)
-		} else {
-			fmt.Printf("[DEBUG] failed to read jwt.public_key_file=%s err=%v\n", path, err)
-			return nil, err
-		}
+	cfg.Health = HealthConfig{
+		MaxConsumerLag:   v.GetUint64("health.max_consumer_lag"),
+		StallTimeout:     v.GetDuration("health.stall_timeout"),
+		LivenessInterval: v.GetDuration("health.liveness_interval"),
 	}
-	// Fallback: if still empty, try local defaults
-	if cfg.JWT.PublicKeyPEM == "" {
-		if data, err := os.ReadFile("jwt_dev_public.pem"); err == nil {
-			fmt.Printf("[DEBUG] loaded jwt_dev_public.pem from CWD len=%d\n", len(data))
-			cfg.JWT.PublicKeyPEM = string(data)
-		} else if data, err := os.ReadFile("./attchat-gateway-websocket/jwt_dev_public.pem"); err == nil {
-			fmt.Printf("[DEBUG] loaded ./attchat-gateway-websocket/jwt_dev_public.pem len=%d\n", len(data))
-			cfg.JWT.PublicKeyPEM = string(data)
-		} else {
-			fmt.Printf("[DEBUG] could not find jwt_dev_public.pem in fallback locations\n")
-		}
+
+	cfg.Shutdown = ShutdownConfig{
+		Timeout:           v.GetDuration("shutdown.timeout"),
+		DrainGrace:        v.GetDuration("shutdown.drain_grace"),
+		DrainWindow:       v.GetDuration("shutdown.drain_window"),
+		BatchInterval:     v.GetDuration("shutdown.batch_interval"),
+		ReconnectDelayMin: v.GetDuration("shutdown.reconnect_delay_min"),
+		ReconnectDelayMax: v.GetDuration("shutdown.reconnect_delay_max"),
+		ReconnectURL:      v.GetString("shutdown.reconnect_url"),
+	}
+
+	cfg.Admin = AdminConfig{
+		Enabled:    v.GetBool("admin.enabled"),
+		Port:       v.GetString("admin.port"),
+		PathPrefix: v.GetString("admin.path_prefix"),
+		Tokens:     v.GetStringSlice("admin.tokens"),
+	}
+
+	cfg.Publish = PublishConfig{
+		Enabled:        v.GetBool("publish.enabled"),
+		Mode:           v.GetString("publish.mode"),
+		DefaultSubject: v.GetString("publish.default_subject"),
+		MaxEvents:      v.GetInt("publish.max_events"),
+	}
+	if err := v.UnmarshalKey("publish.services", &cfg.Publish.Services); err != nil {
+		return nil, fmt.Errorf("invalid publish.services: %w", err)
+	}
+
+	cfg.Fallback = FallbackConfig{
+		Enabled:        v.GetBool("fallback.enabled"),
+		PollTimeout:    v.GetDuration("fallback.poll_timeout"),
+		SessionTimeout: v.GetDuration("fallback.session_timeout"),
+		MaxBatch:       v.GetInt("fallback.max_batch"),
 	}
 
-	if cwd, err := os.Getwd(); err == nil {
-		fmt.Printf("[DEBUG] cwd=%s\n", cwd)
+	cfg.RateLimit = RateLimitConfig{
+		Enabled:         v.GetBool("ratelimit.enabled"),
+		UserRate:        v.GetFloat64("ratelimit.user_rate"),
+		UserBurst:       v.GetInt("ratelimit.user_burst"),
+		MaxViolations:   v.GetInt("ratelimit.max_violations"),
+		ViolationWindow: v.GetDuration("ratelimit.violation_window"),
+	}
+	if err := v.UnmarshalKey("ratelimit.rules", &cfg.RateLimit.Rules); err != nil {
+		return nil, fmt.Errorf("invalid ratelimit.rules: %w", err)
+	}
+
+	cfg.Admission = AdmissionConfig{
+		MaxPerIP:          v.GetInt("admission.max_per_ip"),
+		TrustedProxies:    v.GetStringSlice("admission.trusted_proxies"),
+		MaxPerUser:        v.GetInt("admission.max_per_user"),
+		UserPolicy:        v.GetString("admission.user_policy"),
+		DefaultBrandQuota: v.GetInt("admission.default_brand_quota"),
+		QuotaBucket:       v.GetString("admission.quota_bucket"),
+		RetryAfter:        v.GetDuration("admission.retry_after"),
+	}
+	if err := v.UnmarshalKey("admission.brand_quotas", &cfg.Admission.BrandQuotas); err != nil {
+		return nil, fmt.Errorf("invalid admission.brand_quotas: %w", err)
+	}
+
+	cfg.Origins = OriginsConfig{
+		Allowed:          v.GetStringSlice("origins.allowed"),
+		AllowMissing:     v.GetBool("origins.allow_missing"),
+		AllowCredentials: v.GetBool("origins.allow_credentials"),
+	}
+	if err := v.UnmarshalKey("origins.brands", &cfg.Origins.Brands); err != nil {
+		return nil, fmt.Errorf("invalid origins.brands: %w", err)
+	}
+
+	cfg.Tenancy.AdminRoles = splitCommaList(v.GetStringSlice("tenancy.admin_roles"))
+
+	cfg.Brands = BrandsConfig{
+		File:   v.GetString("brands.file"),
+		Bucket: v.GetString("brands.bucket"),
+	}
+
+	cfg.RPC.Timeout = v.GetDuration("rpc.timeout")
+	cfg.RPC.MaxInFlight = v.GetInt("rpc.max_in_flight")
+	if err := v.UnmarshalKey("rpc.methods", &cfg.RPC.Methods); err != nil {
+		return nil, fmt.Errorf("invalid rpc.methods: %w", err)
+	}
+	if err := v.UnmarshalKey("routing.routes", &cfg.Routing.Routes); err != nil {
+		return nil, fmt.Errorf("invalid routing.routes: %w", err)
 	}
-	fmt.Printf("[DEBUG] final jwt.public_key length=%d\n", len(cfg.JWT.PublicKeyPEM))
 
-	// Normalize streams: support comma-separated env
-	if len(cfg.NATS.Streams) == 1 && strings.Contains(cfg.NATS.Streams[0], ",") {
-		parts := strings.Split(cfg.NATS.Streams[0], ",")
-		var cleaned []string
-		for _, p := range parts {
-			if s := strings.TrimSpace(p); s != "" {
-				cleaned = append(cleaned, s)
+	// Allow loading public key from file if provided
+	if path := strings.TrimSpace(v.GetString("jwt.public_key_file")); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("jwt.public_key_file: %w", err)
+		}
+		log.Debug().Str("file", path).Int("bytes", len(data)).Msg("Loaded JWT public key")
+		cfg.JWT.PublicKeyPEM = string(data)
+	}
+	// Fallback: if still empty, try the local development key
+	if cfg.JWT.PublicKeyPEM == "" {
+		for _, path := range devPublicKeyFiles {
+			if data, err := os.ReadFile(path); err == nil {
+				log.Warn().Str("file", path).Msg("Using development JWT public key, set jwt.public_key_file in production")
+				cfg.JWT.PublicKeyPEM = string(data)
+				break
 			}
 		}
-		cfg.NATS.Streams = cleaned
 	}
 
+	// Normalize lists: support comma-separated env
+	cfg.NATS.Streams = splitCommaList(cfg.NATS.Streams)
+	cfg.Admin.Tokens = splitCommaList(cfg.Admin.Tokens)
+	cfg.Admission.TrustedProxies = splitCommaList(cfg.Admission.TrustedProxies)
+	cfg.Origins.Allowed = splitCommaList(cfg.Origins.Allowed)
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
 func setDefaults() {
+	// Log defaults: LOG_LEVEL is still honoured
+	setDefault("log.level", "info")
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		setDefault("log.level", level)
+	}
+
 	// Server defaults
-	viper.SetDefault("server.port", "8086")
-	viper.SetDefault("server.read_timeout", "10s")
-	viper.SetDefault("server.write_timeout", "10s")
+	setDefault("server.port", "8086")
+	setDefault("server.read_timeout", "10s")
+	setDefault("server.write_timeout", "10s")
 
 	// JWT defaults
-	viper.SetDefault("jwt.public_key", "")
-	viper.SetDefault("jwt.public_key_file", "")
-	viper.SetDefault("jwt.validate_exp", true)
-	viper.SetDefault("jwt.allowed_issuers", []string{"attchat"})
+	setDefault("jwt.public_key", "")
+	setDefault("jwt.public_key_file", "")
+	setDefault("jwt.validate_exp", true)
+	setDefault("jwt.allowed_issuers", []string{"attchat"})
 
 	// NATS defaults
-	viper.SetDefault("nats.url", "nats://localhost:4222")
-	viper.SetDefault("nats.cluster_id", "attchat")
-	viper.SetDefault("nats.client_id", "gateway")
-	viper.SetDefault("nats.reconnect_wait", "2s")
-	viper.SetDefault("nats.max_reconnects", -1) // Unlimited
-	viper.SetDefault("nats.streams", []string{"CHAT", "NOTIFY", "ONLINE", "ANALYTICS", "AUDIT", "BILLING", "FILE", "EMAIL"})
+	setDefault("nats.url", "nats://localhost:4222")
+	setDefault("nats.cluster_id", "attchat")
+	setDefault("nats.client_id", "gateway")
+	setDefault("nats.reconnect_wait", "2s")
+	setDefault("nats.max_reconnects", -1) // Unlimited
+	setDefault("nats.streams", []string{"CHAT", "NOTIFY", "ONLINE", "ANALYTICS", "AUDIT", "BILLING", "FILE", "EMAIL"})
+	setDefault("nats.dedup_window", "2m")    // 0 disables consumer-side dedup
+	setDefault("nats.expose_headers", false) // surface trace/correlation headers to clients
+	setDefault("nats.subject_brand_token", 0)
+	setDefault("nats.outbox.max_messages", 10000)
+	setDefault("nats.outbox.max_bytes", 8*1024*1024) // 8MB
+	setDefault("nats.outbox.path", "")               // memory only
 
 	// Metrics defaults
-	viper.SetDefault("metrics.port", "9090")
-	viper.SetDefault("metrics.enabled", true)
+	setDefault("metrics.port", "9090")
+	setDefault("metrics.enabled", true)
 
 	// WebSocket defaults
-	viper.SetDefault("ws.max_connections", 10000)
-	viper.SetDefault("ws.ping_interval", "30s")
-	viper.SetDefault("ws.pong_timeout", "10s")
-	viper.SetDefault("ws.write_timeout", "10s")
-	viper.SetDefault("ws.read_buffer_size", 4096)
-	viper.SetDefault("ws.write_buffer_size", 4096)
-	viper.SetDefault("ws.enable_compression", false)
-	viper.SetDefault("ws.max_message_size", 65536) // 64KB
+	setDefault("ws.max_connections", 0) // unlimited
+	setDefault("ws.ping_interval", "30s")
+	setDefault("ws.pong_timeout", "10s")
+	setDefault("ws.write_timeout", "10s")
+	setDefault("ws.read_buffer_size", 4096)
+	setDefault("ws.write_buffer_size", 4096)
+	setDefault("ws.enable_compression", false)
+	setDefault("ws.max_message_size", 65536) // 64KB
+	setDefault("ws.sequence_numbers", false)
+
+	// Health defaults
+	setDefault("health.max_consumer_lag", 10000)
+	setDefault("health.stall_timeout", "30s")
+	setDefault("health.liveness_interval", "1s")
+
+	// Shutdown defaults
+	setDefault("shutdown.timeout", "30s")
+	setDefault("shutdown.drain_grace", "5s")
+	setDefault("shutdown.drain_window", "20s")
+	setDefault("shutdown.batch_interval", "500ms")
+	setDefault("shutdown.reconnect_delay_min", "1s")
+	setDefault("shutdown.reconnect_delay_max", "10s")
+	setDefault("shutdown.reconnect_url", "")
+
+	// Admin defaults
+	setDefault("admin.enabled", false)
+	setDefault("admin.port", "")
+	setDefault("admin.path_prefix", "/admin")
+	setDefault("admin.tokens", []string{})
+
+	// HTTP publish defaults
+	setDefault("publish.enabled", false)
+	setDefault("publish.mode", "stream")
+	setDefault("publish.default_subject", "NOTIFY.{brand}.http")
+	setDefault("publish.max_events", 100)
+	setDefault("publish.services", []map[string]interface{}{})
+
+	// SSE / long-poll defaults
+	setDefault("fallback.enabled", false)
+	setDefault("fallback.poll_timeout", "25s")
+	setDefault("fallback.session_timeout", "60s")
+	setDefault("fallback.max_batch", 100)
+
+	// Rate limit defaults
+	setDefault("ratelimit.enabled", false)
+	setDefault("ratelimit.rules", []map[string]interface{}{
+		{"type": "typing", "rate": 1, "burst": 3},
+		{"type": "*", "rate": 20, "burst": 40},
+	})
+	setDefault("ratelimit.user_rate", 50)
+	setDefault("ratelimit.user_burst", 100)
+	setDefault("ratelimit.max_violations", 50)
+	setDefault("ratelimit.violation_window", "10s")
+
+	// Admission defaults
+	setDefault("admission.max_per_ip", 0)
+	setDefault("admission.trusted_proxies", []string{})
+	setDefault("admission.max_per_user", 0)
+	setDefault("admission.user_policy", "evict_oldest")
+	setDefault("admission.brand_quotas", []map[string]interface{}{})
+	setDefault("admission.default_brand_quota", 0)
+	setDefault("admission.quota_bucket", "")
+	setDefault("admission.retry_after", "10s")
+
+	// Origin defaults: any origin, as before the allow-list existed
+	setDefault("origins.allowed", []string{"*"})
+	setDefault("origins.brands", []map[string]interface{}{})
+	setDefault("origins.allow_missing", true)
+	setDefault("origins.allow_credentials", false)
+
+	// Tenancy defaults
+	setDefault("tenancy.admin_roles", []string{"platform_admin"})
+
+	// Brand settings defaults
+	setDefault("brands.file", "")
+	setDefault("brands.bucket", "")
+
+	// RPC defaults
+	setDefault("rpc.timeout", "5s")
+	setDefault("rpc.max_in_flight", 16)
+	setDefault("rpc.methods", []map[string]interface{}{})
+
+	// Routing defaults: everything goes to the connection's stream
+	setDefault("routing.routes", []map[string]interface{}{
+		{"type": "*", "subject": "{stream}.{brand}.events"},
+	})
+}
+
+// splitCommaList expands a single comma-separated entry (as set from env) into a list
+func splitCommaList(values []string) []string {
+	if len(values) != 1 || !strings.Contains(values[0], ",") {
+		return values
+	}
+	var cleaned []string
+	for _, p := range strings.Split(values[0], ",") {
+		if s := strings.TrimSpace(p); s != "" {
+			cleaned = append(cleaned, s)
+		}
+	}
+	return cleaned
 }
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/metrics"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// watchDebounce coalesces the events of one config file save
+const watchDebounce = 300 * time.Millisecond
+
+// Reloader validates a new configuration for one component and returns the
+// function that switches the component to it. apply must not fail.
+type Reloader func(next *Config) (apply func(), err error)
+
+// Live holds the running configuration. Reload re-reads config.yaml and the
+// environment, and swaps the new configuration in only if it changes no
+// restart-only setting and every registered Reloader accepts it.
+type Live struct {
+	current atomic.Pointer[Config]
+
+	mu        sync.Mutex // serializes reloads
+	reloaders []namedReloader
+
+	watchMu sync.Mutex // guards pending
+	pending *time.Timer
+}
+
+type namedReloader struct {
+	name string
+	fn   Reloader
+}
+
+// NewLive wraps the configuration returned by Load
+func NewLive(cfg *Config) *Live {
+	l := &Live{}
+	l.current.Store(cfg)
+	return l
+}
+
+// Get returns the current configuration; it must not be modified
+func (l *Live) Get() *Config {
+	return l.current.Load()
+}
+
+// OnReload registers a component's Reloader. Call it before Watch.
+func (l *Live) OnReload(name string, fn Reloader) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reloaders = append(l.reloaders, namedReloader{name, fn})
+}
+
+// Watch reloads whenever the config file changes
+func (l *Live) Watch() {
+	if viper.ConfigFileUsed() == "" {
+		log.Info().Msg("No config file, reload on change is off")
+		return
+	}
+	// Editors truncate before writing; reload once the burst of events is over
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		l.watchMu.Lock()
+		defer l.watchMu.Unlock()
+		if l.pending != nil {
+			l.pending.Stop()
+		}
+		l.pending = time.AfterFunc(watchDebounce, func() { l.Reload("file") })
+	})
+	viper.WatchConfig()
+}
+
+// Reload loads, validates and applies the configuration. trigger names
+// what caused it ("file", "sighup") in the log line.
+func (l *Live) Reload(trigger string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.reload()
+	switch {
+	case err == nil:
+		metrics.ConfigReloads.WithLabelValues("success").Inc()
+		log.Info().Str("trigger", trigger).Msg("Configuration reloaded")
+	case isRestartRequired(err):
+		metrics.ConfigReloads.WithLabelValues("rejected").Inc()
+		log.Warn().Err(err).Str("trigger", trigger).Msg("Configuration reload rejected, restart to apply")
+	default:
+		metrics.ConfigReloads.WithLabelValues("invalid").Inc()
+		log.Error().Err(err).Str("trigger", trigger).Msg("Configuration reload failed, keeping the running configuration")
+	}
+	return err
+}
+
+// reload reads into a fresh viper: the global one is re-read by the file
+// watcher's goroutine
+func (l *Live) reload() error {
+	v := viper.New()
+	configure(v)
+	next, err := read(v)
+	if err != nil {
+		return err
+	}
+	LogWarnings()
+	if changed := restartOnlyChanges(l.Get(), next); len(changed) > 0 {
+		return &RestartRequiredError{Keys: changed}
+	}
+
+	applies := make([]func(), 0, len(l.reloaders))
+	for _, r := range l.reloaders {
+		apply, err := r.fn(next)
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.name, err)
+		}
+		applies = append(applies, apply)
+	}
+
+	l.current.Store(next)
+	for _, apply := range applies {
+		apply()
+	}
+	return nil
+}
+
+// RestartRequiredError rejects a reload that changes settings which only
+// take effect on restart
+type RestartRequiredError struct {
+	Keys []string
+}
+
+func (e *RestartRequiredError) Error() string {
+	return "changed settings require a restart: " + strings.Join(e.Keys, ", ")
+}
+
+func isRestartRequired(err error) bool {
+	_, ok := err.(*RestartRequiredError)
+	return ok
+}
+
+// withoutReloadable clears the settings a reload may change
+func withoutReloadable(c Config) Config {
+	c.Log = LogConfig{}
+	c.JWT = JWTConfig{}
+	c.RateLimit = RateLimitConfig{}
+	c.Routing = RoutingConfig{}
+	c.Origins.Allowed = nil
+	c.Origins.Brands = nil
+	c.Origins.AllowMissing = false
+	c.NATS.DedupWindow = 0
+	c.NATS.ExposeHeaders = false
+	c.NATS.BrandToken = 0
+	return c
+}
+
+// restartOnlyChanges lists the restart-only settings that differ, as
+// Section.Field
+func restartOnlyChanges(old, next *Config) []string {
+	a := reflect.ValueOf(withoutReloadable(*old))
+	b := reflect.ValueOf(withoutReloadable(*next))
+	var changed []string
+	for i := 0; i < a.NumField(); i++ {
+		section := a.Type().Field(i).Name
+		sa, sb := a.Field(i), b.Field(i)
+		if reflect.DeepEqual(sa.Interface(), sb.Interface()) {
+			continue
+		}
+		if sa.Kind() != reflect.Struct {
+			changed = append(changed, section)
+			continue
+		}
+		for j := 0; j < sa.NumField(); j++ {
+			if !reflect.DeepEqual(sa.Field(j).Interface(), sb.Field(j).Interface()) {
+				changed = append(changed, section+"."+sa.Type().Field(j).Name)
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, path, level string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("log:\n  level: "+level+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// SIGHUP reloads run while the file watcher re-reads the same file; with
+// -race this checks they share no viper state
+func TestReloadWhileWatching(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeConfig(t, path, "info")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GATEWAY_JWT_PUBLIC_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})))
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLive(cfg)
+	l.Watch()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			writeConfig(t, path, []string{"debug", "warn"}[i%2])
+			time.Sleep(5 * time.Millisecond)
+		}
+		writeConfig(t, path, "error")
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			l.Reload("sighup")
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	wg.Wait()
+
+	// The last write wins once the debounced reload has run
+	deadline := time.Now().Add(5 * time.Second)
+	for l.Get().Log.Level != "error" {
+		if time.Now().After(deadline) {
+			t.Fatalf("log level = %q after the last write, want error", l.Get().Log.Level)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,360 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// knownKeys are the supported keys: every key is given a default
+var knownKeys = map[string]bool{}
+
+// defaults holds the default of every known key, for configure
+var defaults = map[string]interface{}{}
+
+// deprecatedKeys are accepted but ignored, with the reason
+var deprecatedKeys = map[string]string{
+	"jwt.secret_key": "HS256 secrets are no longer used; tokens are verified with jwt.public_key (RS256)",
+}
+
+// secretKeys are redacted when settings are printed; list entries use the
+// key of the list, e.g. publish.services.key
+var secretKeys = map[string]bool{
+	"jwt.secret_key":       true,
+	"admin.tokens":         true,
+	"publish.services.key": true,
+}
+
+const redacted = "[redacted]"
+
+// Check validates settings that another package parses itself, with the
+// code it runs at startup, e.g. building the route table
+type Check func(c *Config) error
+
+var checks []Check
+
+// RegisterCheck adds a check to Validate. Packages that build state from
+// the configuration register their constructor, so "config check" and
+// reloads reject what startup would.
+func RegisterCheck(check Check) {
+	checks = append(checks, check)
+}
+
+// ValidationError lists every invalid setting of a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Warning is a key that is ignored, from the config file or the environment
+type Warning struct {
+	Key     string
+	Message string
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, key, format string, args ...interface{}) {
+	if !ok {
+		v.problems = append(v.problems, key+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *validator) positive(key string, d time.Duration) {
+	v.check(d > 0, key, "must be a positive duration, got %s", d)
+}
+
+func (v *validator) notNegative(key string, n int) {
+	v.check(n >= 0, key, "must not be negative, got %d", n)
+}
+
+func (v *validator) port(key, value string) {
+	n, err := strconv.Atoi(value)
+	v.check(err == nil && n > 0 && n < 65536, key, "must be a port number, got %q", value)
+}
+
+// Validate checks the values of every section and reports all problems at once
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	if _, err := zerolog.ParseLevel(c.Log.Level); err != nil || c.Log.Level == "" {
+		v.check(false, "log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+
+	v.port("server.port", c.Server.Port)
+	v.positive("server.read_timeout", c.Server.ReadTimeout)
+	v.positive("server.write_timeout", c.Server.WriteTimeout)
+
+	v.check(c.JWT.PublicKeyPEM != "", "jwt.public_key", "is required (or jwt.public_key_file)")
+
+	v.check(c.NATS.URL != "", "nats.url", "is required")
+	v.check(len(c.NATS.Streams) > 0, "nats.streams", "needs at least one stream")
+	v.positive("nats.reconnect_wait", c.NATS.ReconnectWait)
+	v.check(c.NATS.DedupWindow >= 0, "nats.dedup_window", "must not be negative, got %s", c.NATS.DedupWindow)
+	v.notNegative("nats.subject_brand_token", c.NATS.BrandToken)
+	v.notNegative("nats.outbox.max_messages", c.NATS.Outbox.MaxMessages)
+	v.notNegative("nats.outbox.max_bytes", c.NATS.Outbox.MaxBytes)
+
+	if c.Metrics.Enabled {
+		v.port("metrics.port", c.Metrics.Port)
+	}
+
+	v.notNegative("ws.max_connections", c.WS.MaxConnections)
+	v.positive("ws.ping_interval", c.WS.PingInterval)
+	v.positive("ws.pong_timeout", c.WS.PongTimeout)
+	v.positive("ws.write_timeout", c.WS.WriteTimeout)
+	v.check(c.WS.ReadBufferSize > 0, "ws.read_buffer_size", "must be positive, got %d", c.WS.ReadBufferSize)
+	v.check(c.WS.WriteBufferSize > 0, "ws.write_buffer_size", "must be positive, got %d", c.WS.WriteBufferSize)
+	v.check(c.WS.MaxMessageSize > 0, "ws.max_message_size", "must be positive, got %d", c.WS.MaxMessageSize)
+
+	v.positive("rpc.timeout", c.RPC.Timeout)
+	v.check(c.RPC.MaxInFlight > 0, "rpc.max_in_flight", "must be positive, got %d", c.RPC.MaxInFlight)
+	for i, m := range c.RPC.Methods {
+		key := fmt.Sprintf("rpc.methods[%d]", i)
+		v.check(m.Name != "" && m.Subject != "", key, "name and subject are required")
+		v.check(m.Timeout >= 0, key+".timeout", "must not be negative, got %s", m.Timeout)
+	}
+
+	v.positive("health.stall_timeout", c.Health.StallTimeout)
+	v.positive("health.liveness_interval", c.Health.LivenessInterval)
+
+	v.positive("shutdown.timeout", c.Shutdown.Timeout)
+	v.positive("shutdown.batch_interval", c.Shutdown.BatchInterval)
+	v.check(c.Shutdown.DrainGrace >= 0, "shutdown.drain_grace", "must not be negative, got %s", c.Shutdown.DrainGrace)
+	v.check(c.Shutdown.DrainWindow >= 0, "shutdown.drain_window", "must not be negative, got %s", c.Shutdown.DrainWindow)
+	v.check(c.Shutdown.ReconnectDelayMin >= 0 && c.Shutdown.ReconnectDelayMin <= c.Shutdown.ReconnectDelayMax,
+		"shutdown.reconnect_delay_min", "must be between 0 and shutdown.reconnect_delay_max (%s), got %s",
+		c.Shutdown.ReconnectDelayMax, c.Shutdown.ReconnectDelayMin)
+
+	if c.Admin.Enabled {
+		v.check(len(c.Admin.Tokens) > 0, "admin.tokens", "is required when admin.enabled is true")
+		v.check(strings.HasPrefix(c.Admin.PathPrefix, "/"), "admin.path_prefix", "must start with /, got %q", c.Admin.PathPrefix)
+		if c.Admin.Port != "" {
+			v.port("admin.port", c.Admin.Port)
+		}
+	}
+
+	v.check(c.Publish.Mode == "stream" || c.Publish.Mode == "local", "publish.mode", "must be stream or local, got %q", c.Publish.Mode)
+	if c.Publish.Enabled {
+		v.check(len(c.Publish.Services) > 0, "publish.services", "is required when publish.enabled is true")
+		v.check(c.Publish.MaxEvents > 0, "publish.max_events", "must be positive, got %d", c.Publish.MaxEvents)
+	}
+	for i, svc := range c.Publish.Services {
+		v.check(svc.Name != "" && svc.Key != "", fmt.Sprintf("publish.services[%d]", i), "name and key are required")
+	}
+
+	if c.Fallback.Enabled {
+		v.positive("fallback.poll_timeout", c.Fallback.PollTimeout)
+		v.check(c.Fallback.SessionTimeout > c.Fallback.PollTimeout, "fallback.session_timeout",
+			"must be longer than fallback.poll_timeout (%s), got %s", c.Fallback.PollTimeout, c.Fallback.SessionTimeout)
+		v.check(c.Fallback.MaxBatch > 0, "fallback.max_batch", "must be positive, got %d", c.Fallback.MaxBatch)
+	}
+
+	v.check(c.RateLimit.ViolationWindow >= 0, "ratelimit.violation_window", "must not be negative, got %s", c.RateLimit.ViolationWindow)
+
+	v.notNegative("admission.max_per_ip", c.Admission.MaxPerIP)
+	v.notNegative("admission.max_per_user", c.Admission.MaxPerUser)
+	v.notNegative("admission.default_brand_quota", c.Admission.DefaultBrandQuota)
+	v.check(c.Admission.RetryAfter >= 0, "admission.retry_after", "must not be negative, got %s", c.Admission.RetryAfter)
+
+	for _, check := range checks {
+		if err := check(c); err != nil {
+			v.problems = append(v.problems, err.Error())
+		}
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// setDefault sets the default of a key and registers it as supported
+func setDefault(key string, value interface{}) {
+	knownKeys[key] = true
+	defaults[key] = value
+}
+
+// Warnings lists the keys of the config file and GATEWAY_* variables that
+// are unknown or deprecated. Call it after Load.
+func Warnings() []Warning {
+	var warnings []Warning
+	if file := viper.ConfigFileUsed(); file != "" {
+		v := viper.New()
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err == nil {
+			keys := v.AllKeys()
+			sort.Strings(keys)
+			for _, key := range keys {
+				if w, ok := keyWarning(key); ok {
+					warnings = append(warnings, w)
+				}
+			}
+		}
+	}
+
+	envKeys := make(map[string]string, len(knownKeys)+len(deprecatedKeys))
+	for key := range knownKeys {
+		envKeys[envName(key)] = key
+	}
+	for key := range deprecatedKeys {
+		envKeys[envName(key)] = key
+	}
+	var names []string
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, "GATEWAY_") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key, ok := envKeys[name]
+		if !ok {
+			warnings = append(warnings, Warning{Key: name, Message: "unknown environment variable, ignored"})
+			continue
+		}
+		if reason, ok := deprecatedKeys[key]; ok {
+			warnings = append(warnings, Warning{Key: name, Message: "deprecated and ignored: " + reason})
+		}
+	}
+	return warnings
+}
+
+// LogWarnings logs the result of Warnings
+func LogWarnings() {
+	for _, w := range Warnings() {
+		log.Warn().Str("key", w.Key).Msg("Config: " + w.Message)
+	}
+}
+
+func keyWarning(key string) (Warning, bool) {
+	if reason, ok := deprecatedKeys[key]; ok {
+		return Warning{Key: key, Message: "deprecated and ignored: " + reason}, true
+	}
+	if knownKeys[key] {
+		return Warning{}, false
+	}
+	msg := "unknown key, ignored"
+	if s := suggestKey(key); s != "" {
+		msg += " (did you mean " + s + "?)"
+	}
+	return Warning{Key: key, Message: msg}, true
+}
+
+// envName is the GATEWAY_* variable that overrides a key
+func envName(key string) string {
+	return "GATEWAY_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// suggestKey returns the known key closest to a misspelt one, if any is close
+func suggestKey(key string) string {
+	best, bestDist := "", 4
+	for known := range knownKeys {
+		if d := editDistance(key, known); d < bestDist || (d == bestDist && known < best) {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Settings returns the settings as nested maps with secrets redacted:
+// the effective ones (defaults, config file and environment) or only
+// those of the config file. Call it after Load.
+func Settings(effective bool) (map[string]interface{}, error) {
+	settings := viper.AllSettings()
+	if !effective {
+		settings = map[string]interface{}{}
+		if file := viper.ConfigFileUsed(); file != "" {
+			v := viper.New()
+			v.SetConfigFile(file)
+			if err := v.ReadInConfig(); err != nil {
+				return nil, err
+			}
+			settings = v.AllSettings()
+		}
+	}
+	return redact("", settings).(map[string]interface{}), nil
+}
+
+// redact masks secret values and the password of URLs
+func redact(path string, value interface{}) interface{} {
+	if secretKeys[path] {
+		if isEmpty(value) {
+			return value
+		}
+		return redacted
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			key := k
+			if path != "" {
+				key = path + "." + k
+			}
+			out[k] = redact(key, item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = redact(path, item)
+		}
+		return out
+	case string:
+		if u, err := url.Parse(v); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), "xxxxx")
+				return u.String()
+			}
+		}
+	}
+	return value
+}
+
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case []string:
+		return len(v) == 0
+	}
+	return false
+}
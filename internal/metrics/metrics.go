@@ -43,6 +43,21 @@ var (
 		Help: "Total number of messages received from NATS",
 	})
 
+	EventsBrandMismatch = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_events_brand_mismatch_total",
+		Help: "Total number of NATS events dropped because brand_id did not match the subject",
+	})
+
+	EventsFromClients = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_events_from_clients_total",
+		Help: "Total number of client messages forwarded by a gateway that consumers skipped",
+	})
+
+	MessagesDuplicate = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_messages_duplicate_total",
+		Help: "Total number of duplicate NATS messages dropped",
+	})
+
 	// Latency metrics
 	MessageLatency = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "gateway_message_latency_seconds",
@@ -56,6 +71,55 @@ var (
 		Help: "Total number of errors by type",
 	}, []string{"type"})
 
+	// NATS metrics
+	NATSConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_nats_connected",
+		Help: "Whether the NATS connection is up (1) or down (0)",
+	})
+
+	ConsumerPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_consumer_pending",
+		Help: "Messages pending delivery to the gateway per stream consumer",
+	}, []string{"stream"})
+
+	OutboxMessages = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_outbox_messages",
+		Help: "Number of publishes buffered while NATS is unavailable",
+	})
+
+	OutboxDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_outbox_dropped_total",
+		Help: "Total number of publishes dropped because the outbox was full or their subject has no stream",
+	})
+
+	// Rate limit metrics
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_rate_limited_total",
+		Help: "Total number of client messages rejected by rate limits, by limit",
+	}, []string{"limit"})
+
+	RateLimitDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_rate_limit_disconnects_total",
+		Help: "Total number of connections closed for sustained rate limit violations",
+	})
+
+	// Admission metrics
+	AdmissionRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_admission_rejected_total",
+		Help: "Total number of connection attempts rejected by admission limits, by limit",
+	}, []string{"limit"})
+
+	AdmissionEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_admission_evictions_total",
+		Help: "Total number of connections closed to make room for a newer one of the same user",
+	})
+
+	// Config metrics
+	ConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_config_reloads_total",
+		Help: "Total number of configuration reloads, by result (success, rejected, invalid)",
+	}, []string{"result"})
+
 	// Auth metrics
 	AuthSuccess = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "gateway_auth_success_total",
@@ -91,4 +155,3 @@ func (s *Server) Start() {
 		log.Error().Err(err).Msg("Metrics server error")
 	}
 }
-
@@ -3,42 +3,73 @@ package nats
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/attchat/attchat-gateway/internal/config"
 	"github.com/attchat/attchat-gateway/internal/metrics"
 	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/rs/zerolog/log"
 )
 
-// Event represents a message event from NATS
-type Event struct {
-	Type          string          `json:"type"`
-	Room          string          `json:"room"`
-	UserID        string          `json:"user_id,omitempty"`
-	BrandID       string          `json:"brand_id,omitempty"`
-	ChatID        string          `json:"chat_id,omitempty"`
-	Payload       json.RawMessage `json:"payload"`
-	Timestamp     time.Time       `json:"timestamp"`
-	ExcludeConnID string          `json:"exclude_conn_id,omitempty"`
-}
+var (
+	ErrNoResponders   = errors.New("no responders")
+	ErrRequestTimeout = errors.New("request timed out")
+)
+
+const (
+	publishTimeout      = 5 * time.Second
+	outboxRetryInterval = 5 * time.Second
+	consumerBackoffMin  = time.Second
+	consumerBackoffMax  = 30 * time.Second
+)
 
 // Consumer consumes messages from NATS JetStream
 type Consumer struct {
 	nc          *nats.Conn
 	js          jetstream.JetStream
 	cfg         config.NATSConfig
+	live        atomic.Pointer[config.NATSConfig] // reloadable event options
 	roomManager *room.Manager
+	dedup       *dedupCache
+	outbox      *outbox
+	streams     map[string]*streamState
+	degraded    atomic.Bool
+	wg          sync.WaitGroup
 	ctx         context.Context
 	cancel      context.CancelFunc
 }
 
 // NewConsumer creates a new NATS consumer
 func NewConsumer(cfg config.NATSConfig, roomManager *room.Manager) (*Consumer, error) {
+	cfg.Streams = trimStreams(cfg.Streams)
+	ob, err := newOutbox(cfg.Outbox.MaxMessages, cfg.Outbox.MaxBytes, cfg.Outbox.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Consumer{
+		cfg:         cfg,
+		roomManager: roomManager,
+		dedup:       newDedupCache(cfg.DedupWindow),
+		outbox:      ob,
+		streams:     make(map[string]*streamState),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	for _, name := range cfg.Streams {
+		c.streams[name] = &streamState{}
+	}
+	c.live.Store(&cfg)
+
 	// Connect to NATS
 	opts := []nats.Option{
 		nats.Name(cfg.ClientID),
@@ -46,9 +77,12 @@ func NewConsumer(cfg config.NATSConfig, roomManager *room.Manager) (*Consumer, e
 		nats.MaxReconnects(cfg.MaxReconnects),
 		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
 			log.Warn().Err(err).Msg("NATS disconnected")
+			c.setDegraded(true)
 		}),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
 			log.Info().Str("url", nc.ConnectedUrl()).Msg("NATS reconnected")
+			c.setDegraded(false)
+			go c.flushOutbox()
 		}),
 		nats.ClosedHandler(func(nc *nats.Conn) {
 			log.Info().Msg("NATS connection closed")
@@ -57,22 +91,23 @@ func NewConsumer(cfg config.NATSConfig, roomManager *room.Manager) (*Consumer, e
 
 	nc, err := nats.Connect(cfg.URL, opts...)
 	if err != nil {
+		cancel()
+		ob.Close()
 		return nil, err
 	}
+	metrics.NATSConnected.Set(1)
 
 	// Create JetStream context
 	js, err := jetstream.New(nc)
 	if err != nil {
+		cancel()
+		ob.Close()
 		nc.Close()
 		return nil, err
 	}
 
 	// Ensure streams exist
-	for _, raw := range cfg.Streams {
-		streamName := strings.TrimSpace(raw)
-		if streamName == "" {
-			continue
-		}
+	for _, streamName := range cfg.Streams {
 		_, err := js.Stream(context.Background(), streamName)
 		if err != nil {
 			_, err = js.CreateStream(context.Background(), jetstream.StreamConfig{
@@ -82,6 +117,8 @@ func NewConsumer(cfg config.NATSConfig, roomManager *room.Manager) (*Consumer, e
 				Retention: jetstream.LimitsPolicy,
 			})
 			if err != nil {
+				cancel()
+				ob.Close()
 				nc.Close()
 				return nil, fmt.Errorf("failed to create stream %s: %w", streamName, err)
 			}
@@ -89,29 +126,88 @@ func NewConsumer(cfg config.NATSConfig, roomManager *room.Manager) (*Consumer, e
 		}
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	c.nc = nc
+	c.js = js
+	return c, nil
+}
 
-	return &Consumer{
-		nc:          nc,
-		js:          js,
-		cfg:         cfg,
-		roomManager: roomManager,
-		ctx:         ctx,
-		cancel:      cancel,
+// trimStreams drops blanks around and between configured stream names
+func trimStreams(raw []string) []string {
+	names := make([]string, 0, len(raw))
+	for _, name := range raw {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// options returns the current NATS settings for handling events
+// (dedup_window, expose_headers, subject_brand_token)
+func (c *Consumer) options() *config.NATSConfig {
+	return c.live.Load()
+}
+
+// Reload applies the event options of a new configuration to every stream
+// consumer (config.Reloader); connection and stream settings need a restart
+func (c *Consumer) Reload(next *config.Config) (func(), error) {
+	cfg := next.NATS
+	return func() {
+		c.live.Store(&cfg)
+		c.dedup.setWindow(cfg.DedupWindow)
 	}, nil
 }
 
 // Start starts consuming messages from all configured streams
 func (c *Consumer) Start() {
 	for _, streamName := range c.cfg.Streams {
-		go c.consumeStream(streamName)
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.consumeStream(streamName)
+		}()
 	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.outboxLoop()
+	}()
 }
 
-// consumeStream consumes messages from a specific stream
+// consumeStream consumes messages from a specific stream, recreating the
+// JetStream consumer with backoff whenever it fails (e.g. after a reconnect)
 func (c *Consumer) consumeStream(streamName string) {
 	log.Info().Str("stream", streamName).Msg("Starting stream consumer")
 
+	backoff := consumerBackoffMin
+	for {
+		started := time.Now()
+		err := c.runStream(streamName)
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		// A consumer that ran for a while was healthy; start over with a short wait
+		if time.Since(started) > consumerBackoffMax {
+			backoff = consumerBackoffMin
+		}
+		log.Warn().
+			Err(err).
+			Str("stream", streamName).
+			Dur("retry_in", backoff).
+			Msg("Stream consumer stopped, recreating")
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, consumerBackoffMax)
+	}
+}
+
+// runStream creates the stream consumer and handles messages until an error occurs
+func (c *Consumer) runStream(streamName string) error {
 	// Create or get consumer
 	consumerName := "gateway-" + c.cfg.ClientID
 
@@ -123,56 +219,97 @@ func (c *Consumer) consumeStream(streamName string) {
 		FilterSubject: streamName + ".>",
 	})
 	if err != nil {
-		log.Error().Err(err).Str("stream", streamName).Msg("Failed to create consumer")
-		return
+		return fmt.Errorf("create consumer: %w", err)
 	}
 
 	// Consume messages
 	msgs, err := consumer.Messages()
 	if err != nil {
-		log.Error().Err(err).Str("stream", streamName).Msg("Failed to get messages")
-		return
+		return fmt.Errorf("get messages: %w", err)
 	}
+	defer msgs.Stop()
+
+	st := c.streams[streamName]
+	st.running.Store(true)
+	defer st.running.Store(false)
+
+	// Unblock Next when shutting down; stop the lag watcher with the consumer
+	runCtx, stop := context.WithCancel(c.ctx)
+	defer stop()
+	go func() {
+		<-runCtx.Done()
+		msgs.Stop()
+	}()
+	go c.watchLag(runCtx, streamName, consumer, st)
 
 	for {
-		select {
-		case <-c.ctx.Done():
-			msgs.Stop()
-			return
-		default:
-			msg, err := msgs.Next()
-			if err != nil {
-				if err == context.Canceled {
-					return
-				}
-				log.Error().Err(err).Msg("Error getting next message")
-				continue
+		msg, err := msgs.Next()
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return nil
 			}
-
-			c.handleMessage(msg)
+			return fmt.Errorf("next message: %w", err)
 		}
+
+		st.busySince.Store(time.Now().UnixNano())
+		c.handleMessage(msg)
+		st.busySince.Store(0)
+		st.lastMsg.Store(time.Now().UnixNano())
 	}
 }
 
 // handleMessage processes a message from NATS
 func (c *Consumer) handleMessage(msg jetstream.Msg) {
+	c.handleEvent(msg.Subject(), msg.Headers(), msg.Data())
+	msg.Ack()
+}
+
+// handleEvent decodes, deduplicates and routes one message and returns the
+// number of connections it was delivered to
+func (c *Consumer) handleEvent(subject string, header nats.Header, data []byte) int {
 	start := time.Now()
+	meta := MetaFromHeader(header)
 
-	var event Event
-	if err := json.Unmarshal(msg.Data(), &event); err != nil {
-		log.Error().Err(err).Msg("Failed to unmarshal event")
-		msg.Ack()
-		return
+	event, err := DecodeEvent(header, data)
+	if err != nil {
+		log.Error().Err(err).Str("subject", subject).Str("msg_id", meta.MsgID).Msg("Failed to decode event")
+		return 0
+	}
+	event.normalize(meta, subject)
+	// Client messages go to backends; fanning them out would let clients
+	// send any frame to their peers
+	if strings.HasPrefix(event.Source, ClientSourcePrefix) {
+		metrics.EventsFromClients.Inc()
+		log.Debug().Str("subject", subject).Str("event_id", event.ID).Str("source", event.Source).Msg("Skipped client message forwarded by a gateway")
+		return 0
+	}
+	if !c.scopeToSubject(event, subject) {
+		return 0
+	}
+
+	// Drop redelivered or republished duplicates
+	if c.dedup.Seen(event.ID) {
+		metrics.MessagesDuplicate.Inc()
+		log.Debug().
+			Str("subject", subject).
+			Str("event_id", event.ID).
+			Msg("Duplicate event dropped")
+		return 0
+	}
+	if c.options().ExposeHeaders {
+		// The publisher's connection and user are not for every recipient
+		exposed := meta
+		exposed.ConnID, exposed.UserID = "", ""
+		if !exposed.IsZero() {
+			event.Meta = &exposed
+		}
 	}
 
 	// Update metrics
 	metrics.MessagesFromNATS.Inc()
 
 	// Route message to appropriate room(s)
-	c.routeEvent(&event)
-
-	// Ack message
-	msg.Ack()
+	delivered := c.routeEvent(event)
 
 	// Record latency
 	metrics.MessageLatency.Observe(time.Since(start).Seconds())
@@ -180,39 +317,126 @@ func (c *Consumer) handleMessage(msg jetstream.Msg) {
 	log.Debug().
 		Str("type", event.Type).
 		Str("room", event.Room).
+		Str("event_id", event.ID).
+		Str("msg_id", meta.MsgID).
+		Str("correlation_id", meta.CorrelationID).
+		Str("traceparent", meta.TraceParent).
 		Dur("latency", time.Since(start)).
 		Msg("Event processed")
+	return delivered
 }
 
-// routeEvent routes an event to the appropriate connections
-func (c *Consumer) routeEvent(event *Event) {
-	// Serialize event for sending
-	data, err := json.Marshal(event)
+// scopeToSubject applies nats.subject_brand_token: the brand in the subject
+// scopes an event without brand_id, and an event claiming another brand than
+// its subject is dropped. "_" is the subject token of brandless events.
+func (c *Consumer) scopeToSubject(event *Event, subject string) bool {
+	n := c.options().BrandToken
+	if n <= 0 {
+		return true
+	}
+	tokens := strings.Split(subject, ".")
+	if len(tokens) < n {
+		return true
+	}
+	brand := tokens[n-1]
+	if brand == "_" {
+		brand = ""
+	}
+	if event.BrandID == "" {
+		event.BrandID = brand
+	}
+	if event.BrandID == brand {
+		return true
+	}
+
+	metrics.EventsBrandMismatch.Inc()
+	log.Warn().
+		Str("subject", subject).
+		Str("event_id", event.ID).
+		Str("brand_id", event.BrandID).
+		Msg("Dropped event whose brand_id does not match its subject")
+	return false
+}
+
+// routeEvent routes an event to the appropriate connections and returns the
+// number of connections it was delivered to
+func (c *Consumer) routeEvent(event *Event) int {
+	// Serialize the client-facing projection for sending
+	data, err := json.Marshal(event.ClientView())
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to marshal event")
-		return
+		return 0
 	}
 
-	switch {
-	case event.Room != "":
-		// Broadcast to specific room
-		count := c.roomManager.BroadcastToRoom(event.Room, data, event.ExcludeConnID)
-		log.Debug().
-			Str("room", event.Room).
-			Int("recipients", count).
-			Msg("Broadcasted to room")
+	target := event.Target()
+	if target.IsZero() {
+		log.Warn().Str("event_id", event.ID).Msg("Event has no routing target")
+		return 0
+	}
 
-	case event.UserID != "":
-		// Send to specific user (all their connections)
-		count := c.roomManager.BroadcastToUser(event.UserID, data, event.ExcludeConnID)
-		log.Debug().
-			Str("user_id", event.UserID).
-			Int("recipients", count).
-			Msg("Sent to user")
+	count := c.roomManager.Broadcast(target, data, event.ExcludeConnID)
+	log.Debug().
+		Strs("rooms", target.Rooms).
+		Strs("user_ids", target.UserIDs).
+		Bool("filtered", !target.Filter.IsZero()).
+		Int("recipients", count).
+		Msg("Broadcasted event")
+	return count
+}
+
+// RouteLocal delivers an event to connections on this node only
+func (c *Consumer) RouteLocal(event *Event) int {
+	event.normalize(Meta{}, "local")
+	return c.routeEvent(event)
+}
+
+// PublishAck is the JetStream acknowledgement of a stored event
+type PublishAck struct {
+	Stream    string `json:"stream"`
+	Sequence  uint64 `json:"seq"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
 
-	default:
-		log.Warn().Msg("Event has no routing target")
+// PublishEvent stores an event in JetStream so every node delivers it.
+// Unlike Publish it does not buffer: the caller gets the ack or the error.
+// The event ID is used as Nats-Msg-Id so retries are deduplicated.
+func (c *Consumer) PublishEvent(ctx context.Context, subject string, event *Event, meta Meta) (*PublishAck, error) {
+	msg, err := encodeEvent(subject, event, meta)
+	if err != nil {
+		return nil, err
+	}
+	ack, err := c.js.PublishMsg(ctx, msg)
+	if err != nil {
+		return nil, err
 	}
+	return &PublishAck{Stream: ack.Stream, Sequence: ack.Sequence, Duplicate: ack.Duplicate}, nil
+}
+
+// encodeEvent builds the message PublishEvent stores: the event in the
+// legacy envelope (see DecodeEvent) with the gateway headers
+func encodeEvent(subject string, event *Event, meta Meta) (*nats.Msg, error) {
+	event.normalize(meta, subject)
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	meta.MsgID = event.ID
+	if !ValidTraceParent(meta.TraceParent) {
+		meta.TraceParent = NewTraceParent()
+		meta.TraceState = ""
+	}
+	return &nats.Msg{Subject: subject, Header: meta.Header(), Data: data}, nil
+}
+
+// HasStream reports whether subject belongs to one of the configured streams
+func (c *Consumer) HasStream(subject string) bool {
+	for _, name := range c.cfg.Streams {
+		if strings.HasPrefix(subject, name+".") {
+			return true
+		}
+	}
+	return false
 }
 
 // AccountStats returns JetStream account streams/consumers counts.
@@ -227,14 +451,212 @@ func (c *Consumer) AccountStats(ctx context.Context) (streams int64, consumers i
 	return int64(info.Streams), int64(info.Consumers), nil
 }
 
-// Publish publishes a message to NATS (for forwarding client messages)
-func (c *Consumer) Publish(subject string, data []byte) error {
-	_, err := c.js.Publish(c.ctx, subject, data)
+// Publish publishes a message to NATS (for forwarding client messages).
+// Meta is sent as headers; a missing msg ID or traceparent is generated.
+// While NATS is unreachable the message is buffered in the outbox and
+// published on reconnect; ErrOutboxFull is returned once the buffer is full.
+func (c *Consumer) Publish(subject string, data []byte, meta Meta) error {
+	if meta.MsgID == "" {
+		meta.MsgID = uuid.New().String()
+	}
+	if !ValidTraceParent(meta.TraceParent) {
+		meta.TraceParent = NewTraceParent()
+		meta.TraceState = ""
+	}
+
+	msg := &nats.Msg{
+		Subject: subject,
+		Header:  meta.Header(),
+		Data:    data,
+	}
+
+	// Keep ordering: once anything is buffered, new messages queue behind it
+	if !c.Connected() {
+		return c.buffer(msg)
+	}
+	if c.outbox.Len() > 0 {
+		if err := c.buffer(msg); err != nil {
+			return err
+		}
+		go c.flushOutbox()
+		return nil
+	}
+
+	if err := c.publishMsg(msg); err != nil {
+		if isConnectionError(err) {
+			return c.buffer(msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *Consumer) publishMsg(msg *nats.Msg) error {
+	ctx, cancel := context.WithTimeout(c.ctx, publishTimeout)
+	defer cancel()
+	_, err := c.js.PublishMsg(ctx, msg)
 	return err
 }
 
-// Close closes the NATS connection
+func (c *Consumer) buffer(msg *nats.Msg) error {
+	if err := c.outbox.Push(msg); err != nil {
+		return err
+	}
+	log.Debug().
+		Str("subject", msg.Subject).
+		Int("buffered", c.outbox.Len()).
+		Msg("NATS unavailable, message buffered")
+	return nil
+}
+
+// flushOutbox publishes buffered messages; Nats-Msg-Id lets JetStream drop
+// any that were already stored before a failure
+func (c *Consumer) flushOutbox() {
+	if c.outbox.Len() == 0 || !c.Connected() {
+		return
+	}
+	sent, dropped, err := c.outbox.Flush(c.publishMsg, c.retryable)
+	if sent > 0 || dropped > 0 || err != nil {
+		log.Info().
+			Err(err).
+			Int("sent", sent).
+			Int("dropped", dropped).
+			Int("remaining", c.outbox.Len()).
+			Msg("Flushed NATS outbox")
+	}
+}
+
+// outboxLoop retries buffered publishes in case a reconnect flush failed
+func (c *Consumer) outboxLoop() {
+	ticker := time.NewTicker(outboxRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.flushOutbox()
+		}
+	}
+}
+
+// Connected reports whether the NATS connection is up
+func (c *Consumer) Connected() bool {
+	return c != nil && c.nc != nil && c.nc.IsConnected()
+}
+
+// Degraded reports whether the realtime backend is currently unavailable
+func (c *Consumer) Degraded() bool {
+	return c.degraded.Load()
+}
+
+// setDegraded records the NATS state and tells every client when it changes
+func (c *Consumer) setDegraded(degraded bool) {
+	if c.degraded.Swap(degraded) == degraded {
+		return
+	}
+
+	status, message := "restored", "Realtime backend restored"
+	metrics.NATSConnected.Set(1)
+	if degraded {
+		status, message = "degraded", "Realtime backend unavailable, messages may be delayed"
+		metrics.NATSConnected.Set(0)
+	}
+
+	payload, _ := json.Marshal(map[string]string{
+		"component": "nats",
+		"status":    status,
+		"message":   message,
+	})
+	frame, _ := json.Marshal(systemFrame{
+		Type:      "system",
+		Payload:   payload,
+		Timestamp: time.Now(),
+	})
+	count := c.roomManager.BroadcastAll(frame)
+	log.Info().Str("status", status).Int("recipients", count).Msg("Signalled realtime backend status")
+}
+
+// systemFrame is the client frame used for gateway status notifications
+type systemFrame struct {
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// isConnectionError reports whether a publish failed because NATS is
+// disconnected. No responders or no stream means the subject has no stream,
+// which waiting does not fix.
+func isConnectionError(err error) bool {
+	return errors.Is(err, nats.ErrConnectionClosed) ||
+		errors.Is(err, nats.ErrConnectionReconnecting) ||
+		errors.Is(err, nats.ErrDisconnected)
+}
+
+// retryable reports whether a buffered publish should stay buffered: NATS is
+// down, or the ack timed out (Nats-Msg-Id deduplicates the retry)
+func (c *Consumer) retryable(err error) bool {
+	return isConnectionError(err) || !c.Connected() ||
+		errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Reply is a response to a NATS request
+type Reply struct {
+	Data      []byte
+	Meta      Meta
+	Error     string // Nats-Service-Error header, set by the responder on failure
+	ErrorCode string // Nats-Service-Error-Code header
+}
+
+// Request sends a core NATS request and waits for a single reply until ctx expires.
+// Meta is sent as headers; a missing traceparent is generated.
+func (c *Consumer) Request(ctx context.Context, subject string, data []byte, meta Meta) (*Reply, error) {
+	if !ValidTraceParent(meta.TraceParent) {
+		meta.TraceParent = NewTraceParent()
+		meta.TraceState = ""
+	}
+
+	msg, err := c.nc.RequestMsgWithContext(ctx, &nats.Msg{
+		Subject: subject,
+		Header:  meta.Header(),
+		Data:    data,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, nats.ErrNoResponders):
+			return nil, ErrNoResponders
+		case errors.Is(err, context.DeadlineExceeded), errors.Is(err, nats.ErrTimeout):
+			return nil, ErrRequestTimeout
+		}
+		return nil, err
+	}
+
+	return &Reply{
+		Data:      msg.Data,
+		Meta:      MetaFromHeader(msg.Header),
+		Error:     msg.Header.Get("Nats-Service-Error"),
+		ErrorCode: msg.Header.Get("Nats-Service-Error-Code"),
+	}, nil
+}
+
+// Flush publishes anything still buffered in the outbox and waits for the
+// server to acknowledge pending publishes
+func (c *Consumer) Flush(ctx context.Context) error {
+	c.flushOutbox()
+	if n := c.outbox.Len(); n > 0 {
+		log.Warn().Int("remaining", n).Msg("NATS outbox not empty at shutdown")
+	}
+	if !c.Connected() {
+		return nats.ErrConnectionClosed
+	}
+	return c.nc.FlushWithContext(ctx)
+}
+
+// Close stops the stream consumers, waits for in-flight messages to be
+// handled and closes the NATS connection
 func (c *Consumer) Close() {
 	c.cancel()
+	c.wg.Wait()
 	c.nc.Close()
+	c.outbox.Close()
 }
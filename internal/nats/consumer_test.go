@@ -0,0 +1,170 @@
+package nats
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/attchat/attchat-gateway/internal/room"
+)
+
+// newTestConsumer returns a consumer without a NATS connection, for
+// handleEvent
+func newTestConsumer(manager *room.Manager) *Consumer {
+	cfg := config.NATSConfig{ClientID: "test", DedupWindow: time.Minute}
+	c := &Consumer{cfg: cfg, roomManager: manager, dedup: newDedupCache(cfg.DedupWindow)}
+	c.live.Store(&cfg)
+	return c
+}
+
+func newTestConn(manager *room.Manager, id, userID string, rooms ...string) *room.Connection {
+	conn := room.NewConnection(id, "memory", userID, "b1", "", "customer")
+	manager.AddConnection(conn)
+	for _, r := range rooms {
+		if err := manager.JoinRoom(id, r); err != nil {
+			panic(err)
+		}
+	}
+	return conn
+}
+
+// received returns the client events queued on conn
+func received(t *testing.T, conn *room.Connection) []ClientEvent {
+	t.Helper()
+	var events []ClientEvent
+	for {
+		select {
+		case out := <-conn.SendChannel():
+			var ev ClientEvent
+			if err := json.Unmarshal(out.Frame.JSON(), &ev); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+// An event published through PublishEvent (HTTP publish, stream mode) is
+// delivered when a node consumes it
+func TestPublishEventConsumed(t *testing.T) {
+	manager := room.NewManager()
+	c := newTestConsumer(manager)
+	member := newTestConn(manager, "c1", "7", "chat:2")
+	other := newTestConn(manager, "c2", "8", "chat:3")
+	user := newTestConn(manager, "c3", "9")
+
+	event := &Event{
+		Type:    "notice",
+		Rooms:   []string{"chat:1", "chat:2"},
+		UserIDs: []string{"9"},
+		BrandID: "b1",
+		Payload: json.RawMessage(`{"text":"hello"}`),
+	}
+	msg, err := encodeEvent("NOTIFY.b1.http", event, Meta{CorrelationID: "corr-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get(HeaderMsgID); got != event.ID || got == "" {
+		t.Errorf("Nats-Msg-Id = %q, want event ID %q", got, event.ID)
+	}
+
+	if n := c.handleEvent(msg.Subject, msg.Header, msg.Data); n != 2 {
+		t.Fatalf("delivered to %d connections, want 2", n)
+	}
+	for _, conn := range []*room.Connection{member, user} {
+		events := received(t, conn)
+		if len(events) != 1 {
+			t.Fatalf("%s received %d events, want 1", conn.ID, len(events))
+		}
+		ev := events[0]
+		if ev.Type != "notice" || ev.ID != event.ID || string(ev.Payload) != `{"text":"hello"}` {
+			t.Errorf("%s received %+v", conn.ID, ev)
+		}
+	}
+	if events := received(t, other); len(events) != 0 {
+		t.Errorf("non-member received %v", events)
+	}
+
+	// JetStream redelivery of the same message is deduplicated
+	if n := c.handleEvent(msg.Subject, msg.Header, msg.Data); n != 0 {
+		t.Errorf("duplicate delivered to %d connections", n)
+	}
+}
+
+// A client message forwarded by a gateway is for backends: consuming it
+// delivers nothing, whatever frame type it claims to be
+func TestForwardedClientMessageSkipped(t *testing.T) {
+	manager := room.NewManager()
+	c := newTestConsumer(manager)
+	sender := newTestConn(manager, "c1", "7", "chat:1")
+	member := newTestConn(manager, "c2", "8", "chat:1")
+
+	for _, msgType := range []string{"message", "system", "reconnect"} {
+		data, err := json.Marshal(Event{
+			SpecVersion:   SpecVersion,
+			ID:            "msg-" + msgType,
+			Source:        ClientSourcePrefix + "test",
+			Type:          msgType,
+			Room:          "chat:1",
+			UserID:        "7",
+			BrandID:       "b1",
+			Payload:       json.RawMessage(`{"text":"hi"}`),
+			Timestamp:     time.Now(),
+			ExcludeConnID: sender.ID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := c.handleEvent("CHAT.b1."+msgType, Meta{MsgID: "msg-" + msgType}.Header(), data); n != 0 {
+			t.Errorf("%s: delivered to %d connections, want 0", msgType, n)
+		}
+	}
+	if events := received(t, member); len(events) != 0 {
+		t.Errorf("member received client messages: %+v", events)
+	}
+}
+
+// Exposed headers keep tracing but not the publisher's connection and user
+func TestExposedMetaStripsOrigin(t *testing.T) {
+	manager := room.NewManager()
+	c := newTestConsumer(manager)
+	opts := *c.options()
+	opts.ExposeHeaders = true
+	c.live.Store(&opts)
+	member := newTestConn(manager, "c1", "7", "chat:1")
+
+	for i, meta := range []Meta{
+		{CorrelationID: "corr-1", ConnID: "origin-conn", UserID: "42", BrandID: "b1"},
+		{ConnID: "origin-conn", UserID: "42"},
+	} {
+		event := &Event{Type: "notice", Room: "chat:1", BrandID: "b1", Payload: json.RawMessage(`{}`)}
+		msg, err := encodeEvent("NOTIFY.b1.http", event, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := c.handleEvent(msg.Subject, msg.Header, msg.Data); n != 1 {
+			t.Fatalf("%d: delivered to %d connections, want 1", i, n)
+		}
+		events := received(t, member)
+		if len(events) != 1 || events[0].Meta == nil {
+			t.Fatalf("%d: received %+v, want one event with meta", i, events)
+		}
+		got := events[0].Meta
+		if got.ConnID != "" || got.UserID != "" {
+			t.Errorf("%d: meta exposes the origin: %+v", i, got)
+		}
+		if got.MsgID != event.ID || got.TraceParent == "" || got.CorrelationID != meta.CorrelationID {
+			t.Errorf("%d: meta = %+v, want the msg ID, traceparent and correlation ID", i, got)
+		}
+	}
+}
+
+func TestTrimStreams(t *testing.T) {
+	got := trimStreams([]string{" CHAT", "", "NOTIFY ", "  "})
+	if len(got) != 2 || got[0] != "CHAT" || got[1] != "NOTIFY" {
+		t.Errorf("trimStreams = %q, want [CHAT NOTIFY]", got)
+	}
+}
@@ -0,0 +1,58 @@
+package nats
+
+import (
+	"sync"
+	"time"
+)
+
+// dedupCache remembers message IDs seen within a time window
+type dedupCache struct {
+	mu        sync.Mutex
+	window    time.Duration
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newDedupCache(window time.Duration) *dedupCache {
+	return &dedupCache{
+		window:    window,
+		seen:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Seen records id and reports whether it was already seen inside the window
+func (d *dedupCache) Seen(id string) bool {
+	if d == nil || id == "" {
+		return false
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.window <= 0 {
+		return false
+	}
+
+	if now.Sub(d.lastSweep) > d.window {
+		for k, t := range d.seen {
+			if now.Sub(t) > d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	if t, ok := d.seen[id]; ok && now.Sub(t) <= d.window {
+		return true
+	}
+	d.seen[id] = now
+	return false
+}
+
+// setWindow changes the window; IDs already seen are kept
+func (d *dedupCache) setWindow(window time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.window = window
+}
@@ -0,0 +1,291 @@
+package nats
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// SpecVersion is the CloudEvents version of the event envelope
+const SpecVersion = "1.0"
+
+// ClientSourcePrefix starts the source of client messages a gateway forwards
+// to NATS. Gateways skip these events on consume: they are for backends.
+const ClientSourcePrefix = "/gateway/"
+
+// ContentTypeCloudEvents marks a structured-mode CloudEvent
+const ContentTypeCloudEvents = "application/cloudevents+json"
+
+var ErrUnsupportedSpecVersion = errors.New("unsupported cloudevents specversion")
+
+// Event represents a message event from NATS.
+// It is decoded from the legacy JSON format or a CloudEvents 1.0 message
+// (structured or binary mode) and carries internal routing fields that
+// must not reach clients; use ClientView for the client-facing frame.
+type Event struct {
+	SpecVersion   string          `json:"specversion,omitempty"`
+	ID            string          `json:"id,omitempty"`
+	Source        string          `json:"source,omitempty"`
+	Type          string          `json:"type"`
+	Room          string          `json:"room"`
+	UserID        string          `json:"user_id,omitempty"`
+	BrandID       string          `json:"brand_id,omitempty"`
+	ChatID        string          `json:"chat_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+	Timestamp     time.Time       `json:"timestamp"`
+	ExcludeConnID string          `json:"exclude_conn_id,omitempty"`
+	Meta          *Meta           `json:"meta,omitempty"` // set from headers when nats.expose_headers is on
+
+	// Additional routing targets, combined with Room/UserID
+	Rooms   []string     `json:"rooms,omitempty"`
+	UserIDs []string     `json:"user_ids,omitempty"`
+	Filter  *room.Filter `json:"filter,omitempty"`
+}
+
+// Target builds the delivery target of the event, scoped to its brand. For
+// compatibility with the legacy format, UserID only targets the user when no
+// room is given (events forwarded from clients carry the sender's user_id
+// alongside a room).
+func (e *Event) Target() room.Target {
+	t := room.Target{Brand: e.BrandID}
+	if e.Room != "" {
+		t.Rooms = append(t.Rooms, e.Room)
+	}
+	t.Rooms = append(t.Rooms, e.Rooms...)
+	if e.UserID != "" && len(t.Rooms) == 0 {
+		t.UserIDs = append(t.UserIDs, e.UserID)
+	}
+	t.UserIDs = append(t.UserIDs, e.UserIDs...)
+	if e.Filter != nil {
+		t.Filter = *e.Filter
+	}
+	return t
+}
+
+// ClientEvent is the client-facing projection of an Event
+type ClientEvent struct {
+	SpecVersion string          `json:"specversion"`
+	ID          string          `json:"id"`
+	Source      string          `json:"source,omitempty"`
+	Type        string          `json:"type"`
+	Room        string          `json:"room,omitempty"`
+	UserID      string          `json:"user_id,omitempty"`
+	BrandID     string          `json:"brand_id,omitempty"`
+	ChatID      string          `json:"chat_id,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Timestamp   time.Time       `json:"timestamp"`
+	Meta        *Meta           `json:"meta,omitempty"`
+}
+
+// ClientView strips internal routing fields from the event
+func (e *Event) ClientView() ClientEvent {
+	return ClientEvent{
+		SpecVersion: e.SpecVersion,
+		ID:          e.ID,
+		Source:      e.Source,
+		Type:        e.Type,
+		Room:        e.Room,
+		UserID:      e.UserID,
+		BrandID:     e.BrandID,
+		ChatID:      e.ChatID,
+		Payload:     e.Payload,
+		Timestamp:   e.Timestamp,
+		Meta:        e.Meta,
+	}
+}
+
+// cloudEvent is a structured-mode CloudEvent with the attchat extension attributes
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+
+	// Extension attributes
+	Room          string       `json:"room,omitempty"`
+	UserID        string       `json:"userid,omitempty"`
+	BrandID       string       `json:"brandid,omitempty"`
+	ChatID        string       `json:"chatid,omitempty"`
+	ExcludeConnID string       `json:"excludeconnid,omitempty"`
+	Rooms         string       `json:"rooms,omitempty"`   // comma-separated
+	UserIDs       string       `json:"userids,omitempty"` // comma-separated
+	Filter        *room.Filter `json:"filter,omitempty"`
+}
+
+// DecodeEvent decodes a NATS message body and headers into an Event.
+// CloudEvents binary mode is detected by the ce-specversion header,
+// structured mode by the content type or a data/data_base64 member, or a
+// specversion without the legacy payload member. Anything else is the
+// legacy format, which is also what the gateway itself publishes (with
+// specversion set, see Event).
+func DecodeEvent(h nats.Header, data []byte) (*Event, error) {
+	if h.Get("ce-specversion") != "" {
+		return decodeBinary(h, data)
+	}
+
+	var probe struct {
+		SpecVersion string          `json:"specversion"`
+		Data        json.RawMessage `json:"data"`
+		DataBase64  *string         `json:"data_base64"`
+		Payload     json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+	structured := mediaType(h.Get("Content-Type")) == ContentTypeCloudEvents ||
+		probe.Data != nil || probe.DataBase64 != nil ||
+		(probe.SpecVersion != "" && probe.Payload == nil)
+	if structured {
+		return decodeStructured(data)
+	}
+
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func decodeStructured(data []byte) (*Event, error) {
+	var ce cloudEvent
+	if err := json.Unmarshal(data, &ce); err != nil {
+		return nil, err
+	}
+	if err := checkRequired(ce.SpecVersion, ce.ID, ce.Source, ce.Type); err != nil {
+		return nil, err
+	}
+
+	payload := ce.Data
+	if ce.DataBase64 != "" {
+		raw, err := base64.StdEncoding.DecodeString(ce.DataBase64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data_base64: %w", err)
+		}
+		payload = dataPayload(ce.DataContentType, raw)
+	}
+
+	return &Event{
+		SpecVersion:   ce.SpecVersion,
+		ID:            ce.ID,
+		Source:        ce.Source,
+		Type:          ce.Type,
+		Room:          ce.Room,
+		UserID:        ce.UserID,
+		BrandID:       ce.BrandID,
+		ChatID:        ce.ChatID,
+		Payload:       payload,
+		Timestamp:     ce.Time,
+		ExcludeConnID: ce.ExcludeConnID,
+		Rooms:         splitList(ce.Rooms),
+		UserIDs:       splitList(ce.UserIDs),
+		Filter:        ce.Filter,
+	}, nil
+}
+
+func decodeBinary(h nats.Header, data []byte) (*Event, error) {
+	event := &Event{
+		SpecVersion:   h.Get("ce-specversion"),
+		ID:            h.Get("ce-id"),
+		Source:        h.Get("ce-source"),
+		Type:          h.Get("ce-type"),
+		Room:          h.Get("ce-room"),
+		UserID:        h.Get("ce-userid"),
+		BrandID:       h.Get("ce-brandid"),
+		ChatID:        h.Get("ce-chatid"),
+		ExcludeConnID: h.Get("ce-excludeconnid"),
+		Rooms:         splitList(h.Get("ce-rooms")),
+		UserIDs:       splitList(h.Get("ce-userids")),
+		Payload:       dataPayload(h.Get("Content-Type"), data),
+	}
+	if err := checkRequired(event.SpecVersion, event.ID, event.Source, event.Type); err != nil {
+		return nil, err
+	}
+	if f := h.Get("ce-filter"); f != "" {
+		event.Filter = &room.Filter{}
+		if err := json.Unmarshal([]byte(f), event.Filter); err != nil {
+			return nil, fmt.Errorf("invalid ce-filter: %w", err)
+		}
+	}
+	if t := h.Get("ce-time"); t != "" {
+		ts, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ce-time: %w", err)
+		}
+		event.Timestamp = ts
+	}
+	return event, nil
+}
+
+func checkRequired(specVersion, id, source, eventType string) error {
+	if specVersion != SpecVersion {
+		return fmt.Errorf("%w: %q", ErrUnsupportedSpecVersion, specVersion)
+	}
+	if id == "" || source == "" || eventType == "" {
+		return errors.New("cloudevent requires id, source and type")
+	}
+	return nil
+}
+
+// dataPayload keeps JSON data as-is and wraps anything else as a JSON string
+func dataPayload(contentType string, data []byte) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+	ct := mediaType(contentType)
+	if (ct == "" || ct == "application/json" || strings.HasSuffix(ct, "+json")) && json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	quoted, _ := json.Marshal(string(data))
+	return quoted
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mt
+}
+
+// normalize fills the envelope fields every routed event must carry
+func (e *Event) normalize(meta Meta, subject string) {
+	e.SpecVersion = SpecVersion
+	if e.ID == "" {
+		e.ID = meta.MsgID
+	}
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	if e.Source == "" {
+		e.Source = "/nats/" + subject
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+}
@@ -0,0 +1,110 @@
+package nats
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/nats-io/nats.go"
+)
+
+// The gateway publishes the legacy envelope with specversion set; it must
+// decode back to the same event
+func TestDecodeEventRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		event Event
+	}{
+		{"client message", Event{
+			Type: "message", Room: "chat:1", UserID: "7", BrandID: "b1",
+			Payload: json.RawMessage(`{"text":"hi"}`), ExcludeConnID: "conn-1",
+		}},
+		{"multi-room", Event{
+			Type: "notice", Rooms: []string{"chat:1", "chat:2"}, UserIDs: []string{"8", "9"},
+			BrandID: "b1", Payload: json.RawMessage(`[1,2]`),
+		}},
+		{"filter", Event{
+			Type: "announcement", BrandID: "b1", Payload: json.RawMessage(`"x"`),
+			Filter: &room.Filter{BrandID: "b1", Types: []string{"cskh"}},
+		}},
+		{"null payload", Event{Type: "ping", Room: "chat:1", Payload: json.RawMessage(`null`)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.event
+			want.Timestamp = ts
+			want.normalize(Meta{MsgID: "msg-1"}, "CHAT.b1.message")
+
+			data, err := json.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := DecodeEvent(nats.Header{}, data)
+			if err != nil {
+				t.Fatalf("DecodeEvent(%s): %v", data, err)
+			}
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("round trip of %s\n got %+v\nwant %+v", data, *got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeEventStructured(t *testing.T) {
+	data := []byte(`{"specversion":"1.0","id":"evt-1","source":"/chat","type":"message",
+		"time":"2024-01-02T03:04:05Z","data":{"text":"hi"},"room":"chat:1","brandid":"b1",
+		"userid":"7","excludeconnid":"conn-1","rooms":"chat:2, chat:3","userids":"8"}`)
+	got, err := DecodeEvent(nats.Header{}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Event{
+		SpecVersion: "1.0", ID: "evt-1", Source: "/chat", Type: "message",
+		Room: "chat:1", UserID: "7", BrandID: "b1", ExcludeConnID: "conn-1",
+		Payload:   json.RawMessage(`{"text":"hi"}`),
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Rooms:     []string{"chat:2", "chat:3"},
+		UserIDs:   []string{"8"},
+	}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("got %+v\nwant %+v", *got, want)
+	}
+}
+
+func TestDecodeEventDetection(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      nats.Header
+		data        string
+		wantPayload string
+		wantErr     bool
+	}{
+		{"legacy", nil, `{"type":"m","room":"r","payload":{"a":1}}`, `{"a":1}`, false},
+		{"legacy with specversion", nil, `{"specversion":"1.0","type":"m","room":"r","payload":{"a":1}}`, `{"a":1}`, false},
+		{"structured by data", nil, `{"specversion":"1.0","id":"1","source":"s","type":"m","data":{"a":1}}`, `{"a":1}`, false},
+		{"structured without data", nil, `{"specversion":"1.0","id":"1","source":"s","type":"m"}`, ``, false},
+		{"structured by content type", nats.Header{"Content-Type": []string{ContentTypeCloudEvents}},
+			`{"specversion":"1.0","id":"1","source":"s","type":"m","payload":{"a":1}}`, ``, false},
+		{"structured missing source", nil, `{"specversion":"1.0","id":"1","type":"m","data":1}`, ``, true},
+		{"binary", nats.Header{"ce-specversion": []string{"1.0"}, "ce-id": []string{"1"},
+			"ce-source": []string{"s"}, "ce-type": []string{"m"}}, `{"a":1}`, `{"a":1}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.header
+			if h == nil {
+				h = nats.Header{}
+			}
+			got, err := DecodeEvent(h, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(got.Payload) != tt.wantPayload {
+				t.Errorf("payload = %s, want %s", got.Payload, tt.wantPayload)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package nats
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Header names carried on messages the gateway publishes and consumes
+const (
+	HeaderMsgID         = nats.MsgIdHdr // JetStream dedup
+	HeaderCorrelationID = "Attchat-Correlation-Id"
+	HeaderConnID        = "Attchat-Conn-Id"
+	HeaderUserID        = "Attchat-User-Id"
+	HeaderBrandID       = "Attchat-Brand-Id"
+	HeaderTraceParent   = "traceparent"
+	HeaderTraceState    = "tracestate"
+)
+
+// Meta is the transport metadata attached to a NATS message as headers
+type Meta struct {
+	MsgID         string `json:"msg_id,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	ConnID        string `json:"conn_id,omitempty"`
+	UserID        string `json:"user_id,omitempty"`
+	BrandID       string `json:"brand_id,omitempty"`
+	TraceParent   string `json:"traceparent,omitempty"`
+	TraceState    string `json:"tracestate,omitempty"`
+}
+
+// Header converts meta into NATS headers, skipping empty values
+func (m Meta) Header() nats.Header {
+	h := nats.Header{}
+	set := func(key, value string) {
+		if value != "" {
+			h.Set(key, value)
+		}
+	}
+	set(HeaderMsgID, m.MsgID)
+	set(HeaderCorrelationID, m.CorrelationID)
+	set(HeaderConnID, m.ConnID)
+	set(HeaderUserID, m.UserID)
+	set(HeaderBrandID, m.BrandID)
+	set(HeaderTraceParent, m.TraceParent)
+	set(HeaderTraceState, m.TraceState)
+	return h
+}
+
+// IsZero reports whether no metadata is set
+func (m Meta) IsZero() bool {
+	return m == Meta{}
+}
+
+// MetaFromHeader reads gateway metadata from NATS headers.
+// An invalid traceparent is dropped rather than propagated.
+func MetaFromHeader(h nats.Header) Meta {
+	if h == nil {
+		return Meta{}
+	}
+	m := Meta{
+		MsgID:         h.Get(HeaderMsgID),
+		CorrelationID: h.Get(HeaderCorrelationID),
+		ConnID:        h.Get(HeaderConnID),
+		UserID:        h.Get(HeaderUserID),
+		BrandID:       h.Get(HeaderBrandID),
+		TraceParent:   h.Get(HeaderTraceParent),
+		TraceState:    h.Get(HeaderTraceState),
+	}
+	if !ValidTraceParent(m.TraceParent) {
+		m.TraceParent = ""
+		m.TraceState = ""
+	}
+	return m
+}
+
+// NewTraceParent starts a new W3C trace context (version 00, sampled)
+func NewTraceParent() string {
+	var b [24]byte
+	_, _ = rand.Read(b[:])
+	return "00-" + hex.EncodeToString(b[:16]) + "-" + hex.EncodeToString(b[16:]) + "-01"
+}
+
+// ValidTraceParent checks the W3C traceparent format: 00-<32 hex>-<16 hex>-<2 hex>
+func ValidTraceParent(s string) bool {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return false
+	}
+	if parts[0] == "ff" {
+		return false
+	}
+	for _, p := range parts {
+		if _, err := hex.DecodeString(p); err != nil {
+			return false
+		}
+	}
+	// All-zero trace or span IDs are invalid
+	return strings.Trim(parts[1], "0") != "" && strings.Trim(parts[2], "0") != ""
+}
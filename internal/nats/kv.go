@@ -0,0 +1,68 @@
+package nats
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+var errWatchClosed = errors.New("kv watch closed")
+
+// WatchKeyValue calls fn with every current and future entry of a JetStream
+// KV bucket until the consumer is closed; value is nil when a key is deleted.
+// The watch is re-established with backoff, e.g. while the bucket is missing.
+func (c *Consumer) WatchKeyValue(bucket string, fn func(key string, value []byte)) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		backoff := consumerBackoffMin
+		for {
+			err := c.watchKeyValue(bucket, fn)
+			if c.ctx.Err() != nil {
+				return
+			}
+			log.Warn().
+				Err(err).
+				Str("bucket", bucket).
+				Dur("retry_in", backoff).
+				Msg("KV watch stopped, retrying")
+
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, consumerBackoffMax)
+		}
+	}()
+}
+
+func (c *Consumer) watchKeyValue(bucket string, fn func(key string, value []byte)) error {
+	kv, err := c.js.KeyValue(c.ctx, bucket)
+	if err != nil {
+		return err
+	}
+	w, err := kv.WatchAll(c.ctx)
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	log.Info().Str("bucket", bucket).Msg("Watching KV bucket")
+	for entry := range w.Updates() {
+		// nil marks the end of the initial values
+		if entry == nil {
+			continue
+		}
+		switch entry.Operation() {
+		case jetstream.KeyValueDelete, jetstream.KeyValuePurge:
+			fn(entry.Key(), nil)
+		default:
+			fn(entry.Key(), entry.Value())
+		}
+	}
+	return errWatchClosed
+}
@@ -0,0 +1,208 @@
+package nats
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/attchat/attchat-gateway/internal/metrics"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrOutboxFull = errors.New("outbox full")
+
+// outboxEntry is a publish waiting for NATS to come back
+type outboxEntry struct {
+	Subject string      `json:"subject"`
+	Header  nats.Header `json:"header,omitempty"`
+	Data    []byte      `json:"data"`
+}
+
+func (e outboxEntry) size() int {
+	n := len(e.Subject) + len(e.Data)
+	for k, values := range e.Header {
+		for _, v := range values {
+			n += len(k) + len(v)
+		}
+	}
+	return n
+}
+
+// outbox buffers publishes in memory, optionally mirrored to an append-only
+// JSON lines file so buffered messages survive a restart
+type outbox struct {
+	flushMu  sync.Mutex // serializes flushes
+	mu       sync.Mutex
+	entries  []outboxEntry
+	bytes    int
+	maxMsgs  int
+	maxBytes int
+	path     string
+	file     *os.File
+}
+
+func newOutbox(maxMsgs, maxBytes int, path string) (*outbox, error) {
+	o := &outbox{maxMsgs: maxMsgs, maxBytes: maxBytes, path: path}
+	if path == "" {
+		return o, nil
+	}
+
+	// Restore entries left over from a previous run. The limits apply as in
+	// Push: entries past them (e.g. after the limits were lowered) are dropped.
+	dropped := 0
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var e outboxEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				continue
+			}
+			if o.full(e) {
+				dropped++
+				continue
+			}
+			o.entries = append(o.entries, e)
+			o.bytes += e.size()
+		}
+		f.Close()
+		if len(o.entries) > 0 {
+			log.Info().Int("messages", len(o.entries)).Str("path", path).Msg("Restored NATS outbox")
+		}
+		if dropped > 0 {
+			metrics.OutboxDropped.Add(float64(dropped))
+			log.Warn().Int("dropped", dropped).Str("path", path).Msg("Dropped restored outbox entries over the buffer limits")
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	o.file = f
+	if dropped > 0 {
+		o.rewrite()
+	}
+	metrics.OutboxMessages.Set(float64(len(o.entries)))
+	return o, nil
+}
+
+// Push appends a message, failing when the buffer limits are reached
+func (o *outbox) Push(msg *nats.Msg) error {
+	e := outboxEntry{Subject: msg.Subject, Header: msg.Header, Data: msg.Data}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.full(e) {
+		metrics.OutboxDropped.Inc()
+		return ErrOutboxFull
+	}
+
+	if o.file != nil {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := o.file.Write(append(line, '\n')); err != nil {
+			log.Warn().Err(err).Str("path", o.path).Msg("Failed to persist outbox entry")
+		}
+	}
+
+	o.entries = append(o.entries, e)
+	o.bytes += e.size()
+	metrics.OutboxMessages.Set(float64(len(o.entries)))
+	return nil
+}
+
+// full reports whether e does not fit within the buffer limits
+func (o *outbox) full(e outboxEntry) bool {
+	return (o.maxMsgs > 0 && len(o.entries) >= o.maxMsgs) || (o.maxBytes > 0 && o.bytes+e.size() > o.maxBytes)
+}
+
+// Len returns the number of buffered messages
+func (o *outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Flush publishes buffered messages in order until one fails with a
+// retryable error; those stay buffered for the next attempt. A message
+// failing with any other error (e.g. no stream for its subject) can never be
+// sent, so it is dropped rather than blocking the messages behind it.
+// Publishing happens without holding the buffer lock so Push never waits on
+// the network.
+func (o *outbox) Flush(publish func(*nats.Msg) error, retryable func(error) bool) (sent, dropped int, err error) {
+	o.flushMu.Lock()
+	defer o.flushMu.Unlock()
+
+	o.mu.Lock()
+	pending := append([]outboxEntry(nil), o.entries...)
+	o.mu.Unlock()
+
+	done := 0
+	for _, e := range pending {
+		if perr := publish(&nats.Msg{Subject: e.Subject, Header: e.Header, Data: e.Data}); perr != nil {
+			if retryable(perr) {
+				err = perr
+				break
+			}
+			metrics.OutboxDropped.Inc()
+			log.Warn().Err(perr).Str("subject", e.Subject).Msg("Dropped buffered NATS message that cannot be published")
+			dropped++
+		} else {
+			sent++
+		}
+		done++
+	}
+	if done == 0 {
+		return 0, 0, err
+	}
+
+	// Only Flush removes entries, so the first done entries are still the ones handled
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, e := range o.entries[:done] {
+		o.bytes -= e.size()
+	}
+	o.entries = append([]outboxEntry(nil), o.entries[done:]...)
+	o.rewrite()
+	metrics.OutboxMessages.Set(float64(len(o.entries)))
+	return sent, dropped, err
+}
+
+// rewrite replaces the backing file with the remaining entries
+func (o *outbox) rewrite() {
+	if o.file == nil {
+		return
+	}
+	if err := o.file.Truncate(0); err != nil {
+		log.Warn().Err(err).Str("path", o.path).Msg("Failed to truncate outbox file")
+		return
+	}
+	w := bufio.NewWriter(o.file)
+	for _, e := range o.entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		log.Warn().Err(err).Str("path", o.path).Msg("Failed to rewrite outbox file")
+	}
+}
+
+// Close closes the backing file
+func (o *outbox) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file != nil {
+		o.file.Close()
+		o.file = nil
+	}
+}
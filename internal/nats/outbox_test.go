@@ -0,0 +1,147 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func pushN(t *testing.T, o *outbox, subjects ...string) {
+	t.Helper()
+	for _, s := range subjects {
+		if err := o.Push(&nats.Msg{Subject: s, Data: []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// A message whose subject has no stream is dropped instead of wedging the
+// messages behind it
+func TestOutboxFlushDropsUnpublishable(t *testing.T) {
+	o, err := newOutbox(0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushN(t, o, "CSKH.b1.events", "CHAT.b1.message", "CHAT.b1.typing")
+
+	var published []string
+	sent, dropped, err := o.Flush(func(msg *nats.Msg) error {
+		if msg.Subject == "CSKH.b1.events" {
+			return jetstream.ErrNoStreamResponse
+		}
+		published = append(published, msg.Subject)
+		return nil
+	}, isConnectionError)
+	if err != nil || sent != 2 || dropped != 1 {
+		t.Fatalf("Flush = %d sent, %d dropped, %v; want 2, 1, nil", sent, dropped, err)
+	}
+	if fmt.Sprint(published) != "[CHAT.b1.message CHAT.b1.typing]" {
+		t.Errorf("published %v", published)
+	}
+	if o.Len() != 0 || o.bytes != 0 {
+		t.Errorf("outbox holds %d messages, %d bytes after flush", o.Len(), o.bytes)
+	}
+}
+
+// A disconnect stops the flush and keeps the rest, in order
+func TestOutboxFlushKeepsRetryable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	o, err := newOutbox(0, 0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushN(t, o, "A.1", "A.2", "A.3")
+
+	calls := 0
+	sent, dropped, err := o.Flush(func(msg *nats.Msg) error {
+		if calls++; calls == 2 {
+			return nats.ErrDisconnected
+		}
+		return nil
+	}, isConnectionError)
+	if !errors.Is(err, nats.ErrDisconnected) || sent != 1 || dropped != 0 {
+		t.Fatalf("Flush = %d sent, %d dropped, %v", sent, dropped, err)
+	}
+	o.Close()
+
+	// The file holds what is left, for the next run
+	restored, err := newOutbox(0, 0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if restored.Len() != 2 || restored.entries[0].Subject != "A.2" || restored.entries[1].Subject != "A.3" {
+		t.Errorf("restored %+v", restored.entries)
+	}
+}
+
+// Restored entries past lowered limits are dropped, from the file too
+func TestOutboxRestoreAppliesLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	o, err := newOutbox(0, 0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushN(t, o, "A.1", "A.2", "A.3", "A.4")
+	o.Close()
+
+	for _, limits := range [][2]int{{2, 0}, {0, 8}} {
+		restored, err := newOutbox(limits[0], limits[1], path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Len() != 2 || restored.entries[0].Subject != "A.1" || restored.entries[1].Subject != "A.2" {
+			t.Errorf("limits %v: restored %+v, want A.1 and A.2", limits, restored.entries)
+		}
+		restored.Close()
+	}
+
+	unlimited, err := newOutbox(0, 0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlimited.Close()
+	if unlimited.Len() != 2 {
+		t.Errorf("file holds %d entries after the restore, want 2", unlimited.Len())
+	}
+}
+
+func TestOutboxSizeCountsHeaders(t *testing.T) {
+	o, err := newOutbox(0, 40, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &nats.Msg{Subject: "A.1", Data: []byte("0123456789"), Header: nats.Header{}}
+	msg.Header.Set(HeaderTraceParent, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	if err := o.Push(msg); !errors.Is(err, ErrOutboxFull) {
+		t.Errorf("Push of a message whose headers exceed max_bytes = %v, want ErrOutboxFull", err)
+	}
+	if err := o.Push(&nats.Msg{Subject: "A.1", Data: []byte("0123456789")}); err != nil {
+		t.Errorf("Push without headers = %v", err)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nats.ErrConnectionClosed, true},
+		{nats.ErrConnectionReconnecting, true},
+		{fmt.Errorf("publish: %w", nats.ErrDisconnected), true},
+		{nats.ErrNoResponders, false},
+		{jetstream.ErrNoStreamResponse, false},
+		{context.DeadlineExceeded, false},
+		{errors.New("maximum payload exceeded"), false},
+	}
+	for _, tt := range tests {
+		if got := isConnectionError(tt.err); got != tt.want {
+			t.Errorf("isConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package nats
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/metrics"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+const lagCheckInterval = 10 * time.Second
+
+// streamState tracks the health of one stream consumer goroutine
+type streamState struct {
+	running   atomic.Bool
+	pending   atomic.Uint64 // messages not yet delivered to the gateway
+	lastMsg   atomic.Int64  // unix nanos of the last handled message
+	busySince atomic.Int64  // unix nanos when the current message started, 0 if idle
+}
+
+// StreamStatus is a snapshot of a stream consumer's health
+type StreamStatus struct {
+	Stream      string        `json:"stream"`
+	Running     bool          `json:"running"`
+	Pending     uint64        `json:"pending"`
+	LastMessage time.Time     `json:"last_message,omitempty"`
+	BusyFor     time.Duration `json:"busy_for_ns,omitempty"` // time spent on the current message
+}
+
+// StreamStatuses returns the status of every configured stream consumer
+func (c *Consumer) StreamStatuses() []StreamStatus {
+	if c == nil {
+		return nil
+	}
+	now := time.Now()
+	out := make([]StreamStatus, 0, len(c.streams))
+	for _, name := range c.cfg.Streams {
+		st, ok := c.streams[name]
+		if !ok {
+			continue
+		}
+		status := StreamStatus{
+			Stream:  name,
+			Running: st.running.Load(),
+			Pending: st.pending.Load(),
+		}
+		if t := st.lastMsg.Load(); t > 0 {
+			status.LastMessage = time.Unix(0, t)
+		}
+		if t := st.busySince.Load(); t > 0 {
+			status.BusyFor = now.Sub(time.Unix(0, t))
+		}
+		out = append(out, status)
+	}
+	return out
+}
+
+// watchLag periodically records how far the consumer is behind the stream
+func (c *Consumer) watchLag(ctx context.Context, streamName string, consumer jetstream.Consumer, st *streamState) {
+	ticker := time.NewTicker(lagCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		infoCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		info, err := consumer.Info(infoCtx)
+		cancel()
+		if err == nil {
+			st.pending.Store(info.NumPending)
+			metrics.ConsumerPending.WithLabelValues(streamName).Set(float64(info.NumPending))
+		} else if ctx.Err() == nil {
+			log.Debug().Err(err).Str("stream", streamName).Msg("Failed to get consumer info")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package protocol
+
+import "sync"
+
+// Frame is a prepared outgoing message. It is built once as JSON and
+// shared by every recipient; other wire formats, and anything a transport
+// derives from them such as a pre-compressed WebSocket message, are built
+// on first use and cached, so a broadcast is encoded once per codec rather
+// than per connection.
+type Frame struct {
+	json []byte
+
+	mu       sync.Mutex
+	encoded  map[string][]byte
+	prepared map[preparedKey]interface{}
+}
+
+type preparedKey struct {
+	codec string
+	kind  string
+}
+
+// NewFrame wraps a JSON-encoded server frame
+func NewFrame(json []byte) *Frame {
+	return &Frame{json: json}
+}
+
+// JSON returns the frame as JSON
+func (f *Frame) JSON() []byte {
+	return f.json
+}
+
+// Encode returns the frame in codec's wire format
+func (f *Frame) Encode(codec Codec) ([]byte, error) {
+	if codec == nil || codec == JSON {
+		return f.json, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.encodeLocked(codec)
+}
+
+func (f *Frame) encodeLocked(codec Codec) ([]byte, error) {
+	if data, ok := f.encoded[codec.Name()]; ok {
+		return data, nil
+	}
+	data, err := codec.EncodeServer(f.json)
+	if err != nil {
+		return nil, err
+	}
+	if f.encoded == nil {
+		f.encoded = make(map[string][]byte, 2)
+	}
+	f.encoded[codec.Name()] = data
+	return data, nil
+}
+
+// Prepared returns a value built from the frame's codec encoding by build,
+// cached under kind so every connection sharing the frame reuses it
+func (f *Frame) Prepared(codec Codec, kind string, build func(data []byte) (interface{}, error)) (interface{}, error) {
+	if codec == nil {
+		codec = JSON
+	}
+	key := preparedKey{codec: codec.Name(), kind: kind}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if v, ok := f.prepared[key]; ok {
+		return v, nil
+	}
+
+	data := f.json
+	if codec != JSON {
+		var err error
+		if data, err = f.encodeLocked(codec); err != nil {
+			return nil, err
+		}
+	}
+	v, err := build(data)
+	if err != nil {
+		return nil, err
+	}
+	if f.prepared == nil {
+		f.prepared = make(map[preparedKey]interface{}, 1)
+	}
+	f.prepared[key] = v
+	return v, nil
+}
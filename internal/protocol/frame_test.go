@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// roomSize is the member count of the broadcast benchmarks
+const roomSize = 10_000
+
+var benchFrame = []byte(`{"type":"message","id":"4b1f6c1e-9d2a-4c55-8f0e-2b1d6a7c9e10","room":"chat:42","payload":{"text":"xin chào, bạn cần hỗ trợ gì?","sender":{"id":"7","name":"CSKH"},"attachments":[]},"timestamp":"2024-01-01T00:00:00Z"}`)
+
+// members returns the codecs of a room's members, spread over every codec
+func members(n int) []Codec {
+	all := []Codec{JSON, MsgPack, Proto}
+	codecs := make([]Codec, n)
+	for i := range codecs {
+		codecs[i] = all[i%len(all)]
+	}
+	return codecs
+}
+
+func TestFrameEncodesOncePerCodec(t *testing.T) {
+	f := NewFrame(benchFrame)
+	for _, codec := range []Codec{MsgPack, Proto} {
+		first, err := f.Encode(codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _ := f.Encode(codec)
+		if &first[0] != &again[0] {
+			t.Errorf("%s: frame encoded again", codec.Name())
+		}
+		// Map order may differ between encodings; compare what clients decode
+		got, err := codec.DecodeServer(first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want, have interface{}
+		json.Unmarshal(benchFrame, &want)
+		json.Unmarshal(got, &have)
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%s: frame decodes to %s", codec.Name(), got)
+		}
+	}
+}
+
+// Every member's connection encodes the same shared Frame, as a room
+// broadcast does: one encoding per codec
+func BenchmarkBroadcastEncodeOnce(b *testing.B) {
+	codecs := members(roomSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f := NewFrame(benchFrame)
+		for _, codec := range codecs {
+			if _, err := f.Encode(codec); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// The same broadcast with every connection encoding the frame itself
+func BenchmarkBroadcastEncodePerConnection(b *testing.B) {
+	codecs := members(roomSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, codec := range codecs {
+			if _, err := codec.EncodeServer(benchFrame); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// Shared frame encoded from the connections' writer goroutines at once,
+// contending on the frame's lock
+func BenchmarkBroadcastEncodeOnceParallel(b *testing.B) {
+	codecs := members(roomSize)
+	const writers = 16
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f := NewFrame(benchFrame)
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for j := w; j < len(codecs); j += writers {
+					f.Encode(codecs[j])
+				}
+			}(w)
+		}
+		wg.Wait()
+	}
+}
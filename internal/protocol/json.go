@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// JSON is the text codec, attchat.json.v1, and the default
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return JSONProtocol }
+
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) EncodeServer(frame []byte) ([]byte, error) { return frame, nil }
+
+func (jsonCodec) DecodeServer(data []byte) ([]byte, error) { return data, nil }
+
+func (jsonCodec) EncodeClient(msg *ClientMessage) ([]byte, error) { return json.Marshal(msg) }
+
+func (jsonCodec) DecodeClient(data []byte, msg *ClientMessage) error {
+	return json.Unmarshal(data, msg)
+}
+
+// WithSeq splices "seq" in as the first member of the frame object
+func (jsonCodec) WithSeq(frame []byte, seq uint64) ([]byte, error) {
+	if len(frame) < 2 || frame[0] != '{' {
+		return nil, errors.New("json: frame is not an object")
+	}
+	b := make([]byte, 0, len(frame)+24)
+	b = append(b, `{"seq":`...)
+	b = strconv.AppendUint(b, seq, 10)
+	if frame[1] != '}' {
+		b = append(b, ',')
+	}
+	return append(b, frame[1:]...), nil
+}
@@ -0,0 +1,345 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// MsgPack is the binary codec attchat.msgpack.v1. Frames keep the JSON
+// structure: objects become maps, numbers become ints or float64.
+var MsgPack Codec = msgpackCodec{}
+
+var (
+	errMsgPackTruncated = errors.New("msgpack: truncated data")
+	errMsgPackTooDeep   = errors.New("msgpack: nesting too deep")
+)
+
+// msgPackMaxDepth caps array/map nesting so client input cannot exhaust
+// the stack of the decoding goroutine
+const msgPackMaxDepth = 1000
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return MsgPackProtocol }
+
+func (msgpackCodec) Binary() bool { return true }
+
+func (msgpackCodec) EncodeServer(frame []byte) ([]byte, error) { return jsonToMsgPack(frame) }
+
+func (msgpackCodec) DecodeServer(data []byte) ([]byte, error) { return msgPackToJSON(data) }
+
+func (msgpackCodec) EncodeClient(msg *ClientMessage) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return jsonToMsgPack(data)
+}
+
+func (msgpackCodec) DecodeClient(data []byte, msg *ClientMessage) error {
+	js, err := msgPackToJSON(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, msg)
+}
+
+// WithSeq rewrites the map header with one more entry and puts "seq" first
+func (msgpackCodec) WithSeq(frame []byte, seq uint64) ([]byte, error) {
+	if len(frame) == 0 {
+		return nil, errMsgPackTruncated
+	}
+	var n, header int
+	switch c := frame[0]; {
+	case c&0xf0 == 0x80:
+		n, header = int(c&0x0f), 1
+	case c == 0xde || c == 0xdf:
+		size, _, err := readMsgPackLen(frame[1:], c-0xde+1)
+		if err != nil {
+			return nil, err
+		}
+		n, header = size, 1<<(c-0xde+1)+1
+	default:
+		return nil, errors.New("msgpack: frame is not a map")
+	}
+
+	b := make([]byte, 0, len(frame)+16)
+	b = appendMsgPackHeader(b, n+1, 0x80, 0, 0xde, 0xdf)
+	b = appendMsgPackString(b, "seq")
+	if seq > math.MaxInt64 {
+		b = binary.BigEndian.AppendUint64(append(b, 0xcf), seq)
+	} else {
+		b = appendMsgPackInt(b, int64(seq))
+	}
+	return append(b, frame[header:]...), nil
+}
+
+// jsonToMsgPack re-encodes a JSON document as MessagePack
+func jsonToMsgPack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return appendMsgPack(make([]byte, 0, len(data)), v)
+}
+
+// msgPackToJSON re-encodes a MessagePack document as JSON
+func msgPackToJSON(data []byte) ([]byte, error) {
+	v, rest, err := readMsgPack(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("msgpack: %d trailing bytes", len(rest))
+	}
+	return json.Marshal(v)
+}
+
+func appendMsgPack(b []byte, v interface{}) ([]byte, error) {
+	var err error
+	switch v := v.(type) {
+	case nil:
+		b = append(b, 0xc0)
+	case bool:
+		if v {
+			b = append(b, 0xc3)
+		} else {
+			b = append(b, 0xc2)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return appendMsgPackInt(b, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = appendMsgPackFloat(b, f)
+	case float64:
+		b = appendMsgPackFloat(b, v)
+	case int64:
+		b = appendMsgPackInt(b, v)
+	case string:
+		b = appendMsgPackString(b, v)
+	case []byte:
+		b = appendMsgPackHeader(b, len(v), 0, 0xc4, 0xc5, 0xc6)
+		b = append(b, v...)
+	case []interface{}:
+		b = appendMsgPackHeader(b, len(v), 0x90, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			if b, err = appendMsgPack(b, e); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		b = appendMsgPackHeader(b, len(v), 0x80, 0, 0xde, 0xdf)
+		for k, e := range v {
+			b = appendMsgPackString(b, k)
+			if b, err = appendMsgPack(b, e); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+	return b, nil
+}
+
+func appendMsgPackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+	}
+}
+
+func appendMsgPackFloat(b []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f))
+}
+
+func appendMsgPackString(b []byte, s string) []byte {
+	b = appendMsgPackHeader(b, len(s), 0xa0, 0xd9, 0xda, 0xdb)
+	return append(b, s...)
+}
+
+// appendMsgPackHeader writes a length header: the fix form (when fix is
+// set and n fits), else the 8, 16 or 32-bit form (a zero code8 skips 8-bit)
+func appendMsgPackHeader(b []byte, n int, fix, code8, code16, code32 byte) []byte {
+	switch {
+	case fix == 0xa0 && n < 32, (fix == 0x90 || fix == 0x80) && n < 16:
+		return append(b, fix|byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		return append(b, code8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, code16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, code32), uint32(n))
+	}
+}
+
+// readMsgPack decodes one value at the given nesting depth; maps must have
+// string keys
+func readMsgPack(b []byte, depth int) (interface{}, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errMsgPackTruncated
+	}
+	c, b := b[0], b[1:]
+	switch {
+	case c <= 0x7f:
+		return int64(c), b, nil
+	case c >= 0xe0:
+		return int64(int8(c)), b, nil
+	case c&0xe0 == 0xa0:
+		return readMsgPackString(b, int(c&0x1f))
+	case c&0xf0 == 0x90:
+		return readMsgPackArray(b, int(c&0x0f), depth)
+	case c&0xf0 == 0x80:
+		return readMsgPackMap(b, int(c&0x0f), depth)
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, b, nil
+	case 0xc2:
+		return false, b, nil
+	case 0xc3:
+		return true, b, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, b, err := readMsgPackLen(b, c-0xc4)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(b) < n {
+			return nil, nil, errMsgPackTruncated
+		}
+		return append([]byte(nil), b[:n]...), b[n:], nil
+	case 0xca:
+		if len(b) < 4 {
+			return nil, nil, errMsgPackTruncated
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), b[4:], nil
+	case 0xcb:
+		if len(b) < 8 {
+			return nil, nil, errMsgPackTruncated
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), b[8:], nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		size := 1 << (c - 0xcc)
+		if len(b) < size {
+			return nil, nil, errMsgPackTruncated
+		}
+		u := readUint(b[:size])
+		if u > math.MaxInt64 {
+			return u, b[size:], nil
+		}
+		return int64(u), b[size:], nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		if len(b) < size {
+			return nil, nil, errMsgPackTruncated
+		}
+		u := readUint(b[:size])
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift, b[size:], nil
+	case 0xd9, 0xda, 0xdb:
+		n, b, err := readMsgPackLen(b, c-0xd9)
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgPackString(b, n)
+	case 0xdc, 0xdd:
+		n, b, err := readMsgPackLen(b, c-0xdc+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgPackArray(b, n, depth)
+	case 0xde, 0xdf:
+		n, b, err := readMsgPackLen(b, c-0xde+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgPackMap(b, n, depth)
+	}
+	return nil, nil, fmt.Errorf("msgpack: unsupported type byte 0x%02x", c)
+}
+
+// readMsgPackLen reads a 1, 2 or 4-byte length (sizeClass 0, 1, 2)
+func readMsgPackLen(b []byte, sizeClass byte) (int, []byte, error) {
+	size := 1 << sizeClass
+	if len(b) < size {
+		return 0, nil, errMsgPackTruncated
+	}
+	return int(readUint(b[:size])), b[size:], nil
+}
+
+func readUint(b []byte) uint64 {
+	var u uint64
+	for _, x := range b {
+		u = u<<8 | uint64(x)
+	}
+	return u
+}
+
+func readMsgPackString(b []byte, n int) (interface{}, []byte, error) {
+	if len(b) < n {
+		return nil, nil, errMsgPackTruncated
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+func readMsgPackArray(b []byte, n, depth int) (interface{}, []byte, error) {
+	if depth >= msgPackMaxDepth {
+		return nil, nil, errMsgPackTooDeep
+	}
+	if n > len(b) {
+		return nil, nil, errMsgPackTruncated
+	}
+	arr := make([]interface{}, n)
+	for i := range arr {
+		var err error
+		if arr[i], b, err = readMsgPack(b, depth+1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return arr, b, nil
+}
+
+func readMsgPackMap(b []byte, n, depth int) (interface{}, []byte, error) {
+	if depth >= msgPackMaxDepth {
+		return nil, nil, errMsgPackTooDeep
+	}
+	if 2*n > len(b) {
+		return nil, nil, errMsgPackTruncated
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, rest, err := readMsgPack(b, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("msgpack: map key must be a string, got %T", k)
+		}
+		if m[key], b, err = readMsgPack(rest, depth+1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return m, b, nil
+}
@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// nestedArrays encodes depth nested one-element arrays around nil
+func nestedArrays(depth int) []byte {
+	return append(bytes.Repeat([]byte{0x91}, depth), 0xc0)
+}
+
+func TestMsgPackNestingLimit(t *testing.T) {
+	if _, err := msgPackToJSON(nestedArrays(msgPackMaxDepth)); err != nil {
+		t.Errorf("%d levels: %v", msgPackMaxDepth, err)
+	}
+	if _, err := msgPackToJSON(nestedArrays(msgPackMaxDepth + 1)); !errors.Is(err, errMsgPackTooDeep) {
+		t.Errorf("%d levels: err = %v, want errMsgPackTooDeep", msgPackMaxDepth+1, err)
+	}
+
+	// Far past the cap, as a hostile client frame: an error, not a stack overflow
+	var msg ClientMessage
+	if err := MsgPack.DecodeClient(nestedArrays(10_000_000), &msg); !errors.Is(err, errMsgPackTooDeep) {
+		t.Errorf("DecodeClient: err = %v, want errMsgPackTooDeep", err)
+	}
+
+	// Maps count too: {"a": {"a": ...}}
+	deepMap := append(bytes.Repeat([]byte{0x81, 0xa1, 'a'}, msgPackMaxDepth+1), 0xc0)
+	if _, err := msgPackToJSON(deepMap); !errors.Is(err, errMsgPackTooDeep) {
+		t.Errorf("nested maps: err = %v, want errMsgPackTooDeep", err)
+	}
+}
+
+func TestMsgPackClientRoundTrip(t *testing.T) {
+	want := ClientMessage{Type: "message", ID: "c-1", Room: "chat:1", Payload: json.RawMessage(`{"n":[1,2.5,"x",null,true]}`)}
+	data, err := MsgPack.EncodeClient(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ClientMessage
+	if err := MsgPack.DecodeClient(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != want.Type || got.ID != want.ID || got.Room != want.Room || string(got.Payload) != string(want.Payload) {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Proto is the binary codec attchat.proto.v1; see attchat.proto
+var Proto Codec = protoCodec{}
+
+// Field numbers from attchat.proto
+const (
+	serverFrameType       = 1
+	serverFrameID         = 2
+	serverFrameRoom       = 3
+	serverFramePayload    = 4
+	serverFrameTimestamp  = 5
+	serverFrameAttributes = 6
+	serverFrameSeq        = 7
+
+	clientFrameType    = 1
+	clientFrameID      = 2
+	clientFrameMethod  = 3
+	clientFrameRoom    = 4
+	clientFramePayload = 5
+	clientFrameTrace   = 6
+)
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return ProtoProtocol }
+
+func (protoCodec) Binary() bool { return true }
+
+// EncodeServer maps the well-known top-level fields to ServerFrame fields
+// and keeps the rest as a JSON attributes object
+func (protoCodec) EncodeServer(frame []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(frame, &fields); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 0, len(frame))
+	for _, f := range []struct {
+		key string
+		num protowire.Number
+	}{{"type", serverFrameType}, {"id", serverFrameID}, {"room", serverFrameRoom}} {
+		var s string
+		if raw, ok := fields[f.key]; ok && json.Unmarshal(raw, &s) == nil {
+			delete(fields, f.key)
+			b = appendProtoString(b, f.num, s)
+		}
+	}
+	if raw, ok := fields["payload"]; ok {
+		delete(fields, "payload")
+		b = appendProtoBytes(b, serverFramePayload, raw)
+	}
+	var ts time.Time
+	if raw, ok := fields["timestamp"]; ok && json.Unmarshal(raw, &ts) == nil {
+		delete(fields, "timestamp")
+		b = protowire.AppendTag(b, serverFrameTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(ts.UnixMilli()))
+	}
+	if len(fields) > 0 {
+		attrs, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		b = appendProtoBytes(b, serverFrameAttributes, attrs)
+	}
+	return b, nil
+}
+
+// DecodeServer rebuilds the JSON frame from a ServerFrame
+func (protoCodec) DecodeServer(data []byte) ([]byte, error) {
+	fields := map[string]interface{}{}
+	err := walkProto(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == serverFrameTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			fields["timestamp"] = time.UnixMilli(int64(v)).UTC()
+			return n, protowire.ParseError(n)
+		case num == serverFrameSeq && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			fields["seq"] = v
+			return n, protowire.ParseError(n)
+		case typ != protowire.BytesType:
+			return -1, nil
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, protowire.ParseError(n)
+		}
+		switch num {
+		case serverFrameType:
+			fields["type"] = string(v)
+		case serverFrameID:
+			fields["id"] = string(v)
+		case serverFrameRoom:
+			fields["room"] = string(v)
+		case serverFramePayload:
+			fields["payload"] = json.RawMessage(v)
+		case serverFrameAttributes:
+			var attrs map[string]json.RawMessage
+			if err := json.Unmarshal(v, &attrs); err != nil {
+				return n, err
+			}
+			for k, a := range attrs {
+				if _, ok := fields[k]; !ok {
+					fields[k] = a
+				}
+			}
+		}
+		return n, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// WithSeq appends the seq field; protobuf fields may come in any order
+func (protoCodec) WithSeq(frame []byte, seq uint64) ([]byte, error) {
+	b := make([]byte, len(frame), len(frame)+11)
+	copy(b, frame)
+	b = protowire.AppendTag(b, serverFrameSeq, protowire.VarintType)
+	return protowire.AppendVarint(b, seq), nil
+}
+
+func (protoCodec) EncodeClient(msg *ClientMessage) ([]byte, error) {
+	var b []byte
+	b = appendProtoString(b, clientFrameType, msg.Type)
+	b = appendProtoString(b, clientFrameID, msg.ID)
+	b = appendProtoString(b, clientFrameMethod, msg.Method)
+	b = appendProtoString(b, clientFrameRoom, msg.Room)
+	if len(msg.Payload) > 0 {
+		b = appendProtoBytes(b, clientFramePayload, msg.Payload)
+	}
+	b = appendProtoString(b, clientFrameTrace, msg.TraceParent)
+	return b, nil
+}
+
+func (protoCodec) DecodeClient(data []byte, msg *ClientMessage) error {
+	*msg = ClientMessage{}
+	return walkProto(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if typ != protowire.BytesType {
+			return -1, nil
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, protowire.ParseError(n)
+		}
+		switch num {
+		case clientFrameType:
+			msg.Type = string(v)
+		case clientFrameID:
+			msg.ID = string(v)
+		case clientFrameMethod:
+			msg.Method = string(v)
+		case clientFrameRoom:
+			msg.Room = string(v)
+		case clientFramePayload:
+			if !json.Valid(v) {
+				return n, fmt.Errorf("proto: payload is not valid JSON")
+			}
+			msg.Payload = append(json.RawMessage(nil), v...)
+		case clientFrameTrace:
+			msg.TraceParent = string(v)
+		}
+		return n, nil
+	})
+}
+
+// walkProto calls field for every field of a message. field returns the
+// bytes it consumed, or -1 to have the value skipped.
+func walkProto(data []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		n, err := field(num, typ, data)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			if n = protowire.ConsumeFieldValue(num, typ, data); n < 0 {
+				return protowire.ParseError(n)
+			}
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendProtoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
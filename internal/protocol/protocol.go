@@ -0,0 +1,78 @@
+// Package protocol defines the client wire format: the message types and
+// the codecs negotiated with the Sec-WebSocket-Protocol header.
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Subprotocols, in the order the gateway prefers them
+const (
+	JSONProtocol    = "attchat.json.v1"
+	MsgPackProtocol = "attchat.msgpack.v1"
+	ProtoProtocol   = "attchat.proto.v1"
+)
+
+// ClientMessage represents a message from client
+type ClientMessage struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`     // correlation id, required for rpc
+	Method  string          `json:"method,omitempty"` // rpc method name
+	Room    string          `json:"room,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	TraceParent string `json:"traceparent,omitempty"` // W3C trace context to continue
+}
+
+// ServerMessage represents a message to client
+type ServerMessage struct {
+	Type      string          `json:"type"`
+	ID        string          `json:"id,omitempty"`
+	Room      string          `json:"room,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// Codec converts frames between JSON, the gateway's internal format, and
+// a wire format. Server frames are produced as JSON once and transcoded
+// per codec (see Frame); client messages are decoded straight into
+// ClientMessage.
+type Codec interface {
+	// Name is the subprotocol that selects this codec
+	Name() string
+	// Binary reports whether frames are sent as binary WebSocket messages
+	Binary() bool
+	// EncodeServer transcodes a JSON server frame to the wire format
+	EncodeServer(frame []byte) ([]byte, error)
+	// DecodeServer transcodes a wire server frame back to JSON
+	DecodeServer(data []byte) ([]byte, error)
+	// EncodeClient encodes a client message
+	EncodeClient(msg *ClientMessage) ([]byte, error)
+	// DecodeClient decodes a client message
+	DecodeClient(data []byte, msg *ClientMessage) error
+	// WithSeq adds a "seq" field to an encoded server frame without
+	// re-encoding it
+	WithSeq(frame []byte, seq uint64) ([]byte, error)
+}
+
+// Codecs registered by subprotocol
+var codecs = map[string]Codec{
+	JSONProtocol:    JSON,
+	MsgPackProtocol: MsgPack,
+	ProtoProtocol:   Proto,
+}
+
+// Subprotocols returns the subprotocols the gateway accepts
+func Subprotocols() []string {
+	return []string{JSONProtocol, MsgPackProtocol, ProtoProtocol}
+}
+
+// ForName returns the codec for a negotiated subprotocol. Clients that do
+// not ask for one get JSON.
+func ForName(subprotocol string) Codec {
+	if c, ok := codecs[subprotocol]; ok {
+		return c
+	}
+	return JSON
+}
@@ -0,0 +1,21 @@
+package protocol
+
+import "testing"
+
+// Every codec carries the client's traceparent
+func TestClientTraceParentRoundTrip(t *testing.T) {
+	want := ClientMessage{Type: "message", Room: "chat:1", TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	for _, codec := range []Codec{JSON, MsgPack, Proto} {
+		data, err := codec.EncodeClient(&want)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		var got ClientMessage
+		if err := codec.DecodeClient(data, &got); err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		if got.TraceParent != want.TraceParent || got.Type != want.Type || got.Room != want.Room {
+			t.Errorf("%s: round trip = %+v, want %+v", codec.Name(), got, want)
+		}
+	}
+}
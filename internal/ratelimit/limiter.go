@@ -0,0 +1,255 @@
+// Package ratelimit limits client messages per connection and per user
+// with token buckets.
+package ratelimit
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/attchat/attchat-gateway/internal/room"
+)
+
+// idleTimeout is how long unused per-connection and per-user state is kept.
+// A bucket idle this long has refilled, so dropping it changes nothing.
+const idleTimeout = 5 * time.Minute
+
+// UserLimit is the limit name reported for the per-user aggregate
+const UserLimit = "user"
+
+type rule struct {
+	msgType  string // "*" matches any
+	userType string // "" matches any
+	rate     float64
+	burst    int
+	name     string
+}
+
+// specificity ranks rules: an exact type beats a user type beats "*"
+func (r *rule) specificity() int {
+	score := 0
+	if r.msgType != "*" {
+		score += 2
+	}
+	if r.userType != "" {
+		score++
+	}
+	return score
+}
+
+// Decision is the result of Allow
+type Decision struct {
+	Allowed    bool
+	Limit      string        // the limit that rejected the message
+	RetryAfter time.Duration // until the limit has a token again
+	Disconnect bool          // sustained abuse: the connection should be closed
+}
+
+// Limiter applies the configured limits
+type Limiter struct {
+	rules           []rule
+	userRate        float64
+	userBurst       int
+	maxViolations   int
+	violationWindow time.Duration
+
+	conns sync.Map // conn ID -> *connState
+	users sync.Map // brand-qualified user ID -> *userState
+	done  chan struct{}
+}
+
+type connState struct {
+	mu          sync.Mutex
+	buckets     map[int]*Bucket // by rule index
+	violations  int
+	windowStart time.Time
+	lastSeen    time.Time
+}
+
+type userState struct {
+	bucket   *Bucket
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+func init() {
+	config.RegisterCheck(func(c *config.Config) error {
+		if !c.RateLimit.Enabled {
+			return nil
+		}
+		_, err := newLimiter(c.RateLimit)
+		return err
+	})
+}
+
+// New validates the rules and starts a sweeper for idle state
+func New(cfg config.RateLimitConfig) (*Limiter, error) {
+	l, err := newLimiter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	go l.sweep()
+	return l, nil
+}
+
+// newLimiter validates the rules and builds a limiter without its sweeper
+func newLimiter(cfg config.RateLimitConfig) (*Limiter, error) {
+	l := &Limiter{
+		userRate:        cfg.UserRate,
+		userBurst:       cfg.UserBurst,
+		maxViolations:   cfg.MaxViolations,
+		violationWindow: cfg.ViolationWindow,
+		done:            make(chan struct{}),
+	}
+	for i, r := range cfg.Rules {
+		msgType := strings.TrimSpace(r.Type)
+		if msgType == "" || r.Rate <= 0 || r.Burst < 1 {
+			return nil, fmt.Errorf("ratelimit.rules[%d]: type, a positive rate and a burst of at least 1 are required", i)
+		}
+		name := "type:" + msgType
+		if r.UserType != "" {
+			name += "/" + r.UserType
+		}
+		l.rules = append(l.rules, rule{msgType: msgType, userType: r.UserType, rate: r.Rate, burst: r.Burst, name: name})
+	}
+	if l.userRate > 0 && l.userBurst < 1 {
+		return nil, fmt.Errorf("ratelimit.user_burst must be at least 1 when ratelimit.user_rate is set")
+	}
+	if l.violationWindow <= 0 {
+		l.violationWindow = 10 * time.Second
+	}
+	return l, nil
+}
+
+// Allow decides whether a connection may send a message of msgType. Users
+// are limited within their brand: the same user ID in two brands is two users.
+func (l *Limiter) Allow(connID, brandID, userID, userType, msgType string) Decision {
+	now := time.Now()
+
+	cs := l.connState(connID, now)
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.lastSeen = now
+
+	var connBucket *Bucket
+	if i := l.match(msgType, userType); i >= 0 {
+		b, ok := cs.buckets[i]
+		if !ok {
+			b = NewBucket(l.rules[i].rate, l.rules[i].burst)
+			cs.buckets[i] = b
+		}
+		if ok, wait := b.Allow(now); !ok {
+			return l.reject(cs, now, l.rules[i].name, wait)
+		}
+		connBucket = b
+	}
+
+	if l.userRate > 0 && userID != "" {
+		us := l.userState(room.QualifiedRoom(brandID, userID), now)
+		if ok, wait := us.bucket.Allow(now); !ok {
+			// The message is not sent, so it does not count against the connection
+			if connBucket != nil {
+				connBucket.refund()
+			}
+			return l.reject(cs, now, UserLimit, wait)
+		}
+	}
+	return Decision{Allowed: true}
+}
+
+// reject records a violation; cs.mu is held
+func (l *Limiter) reject(cs *connState, now time.Time, limit string, wait time.Duration) Decision {
+	if now.Sub(cs.windowStart) > l.violationWindow {
+		cs.windowStart = now
+		cs.violations = 0
+	}
+	cs.violations++
+	return Decision{
+		Limit:      limit,
+		RetryAfter: wait,
+		Disconnect: l.maxViolations > 0 && cs.violations >= l.maxViolations,
+	}
+}
+
+// match returns the index of the most specific rule for a message, or -1
+func (l *Limiter) match(msgType, userType string) int {
+	best, bestScore := -1, -1
+	for i := range l.rules {
+		r := &l.rules[i]
+		if (r.msgType != "*" && r.msgType != msgType) || (r.userType != "" && !strings.EqualFold(r.userType, userType)) {
+			continue
+		}
+		if score := r.specificity(); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+func (l *Limiter) connState(connID string, now time.Time) *connState {
+	if v, ok := l.conns.Load(connID); ok {
+		return v.(*connState)
+	}
+	v, _ := l.conns.LoadOrStore(connID, &connState{buckets: make(map[int]*Bucket), windowStart: now})
+	return v.(*connState)
+}
+
+// Forget drops the state of a closed connection
+func (l *Limiter) Forget(connID string) {
+	l.conns.Delete(connID)
+}
+
+// userState returns the state of a brand-qualified user
+func (l *Limiter) userState(key string, now time.Time) *userState {
+	v, ok := l.users.Load(key)
+	if !ok {
+		v, _ = l.users.LoadOrStore(key, &userState{bucket: NewBucket(l.userRate, l.userBurst)})
+	}
+	us := v.(*userState)
+	us.mu.Lock()
+	us.lastSeen = now
+	us.mu.Unlock()
+	return us
+}
+
+// sweep drops state that has been idle long enough to be full again
+func (l *Limiter) sweep() {
+	ticker := time.NewTicker(idleTimeout)
+	defer ticker.Stop()
+
+	for {
+		var now time.Time
+		select {
+		case <-l.done:
+			return
+		case now = <-ticker.C:
+		}
+		l.conns.Range(func(key, value interface{}) bool {
+			cs := value.(*connState)
+			cs.mu.Lock()
+			idle := now.Sub(cs.lastSeen) > idleTimeout
+			cs.mu.Unlock()
+			if idle {
+				l.conns.Delete(key)
+			}
+			return true
+		})
+		l.users.Range(func(key, value interface{}) bool {
+			us := value.(*userState)
+			us.mu.Lock()
+			idle := now.Sub(us.lastSeen) > idleTimeout
+			us.mu.Unlock()
+			if idle {
+				l.users.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// Close stops the sweeper of a limiter that was replaced
+func (l *Limiter) Close() {
+	close(l.done)
+}
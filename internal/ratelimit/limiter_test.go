@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/attchat/attchat-gateway/internal/config"
+)
+
+// slow refills so slowly that no token comes back during a test
+const slow = 0.001
+
+func newTestLimiter(t *testing.T, ruleBurst, userBurst int) *Limiter {
+	t.Helper()
+	l, err := newLimiter(config.RateLimitConfig{
+		Rules:     []config.RateLimitRule{{Type: "*", Rate: slow, Burst: ruleBurst}},
+		UserRate:  slow,
+		UserBurst: userBurst,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// The same user ID in two brands is two users
+func TestUserLimitPerBrand(t *testing.T) {
+	l := newTestLimiter(t, 10, 1)
+
+	if d := l.Allow("c1", "b1", "7", "customer", "message"); !d.Allowed {
+		t.Fatalf("b1 user 7: %+v", d)
+	}
+	if d := l.Allow("c2", "b2", "7", "customer", "message"); !d.Allowed {
+		t.Errorf("b2 user 7 limited by b1 user 7: %+v", d)
+	}
+	if d := l.Allow("c3", "b1", "7", "customer", "message"); d.Allowed || d.Limit != UserLimit {
+		t.Errorf("second tab of b1 user 7 = %+v, want the user limit", d)
+	}
+}
+
+// A message the user bucket rejects leaves the connection's token
+func TestUserLimitKeepsConnectionToken(t *testing.T) {
+	l := newTestLimiter(t, 1, 1)
+
+	// Another tab uses up the user's token
+	if d := l.Allow("c1", "b1", "7", "customer", "message"); !d.Allowed {
+		t.Fatalf("first message: %+v", d)
+	}
+	if d := l.Allow("c2", "b1", "7", "customer", "message"); d.Allowed || d.Limit != UserLimit {
+		t.Fatalf("second tab = %+v, want the user limit", d)
+	}
+
+	// The user has a token again; c2 still has its own
+	l.users.Range(func(_, v interface{}) bool {
+		v.(*userState).bucket.refund()
+		return true
+	})
+	if d := l.Allow("c2", "b1", "7", "customer", "message"); !d.Allowed {
+		t.Errorf("second tab after the user limit = %+v, want allowed", d)
+	}
+}
+
+func TestRuleLimit(t *testing.T) {
+	l, err := newLimiter(config.RateLimitConfig{
+		Rules: []config.RateLimitRule{
+			{Type: "*", Rate: slow, Burst: 5},
+			{Type: "typing", Rate: slow, Burst: 1},
+		},
+		MaxViolations: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := l.Allow("c1", "b1", "7", "customer", "typing"); !d.Allowed {
+		t.Fatalf("first typing: %+v", d)
+	}
+	d := l.Allow("c1", "b1", "7", "customer", "typing")
+	if d.Allowed || d.Limit != "type:typing" || d.RetryAfter <= 0 || d.Disconnect {
+		t.Errorf("second typing = %+v", d)
+	}
+	if d := l.Allow("c1", "b1", "7", "customer", "message"); !d.Allowed {
+		t.Errorf("message limited by the typing rule: %+v", d)
+	}
+	if d := l.Allow("c1", "b1", "7", "customer", "typing"); !d.Disconnect {
+		t.Errorf("second violation = %+v, want Disconnect", d)
+	}
+}
+
+// A forgotten connection starts again with a full bucket
+func TestForget(t *testing.T) {
+	l := newTestLimiter(t, 1, 10)
+
+	if d := l.Allow("c1", "b1", "7", "customer", "message"); !d.Allowed {
+		t.Fatalf("first message: %+v", d)
+	}
+	l.Forget("c1")
+	if _, ok := l.conns.Load("c1"); ok {
+		t.Fatal("state kept after Forget")
+	}
+	if d := l.Allow("c1", "b1", "7", "customer", "message"); !d.Allowed {
+		t.Errorf("message after Forget: %+v", d)
+	}
+}
@@ -1,38 +1,84 @@
 package room
 
 import (
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gofiber/contrib/websocket"
+	"github.com/attchat/attchat-gateway/internal/brand"
+	"github.com/attchat/attchat-gateway/internal/protocol"
 	"github.com/rs/zerolog/log"
 )
 
-// Connection represents a WebSocket connection with metadata
+// Errors for frames that were not queued
+var (
+	ErrConnectionClosed = errors.New("connection closed")
+	ErrSendBufferFull   = errors.New("send buffer full")
+)
+
+// WebSocket close codes used when the gateway closes a connection
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	ClosePolicyViolation = 1008
+)
+
+// Transports a connection can use
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+	TransportPoll      = "poll"
+)
+
+// Connection represents a client connection with metadata.
+// It is transport-agnostic: queued frames are written through a Sink by
+// Pump, or pulled from SendChannel by long-poll requests.
 type Connection struct {
-	ID        string
-	Conn      *websocket.Conn
-	UserID    string
-	BrandID   string
-	Role      string
-	Type      string // "cskh" or "customer"
-	Device    string // thiết bị
-	Tags      string // tags
-	Timezone  string // múi giờ
-	Channel   string // kênh
-	Rooms     map[string]bool
-	CreatedAt time.Time
-	LastPing  time.Time
-	mu        sync.RWMutex
-	closed    bool
-	send      chan []byte
+	ID            string
+	Transport     string
+	UserID        string
+	BrandID       string
+	Role          string
+	Type          string         // "cskh" or "customer"
+	ClaimType     string         // user type from the JWT; Type may come from ?type=
+	Device        string         // thiết bị
+	Tags          string         // tags
+	Timezone      string         // múi giờ
+	Channel       string         // kênh
+	RemoteIP      string         // client IP (the forwarded one behind trusted proxies)
+	Codec         protocol.Codec // wire format negotiated with the client
+	PlatformAdmin bool           // may join and publish to other brands' rooms
+	Sequenced     bool           // number frames with a per-connection "seq"
+	Rooms         map[string]bool
+	CreatedAt     time.Time
+	LastPing      time.Time
+	mu            sync.RWMutex
+	closed        bool
+	closeCode     int
+	closeReason   string
+	sendMu        sync.Mutex // orders seq assignment with enqueueing
+	seq           uint64
+	send          chan Outgoing
+	settings      atomic.Pointer[brand.Settings] // effective brand settings, swapped on overlay changes
+	rpcs          atomic.Int32                   // RPCs waiting for a reply
+}
+
+// unsetSettings apply to connections whose brand settings were never resolved
+var unsetSettings = &brand.Settings{Typing: true}
+
+// Outgoing is a queued frame with the connection's sequence number for it
+// (0 when the connection is not sequenced)
+type Outgoing struct {
+	Frame *protocol.Frame
+	Seq   uint64
 }
 
 // NewConnection creates a new connection wrapper
-func NewConnection(id string, conn *websocket.Conn, userID, brandID, role, userType string) *Connection {
+func NewConnection(id, transport, userID, brandID, role, userType string) *Connection {
 	c := &Connection{
 		ID:        id,
-		Conn:      conn,
+		Transport: transport,
 		UserID:    userID,
 		BrandID:   brandID,
 		Role:      role,
@@ -40,7 +86,8 @@ func NewConnection(id string, conn *websocket.Conn, userID, brandID, role, userT
 		Rooms:     make(map[string]bool),
 		CreatedAt: time.Now(),
 		LastPing:  time.Now(),
-		send:      make(chan []byte, 256),
+		Codec:     protocol.JSON,
+		send:      make(chan Outgoing, 256),
 	}
 
 	// Auto-join default rooms based on user type
@@ -51,21 +98,42 @@ func NewConnection(id string, conn *websocket.Conn, userID, brandID, role, userT
 
 // joinDefaultRooms joins rooms based on user type
 func (c *Connection) joinDefaultRooms() {
-	// Join user-specific room
-	c.JoinRoom("user:" + c.UserID)
+	for _, roomID := range c.defaultRooms() {
+		c.JoinRoom(roomID)
+	}
+}
+
+// defaultRooms are the rooms a connection is in from the start
+func (c *Connection) defaultRooms() []string {
+	// User-specific room
+	rooms := []string{"user:" + c.UserID}
 
-	// Join brand room
+	// Brand room
 	if c.BrandID != "" {
-		c.JoinRoom("brand:" + c.BrandID)
+		rooms = append(rooms, "brand:"+c.BrandID)
 	}
 
-	// CSKH joins additional rooms
+	// CSKH joins folder/inbox rooms
 	if c.Type == "cskh" {
-		// Join folder/inbox rooms
-		c.JoinRoom("folder:" + c.BrandID + ":all")
-		c.JoinRoom("folder:" + c.BrandID + ":waiting")
-		c.JoinRoom("folder:" + c.BrandID + ":active")
+		rooms = append(rooms,
+			"folder:"+c.BrandID+":all",
+			"folder:"+c.BrandID+":waiting",
+			"folder:"+c.BrandID+":active",
+		)
 	}
+	return rooms
+}
+
+// IsDefaultRoom reports whether roomID is one of the connection's default
+// rooms, which clients may not leave
+func (c *Connection) IsDefaultRoom(roomID string) bool {
+	roomID = c.ownRoom(roomID)
+	for _, r := range c.defaultRooms() {
+		if r == roomID {
+			return true
+		}
+	}
+	return false
 }
 
 // JoinRoom adds connection to a room
@@ -110,17 +178,33 @@ func (c *Connection) GetRooms() []string {
 	return rooms
 }
 
-// Send sends a message to this connection
+// Send sends a JSON-encoded message to this connection
 func (c *Connection) Send(message []byte) error {
+	return c.SendFrame(protocol.NewFrame(message))
+}
+
+// SendFrame queues a frame that may be shared with other connections. It
+// returns ErrConnectionClosed or ErrSendBufferFull when the frame is dropped.
+// Dropped frames still use up a sequence number, so sequenced clients can
+// detect the gap.
+func (c *Connection) SendFrame(frame *protocol.Frame) error {
+	// Hold the read lock so CloseWith cannot close the channel mid-send
 	c.mu.RLock()
+	defer c.mu.RUnlock()
 	if c.closed {
-		c.mu.RUnlock()
-		return nil
+		return ErrConnectionClosed
+	}
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	out := Outgoing{Frame: frame}
+	if c.Sequenced {
+		c.seq++
+		out.Seq = c.seq
 	}
-	c.mu.RUnlock()
 
 	select {
-	case c.send <- message:
+	case c.send <- out:
 		return nil
 	default:
 		// Buffer full, connection is slow
@@ -128,15 +212,37 @@ func (c *Connection) Send(message []byte) error {
 			Str("conn_id", c.ID).
 			Str("user_id", c.UserID).
 			Msg("Send buffer full, dropping message")
-		return nil
+		return ErrSendBufferFull
+	}
+}
+
+// Encode returns a queued frame in the connection's wire format, with its
+// sequence number spliced in
+func (c *Connection) Encode(out Outgoing) ([]byte, error) {
+	data, err := out.Frame.Encode(c.Codec)
+	if err != nil || out.Seq == 0 {
+		return data, err
 	}
+	return c.Codec.WithSeq(data, out.Seq)
 }
 
 // SendChannel returns the send channel for writing
-func (c *Connection) SendChannel() <-chan []byte {
+func (c *Connection) SendChannel() <-chan Outgoing {
 	return c.send
 }
 
+// QueueDepth returns the number of messages waiting to be written
+func (c *Connection) QueueDepth() int {
+	return len(c.send)
+}
+
+// LastPingTime returns when the client was last heard from
+func (c *Connection) LastPingTime() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.LastPing
+}
+
 // UpdateLastPing updates the last ping time
 func (c *Connection) UpdateLastPing() {
 	c.mu.Lock()
@@ -153,10 +259,53 @@ func (c *Connection) IsClosed() bool {
 
 // Close marks the connection as closed
 func (c *Connection) Close() {
+	c.CloseWith(CloseNormal, "")
+}
+
+// CloseWith marks the connection as closed with a close code and reason
+// for the writer to send; messages already queued are still delivered
+func (c *Connection) CloseWith(code int, reason string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if !c.closed {
 		c.closed = true
+		c.closeCode = code
+		c.closeReason = reason
 		close(c.send)
 	}
 }
+
+// Settings returns the connection's effective brand settings
+func (c *Connection) Settings() *brand.Settings {
+	if settings := c.settings.Load(); settings != nil {
+		return settings
+	}
+	return unsetSettings
+}
+
+// SetSettings replaces the connection's effective brand settings
+func (c *Connection) SetSettings(settings *brand.Settings) {
+	c.settings.Store(settings)
+}
+
+// StartRPC counts an RPC waiting for its reply. It returns false, counting
+// nothing, when max are waiting already.
+func (c *Connection) StartRPC(max int) bool {
+	if c.rpcs.Add(1) > int32(max) {
+		c.rpcs.Add(-1)
+		return false
+	}
+	return true
+}
+
+// EndRPC ends an RPC counted by StartRPC
+func (c *Connection) EndRPC() {
+	c.rpcs.Add(-1)
+}
+
+// CloseInfo returns the close code and reason set when the connection was closed
+func (c *Connection) CloseInfo() (int, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.closeCode, c.closeReason
+}
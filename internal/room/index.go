@@ -0,0 +1,194 @@
+package room
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// indexShards spreads keys over independently locked shards
+const indexShards = 64
+
+// shardOf hashes a key to a shard (FNV-1a)
+func shardOf(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h % indexShards
+}
+
+// index maps a key (room or user ID) to the set of its connections. A set
+// is created and deleted under its shard lock together with its members,
+// so a join can never land in a set that is concurrently being deleted.
+type index struct {
+	shards [indexShards]indexShard
+	keys   atomic.Int64 // non-empty sets
+}
+
+type indexShard struct {
+	mu   sync.RWMutex
+	sets map[string]map[string]*Connection
+}
+
+func newIndex() *index {
+	ix := &index{}
+	for i := range ix.shards {
+		ix.shards[i].sets = make(map[string]map[string]*Connection)
+	}
+	return ix
+}
+
+// add puts conn in key's set and reports whether the set was created
+func (ix *index) add(key string, conn *Connection) bool {
+	sh := &ix.shards[shardOf(key)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	set, ok := sh.sets[key]
+	if !ok {
+		set = make(map[string]*Connection)
+		sh.sets[key] = set
+		ix.keys.Add(1)
+	}
+	set[conn.ID] = conn
+	return !ok
+}
+
+// remove takes connID out of key's set and reports whether the set was
+// deleted because it became empty
+func (ix *index) remove(key, connID string) bool {
+	sh := &ix.shards[shardOf(key)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	set, ok := sh.sets[key]
+	if !ok {
+		return false
+	}
+	delete(set, connID)
+	if len(set) > 0 {
+		return false
+	}
+	delete(sh.sets, key)
+	ix.keys.Add(-1)
+	return true
+}
+
+// members returns a snapshot of key's set
+func (ix *index) members(key string) []*Connection {
+	sh := &ix.shards[shardOf(key)]
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	set := sh.sets[key]
+	conns := make([]*Connection, 0, len(set))
+	for _, conn := range set {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// each calls fn for every member of key's set without copying it. fn runs
+// under the shard's read lock and must not modify the index.
+func (ix *index) each(key string, fn func(conn *Connection)) {
+	sh := &ix.shards[shardOf(key)]
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	for _, conn := range sh.sets[key] {
+		fn(conn)
+	}
+}
+
+// count returns the exact size of key's set
+func (ix *index) count(key string) int {
+	sh := &ix.shards[shardOf(key)]
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	return len(sh.sets[key])
+}
+
+// len returns the number of non-empty sets
+func (ix *index) len() int64 {
+	return ix.keys.Load()
+}
+
+// sizes calls fn with every key and its set size
+func (ix *index) sizes(fn func(key string, size int)) {
+	for i := range ix.shards {
+		sh := &ix.shards[i]
+		sh.mu.RLock()
+		for key, set := range sh.sets {
+			fn(key, len(set))
+		}
+		sh.mu.RUnlock()
+	}
+}
+
+// connTable is the sharded set of all connections by ID
+type connTable struct {
+	shards [indexShards]connShard
+	size   atomic.Int64
+}
+
+type connShard struct {
+	mu    sync.RWMutex
+	conns map[string]*Connection
+}
+
+func newConnTable() *connTable {
+	t := &connTable{}
+	for i := range t.shards {
+		t.shards[i].conns = make(map[string]*Connection)
+	}
+	return t
+}
+
+func (t *connTable) store(conn *Connection) {
+	sh := &t.shards[shardOf(conn.ID)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.conns[conn.ID]; !ok {
+		t.size.Add(1)
+	}
+	sh.conns[conn.ID] = conn
+}
+
+func (t *connTable) load(id string) (*Connection, bool) {
+	sh := &t.shards[shardOf(id)]
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	conn, ok := sh.conns[id]
+	return conn, ok
+}
+
+// loadAndDelete removes a connection; only one caller gets it
+func (t *connTable) loadAndDelete(id string) (*Connection, bool) {
+	sh := &t.shards[shardOf(id)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	conn, ok := sh.conns[id]
+	if ok {
+		delete(sh.conns, id)
+		t.size.Add(-1)
+	}
+	return conn, ok
+}
+
+// rangeAll calls fn for every connection, one shard at a time, under the
+// shard's read lock
+func (t *connTable) rangeAll(fn func(conn *Connection)) {
+	for i := range t.shards {
+		sh := &t.shards[i]
+		sh.mu.RLock()
+		for _, conn := range sh.conns {
+			fn(conn)
+		}
+		sh.mu.RUnlock()
+	}
+}
+
+func (t *connTable) len() int64 {
+	return t.size.Load()
+}
@@ -0,0 +1,113 @@
+package room
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// has reports whether id is in key's set
+func (ix *index) has(key, id string) bool {
+	found := false
+	ix.each(key, func(conn *Connection) { found = found || conn.ID == id })
+	return found
+}
+
+// A member added to a key is there until it is removed, even while other
+// members churn in and out of the key, deleting and recreating its set.
+// Run with -race.
+func TestIndexConcurrentAddRemove(t *testing.T) {
+	ix := newIndex()
+	const workers, rounds = 32, 2000
+
+	var wg sync.WaitGroup
+	var lost atomic.Int64
+	start := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		conn := &Connection{ID: fmt.Sprint("c", i)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for r := 0; r < rounds; r++ {
+				ix.add("room", conn)
+				if !ix.has("room", conn.ID) {
+					lost.Add(1)
+				}
+				ix.remove("room", conn.ID)
+			}
+			// Every worker ends up a member
+			ix.add("room", conn)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if n := lost.Load(); n > 0 {
+		t.Errorf("%d joins were lost to a concurrent remove", n)
+	}
+	if got := ix.count("room"); got != workers {
+		t.Errorf("count = %d, want %d", got, workers)
+	}
+	if got := ix.len(); got != 1 {
+		t.Errorf("len = %d, want 1", got)
+	}
+
+	for i := 0; i < workers; i++ {
+		ix.remove("room", fmt.Sprint("c", i))
+	}
+	if ix.len() != 0 || ix.count("room") != 0 {
+		t.Errorf("emptied set kept: len = %d", ix.len())
+	}
+}
+
+// Connections joining a room while other members disconnect stay in it,
+// and a connection that joins while it is being disconnected does not.
+// Run with -race.
+func TestManagerConcurrentJoinDisconnect(t *testing.T) {
+	for round := 0; round < 50; round++ {
+		m := NewManager()
+		const n = 100
+		for i := 0; i < 2*n; i++ {
+			m.AddConnection(NewConnection(fmt.Sprint("c", i), "memory", fmt.Sprint(i), "b1", "", "customer"))
+		}
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for i := 0; i < n; i++ {
+			leaving, staying := fmt.Sprint("c", i), fmt.Sprint("c", n+i)
+			wg.Add(3)
+			// The leaving connection joins and is disconnected at once
+			go func() {
+				defer wg.Done()
+				<-start
+				m.JoinRoom(leaving, "chat:1")
+			}()
+			go func() {
+				defer wg.Done()
+				<-start
+				m.Disconnect(leaving, CloseGoingAway, "")
+			}()
+			go func() {
+				defer wg.Done()
+				<-start
+				if err := m.JoinRoom(staying, "chat:1"); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		members := m.GetRoomConnections(QualifiedRoom("b1", "chat:1"))
+		if len(members) != n {
+			t.Fatalf("round %d: room has %d members, want %d", round, len(members), n)
+		}
+		for _, conn := range members {
+			if conn.IsClosed() {
+				t.Fatalf("round %d: disconnected %s left in the room", round, conn.ID)
+			}
+		}
+	}
+}
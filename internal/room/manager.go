@@ -1,46 +1,74 @@
 package room
 
 import (
-	"sync"
+	"strings"
 	"sync/atomic"
 
 	"github.com/attchat/attchat-gateway/internal/metrics"
+	"github.com/attchat/attchat-gateway/internal/protocol"
 	"github.com/rs/zerolog/log"
 )
 
-// Manager manages all connections and rooms
+// Manager manages all connections and rooms.
+// Rooms and users are namespaced by brand: a connection's rooms are keyed
+// within its brand, and broadcasts resolve rooms within the target's brand.
 type Manager struct {
 	// Stats (đặt lên đầu để đảm bảo alignment)
 	totalConnections int64
-	totalRooms       int64
 
 	// All connections indexed by ID
-	connections sync.Map // map[string]*Connection
+	connections *connTable
 
-	// Room to connections mapping
-	rooms sync.Map // map[string]map[string]*Connection
+	// Brand-qualified room to connections mapping
+	rooms *index
 
-	// User to connections mapping (for multi-tab support)
-	userConnections sync.Map // map[string]map[string]*Connection
+	// Brand-qualified user to connections mapping (for multi-tab support)
+	userConnections *index
+
+	// Client IP to connections mapping (for admission limits)
+	ipConnections *index
+
+	// Brand to connections mapping (for quotas, settings and brand filters)
+	brandConnections *index
+
+	// Called with every connection Disconnect removes
+	onDisconnect []func(conn *Connection)
 }
 
 // NewManager creates a new room manager
 func NewManager() *Manager {
-	return &Manager{}
+	return &Manager{
+		connections:      newConnTable(),
+		rooms:            newIndex(),
+		userConnections:  newIndex(),
+		ipConnections:    newIndex(),
+		brandConnections: newIndex(),
+	}
+}
+
+// OnDisconnect registers fn to be called with every removed connection, after
+// it is closed. Call it before connections are added.
+func (m *Manager) OnDisconnect(fn func(conn *Connection)) {
+	m.onDisconnect = append(m.onDisconnect, fn)
 }
 
 // AddConnection adds a new connection
 func (m *Manager) AddConnection(conn *Connection) {
 	// Store connection
-	m.connections.Store(conn.ID, conn)
+	m.connections.store(conn)
 
 	// Add to user's connections (multi-tab support)
-	userConns, _ := m.userConnections.LoadOrStore(conn.UserID, &sync.Map{})
-	userConns.(*sync.Map).Store(conn.ID, conn)
+	m.userConnections.add(conn.userKey(), conn)
+	if conn.RemoteIP != "" {
+		m.ipConnections.add(conn.RemoteIP, conn)
+	}
+	if conn.BrandID != "" {
+		m.brandConnections.add(conn.BrandID, conn)
+	}
 
 	// Add to all rooms
-	for room := range conn.Rooms {
-		m.addToRoom(room, conn)
+	for _, room := range conn.GetRooms() {
+		m.addToRoom(conn.roomKey(room), conn)
 	}
 
 	// Update stats
@@ -59,69 +87,115 @@ func (m *Manager) AddConnection(conn *Connection) {
 
 // RemoveConnection removes a connection
 func (m *Manager) RemoveConnection(connID string) {
-	connInterface, ok := m.connections.Load(connID)
-	if !ok {
-		return
+	m.Disconnect(connID, CloseNormal, "")
+}
+
+// Disconnect removes a connection and closes it with the given code and reason.
+// It returns false if the connection is not known.
+func (m *Manager) Disconnect(connID string, code int, reason string) bool {
+	conn, loaded := m.connections.loadAndDelete(connID)
+	if !loaded {
+		return false
 	}
 
-	conn := connInterface.(*Connection)
+	// Mark as closed first: a concurrent JoinRoom that misses the room
+	// snapshot below sees the connection closed and undoes itself
+	conn.CloseWith(code, reason)
 
 	// Remove from all rooms
-	for room := range conn.Rooms {
-		m.removeFromRoom(room, connID)
+	for _, room := range conn.GetRooms() {
+		m.removeFromRoom(conn.roomKey(room), connID)
 	}
 
 	// Remove from user's connections
-	if userConns, ok := m.userConnections.Load(conn.UserID); ok {
-		userConns.(*sync.Map).Delete(connID)
+	m.userConnections.remove(conn.userKey(), connID)
+	if conn.RemoteIP != "" {
+		m.ipConnections.remove(conn.RemoteIP, connID)
+	}
+	if conn.BrandID != "" {
+		m.brandConnections.remove(conn.BrandID, connID)
 	}
-
-	// Remove connection
-	m.connections.Delete(connID)
-
-	// Mark as closed
-	conn.Close()
 
 	// Update stats
 	metrics.ConnectionsCurrent.Dec()
 
+	for _, fn := range m.onDisconnect {
+		fn(conn)
+	}
+
 	log.Info().
 		Str("conn_id", conn.ID).
 		Str("user_id", conn.UserID).
+		Str("reason", reason).
 		Msg("Connection removed")
+	return true
+}
+
+// Connections returns a snapshot of all connections
+func (m *Manager) Connections() []*Connection {
+	connections := make([]*Connection, 0, m.connections.len())
+	m.connections.rangeAll(func(conn *Connection) {
+		connections = append(connections, conn)
+	})
+	return connections
 }
 
 // GetConnection gets a connection by ID
 func (m *Manager) GetConnection(connID string) (*Connection, bool) {
-	if conn, ok := m.connections.Load(connID); ok {
-		return conn.(*Connection), true
-	}
-	return nil, false
+	return m.connections.load(connID)
 }
 
-// GetUserConnections gets all connections for a user
-func (m *Manager) GetUserConnections(userID string) []*Connection {
-	var connections []*Connection
+// Count returns the number of open connections
+func (m *Manager) Count() int {
+	return int(m.connections.len())
+}
 
-	if userConns, ok := m.userConnections.Load(userID); ok {
-		userConns.(*sync.Map).Range(func(_, value interface{}) bool {
-			connections = append(connections, value.(*Connection))
-			return true
-		})
-	}
+// UserConnectionCount returns the number of a user's open connections
+func (m *Manager) UserConnectionCount(brandID, userID string) int {
+	return m.userConnections.count(QualifiedRoom(brandID, userID))
+}
 
-	return connections
+// IPConnectionCount returns the number of open connections from a client IP
+func (m *Manager) IPConnectionCount(ip string) int {
+	return m.ipConnections.count(ip)
+}
+
+// BrandConnectionCount returns the number of open connections of a brand
+func (m *Manager) BrandConnectionCount(brandID string) int {
+	return m.brandConnections.count(brandID)
 }
 
-// JoinRoom adds a connection to a room
-func (m *Manager) JoinRoom(connID, roomID string) {
+// GetBrandConnections gets all connections of a brand
+func (m *Manager) GetBrandConnections(brandID string) []*Connection {
+	return m.brandConnections.members(brandID)
+}
+
+// GetUserConnections gets all connections of a user of a brand
+func (m *Manager) GetUserConnections(brandID, userID string) []*Connection {
+	return m.userConnections.members(QualifiedRoom(brandID, userID))
+}
+
+// JoinRoom adds a connection to a room of its brand, or to a brand-qualified
+// room, which requires a platform admin unless it is the connection's own brand
+func (m *Manager) JoinRoom(connID, roomID string) error {
 	conn, ok := m.GetConnection(connID)
 	if !ok {
-		return
+		return nil
+	}
+	if !conn.CanAccess(roomID) {
+		return ErrCrossBrand
 	}
 
+	roomID = conn.ownRoom(roomID)
+	key := conn.roomKey(roomID)
 	conn.JoinRoom(roomID)
-	m.addToRoom(roomID, conn)
+	m.addToRoom(key, conn)
+
+	// Lost a race with Disconnect: don't leave a closed connection behind
+	if conn.IsClosed() {
+		m.removeFromRoom(key, connID)
+	}
+	return nil
 }
 
 // LeaveRoom removes a connection from a room
@@ -131,75 +205,57 @@ func (m *Manager) LeaveRoom(connID, roomID string) {
 		return
 	}
 
+	roomID = conn.ownRoom(roomID)
 	conn.LeaveRoom(roomID)
-	m.removeFromRoom(roomID, connID)
+	m.removeFromRoom(conn.roomKey(roomID), connID)
 }
 
 // addToRoom adds a connection to a room
 func (m *Manager) addToRoom(roomID string, conn *Connection) {
-	roomConns, loaded := m.rooms.LoadOrStore(roomID, &sync.Map{})
-	roomConns.(*sync.Map).Store(conn.ID, conn)
-
-	if !loaded {
-		atomic.AddInt64(&m.totalRooms, 1)
+	if m.rooms.add(roomID, conn) {
 		metrics.RoomsTotal.Inc()
 	}
 }
 
-// removeFromRoom removes a connection from a room
+// removeFromRoom removes a connection from a room, deleting it once empty
 func (m *Manager) removeFromRoom(roomID, connID string) {
-	if roomConns, ok := m.rooms.Load(roomID); ok {
-		roomConns.(*sync.Map).Delete(connID)
-
-		// Check if room is empty
-		isEmpty := true
-		roomConns.(*sync.Map).Range(func(_, _ interface{}) bool {
-			isEmpty = false
-			return false
-		})
-
-		if isEmpty {
-			m.rooms.Delete(roomID)
-			atomic.AddInt64(&m.totalRooms, -1)
-			metrics.RoomsTotal.Dec()
-		}
+	if m.rooms.remove(roomID, connID) {
+		metrics.RoomsTotal.Dec()
 	}
 }
 
-// BroadcastToRoom sends a message to all connections in a room
-func (m *Manager) BroadcastToRoom(roomID string, message []byte, excludeConnID string) int {
-	roomConns, ok := m.rooms.Load(roomID)
+// BroadcastToRoom sends a message to all connections in a room of a brand
+func (m *Manager) BroadcastToRoom(brandID, roomID string, message []byte, excludeConnID string) int {
+	key, ok := resolveKey(brandID, roomID)
 	if !ok {
 		return 0
 	}
-
+	frame := protocol.NewFrame(message)
 	count := 0
-	roomConns.(*sync.Map).Range(func(connID, connInterface interface{}) bool {
-		if connID.(string) == excludeConnID {
-			return true
+	m.rooms.each(key, func(conn *Connection) {
+		if conn.ID == excludeConnID {
+			return
 		}
-
-		conn := connInterface.(*Connection)
-		if err := conn.Send(message); err == nil {
+		if err := conn.SendFrame(frame); err == nil {
 			count++
 		}
-		return true
 	})
 
 	metrics.MessagesSent.Add(float64(count))
 	return count
 }
 
-// BroadcastToUser sends a message to all connections of a user
-func (m *Manager) BroadcastToUser(userID string, message []byte, excludeConnID string) int {
-	connections := m.GetUserConnections(userID)
+// BroadcastToUser sends a message to all connections of a user of a brand
+func (m *Manager) BroadcastToUser(brandID, userID string, message []byte, excludeConnID string) int {
+	frame := protocol.NewFrame(message)
+	connections := m.GetUserConnections(brandID, userID)
 	count := 0
 
 	for _, conn := range connections {
 		if conn.ID == excludeConnID {
 			continue
 		}
-		if err := conn.Send(message); err == nil {
+		if err := conn.SendFrame(frame); err == nil {
 			count++
 		}
 	}
@@ -207,32 +263,120 @@ func (m *Manager) BroadcastToUser(userID string, message []byte, excludeConnID s
 	return count
 }
 
-// GetStats returns current statistics
-func (m *Manager) GetStats() map[string]int64 {
-	// Count current live connections (sync.Map length)
-	var current int64
-	m.connections.Range(func(_, _ interface{}) bool {
-		current++
-		return true
+// Broadcast sends a message to every connection selected by target.
+// A connection reached through several rooms or users receives it once.
+// Rooms, users and filters are confined to target.Brand when it is set.
+func (m *Manager) Broadcast(target Target, message []byte, excludeConnID string) int {
+	// Rooms and users are keyed by brand already; a filter alone must not
+	// reach past the brand either
+	if target.Brand != "" && len(target.Rooms) == 0 && len(target.UserIDs) == 0 {
+		if target.Filter.BrandID != "" && target.Filter.BrandID != target.Brand {
+			return 0
+		}
+		target.Filter.BrandID = target.Brand
+	}
+
+	frame := protocol.NewFrame(message)
+	seen := make(map[string]struct{})
+	count := 0
+
+	deliver := func(conn *Connection) {
+		if conn.ID == excludeConnID {
+			return
+		}
+		if _, ok := seen[conn.ID]; ok {
+			return
+		}
+		seen[conn.ID] = struct{}{}
+		if !target.Filter.Match(conn) {
+			return
+		}
+		if err := conn.SendFrame(frame); err == nil {
+			count++
+		}
+	}
+
+	switch {
+	case len(target.Rooms) > 0 || len(target.UserIDs) > 0:
+		for _, roomID := range target.Rooms {
+			if key, ok := resolveKey(target.Brand, roomID); ok {
+				m.rooms.each(key, deliver)
+			}
+		}
+		for _, userID := range target.UserIDs {
+			if key, ok := resolveKey(target.Brand, userID); ok {
+				m.userConnections.each(key, deliver)
+			}
+		}
+
+	case target.Filter.BrandID != "":
+		m.brandConnections.each(target.Filter.BrandID, deliver)
+
+	case !target.Filter.IsZero():
+		m.connections.rangeAll(deliver)
+	}
+
+	metrics.MessagesSent.Add(float64(count))
+	return count
+}
+
+// BroadcastAll sends a message to every connection
+func (m *Manager) BroadcastAll(message []byte) int {
+	frame := protocol.NewFrame(message)
+	count := 0
+	m.connections.rangeAll(func(conn *Connection) {
+		if err := conn.SendFrame(frame); err == nil {
+			count++
+		}
 	})
 
+	metrics.MessagesSent.Add(float64(count))
+	return count
+}
+
+// GetStats returns current statistics
+func (m *Manager) GetStats() map[string]int64 {
 	return map[string]int64{
 		"total_connections":   atomic.LoadInt64(&m.totalConnections),
-		"current_connections": current,
-		"total_rooms":         atomic.LoadInt64(&m.totalRooms),
+		"current_connections": m.connections.len(),
+		"total_rooms":         m.rooms.len(),
 	}
 }
 
-// GetRoomConnections returns all connections in a room
-func (m *Manager) GetRoomConnections(roomID string) []*Connection {
-	var connections []*Connection
+// RoomInfo describes a room and its member count
+type RoomInfo struct {
+	ID      string `json:"id"`
+	Members int    `json:"members"`
+}
+
+// ListRooms returns all rooms whose ID starts with prefix
+func (m *Manager) ListRooms(prefix string) []RoomInfo {
+	var rooms []RoomInfo
+	m.rooms.sizes(func(roomID string, members int) {
+		if strings.HasPrefix(roomID, prefix) {
+			rooms = append(rooms, RoomInfo{ID: roomID, Members: members})
+		}
+	})
+	return rooms
+}
 
-	if roomConns, ok := m.rooms.Load(roomID); ok {
-		roomConns.(*sync.Map).Range(func(_, connInterface interface{}) bool {
-			connections = append(connections, connInterface.(*Connection))
-			return true
-		})
+// resolveKey resolves a room or user ID within a brand. A brand cannot
+// address another brand's qualified IDs; the platform scope ("") can.
+func resolveKey(brandID, id string) (string, bool) {
+	if qualifiedBrand, _, qualified := SplitRoom(id); qualified && brandID != "" && qualifiedBrand != brandID {
+		log.Warn().Str("brand_id", brandID).Str("id", id).Msg("Dropped cross-brand delivery")
+		return "", false
 	}
+	return scopedKey(brandID, id), true
+}
 
-	return connections
+// RoomSize returns the exact number of connections in a room. Room IDs
+// here and in ListRooms and GetRoomConnections are brand-qualified.
+func (m *Manager) RoomSize(roomID string) int {
+	return m.rooms.count(roomID)
+}
+
+// GetRoomConnections returns all connections in a room
+func (m *Manager) GetRoomConnections(roomID string) []*Connection {
+	return m.rooms.members(roomID)
 }
@@ -0,0 +1,306 @@
+package room
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/transport"
+)
+
+// pumped adds a connection to m and pumps its frames into a Memory transport
+func pumped(t *testing.T, m *Manager, id, brandID, userID string, rooms ...string) (*Connection, *transport.Memory) {
+	t.Helper()
+	conn := NewConnection(id, "memory", userID, brandID, "", "customer")
+	m.AddConnection(conn)
+	for _, r := range rooms {
+		if err := m.JoinRoom(id, r); err != nil {
+			t.Fatalf("JoinRoom(%s, %s): %v", id, r, err)
+		}
+	}
+	mem := transport.NewMemory()
+	go conn.Pump(mem, 0)
+	t.Cleanup(func() { m.RemoveConnection(id) })
+	return conn, mem
+}
+
+// waitFrames waits until mem holds n frames and returns them
+func waitFrames(t *testing.T, mem *transport.Memory, n int) []string {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		if frames := mem.Frames(); len(frames) >= n {
+			out := make([]string, len(frames))
+			for i, f := range frames {
+				out[i] = string(f)
+			}
+			return out
+		}
+		select {
+		case <-mem.Written():
+		case <-deadline:
+			t.Fatalf("got %d frames, want %d", len(mem.Frames()), n)
+		}
+	}
+}
+
+// settle gives pumps time to write anything that was (wrongly) queued
+func settle() { time.Sleep(20 * time.Millisecond) }
+
+func TestManagerBroadcastToRoom(t *testing.T) {
+	m := NewManager()
+	_, a := pumped(t, m, "a", "b1", "1", "chat:1")
+	_, b := pumped(t, m, "b", "b1", "2", "chat:1")
+	_, other := pumped(t, m, "c", "b1", "3", "chat:2")
+	_, otherBrand := pumped(t, m, "d", "b2", "4", "chat:1")
+
+	msg := `{"type":"message","room":"chat:1"}`
+	if n := m.BroadcastToRoom("b1", "chat:1", []byte(msg), "b"); n != 1 {
+		t.Fatalf("BroadcastToRoom delivered to %d connections, want 1", n)
+	}
+	if got := waitFrames(t, a, 1); got[0] != msg {
+		t.Errorf("member received %s", got[0])
+	}
+	settle()
+	for name, mem := range map[string]*transport.Memory{"excluded": b, "other room": other, "other brand": otherBrand} {
+		if frames := mem.Frames(); len(frames) != 0 {
+			t.Errorf("%s received %q", name, frames)
+		}
+	}
+}
+
+// A connection reached through several rooms and its user gets one copy
+func TestManagerBroadcastOncePerConnection(t *testing.T) {
+	m := NewManager()
+	_, a := pumped(t, m, "a", "b1", "1", "chat:1", "chat:2")
+	_, b := pumped(t, m, "b", "b1", "2", "chat:2")
+
+	target := Target{Brand: "b1", Rooms: []string{"chat:1", "chat:2"}, UserIDs: []string{"1"}}
+	if n := m.Broadcast(target, []byte(`{"type":"notice"}`), ""); n != 2 {
+		t.Fatalf("Broadcast delivered to %d connections, want 2", n)
+	}
+	waitFrames(t, b, 1)
+	waitFrames(t, a, 1)
+	settle()
+	if frames := a.Frames(); len(frames) != 1 {
+		t.Errorf("connection in two rooms received %d copies", len(frames))
+	}
+}
+
+func TestManagerBroadcastFilter(t *testing.T) {
+	m := NewManager()
+	cskh, _ := pumped(t, m, "a", "b1", "1")
+	cskh.Type = "cskh"
+	_, customer := pumped(t, m, "b", "b1", "2")
+	_, otherBrand := pumped(t, m, "c", "b2", "3")
+
+	// A brand-scoped filter reaches past neither the filter nor the brand
+	target := Target{Brand: "b1", Filter: Filter{Types: []string{"customer"}}}
+	if n := m.Broadcast(target, []byte(`{"type":"notice"}`), ""); n != 1 {
+		t.Fatalf("Broadcast delivered to %d connections, want 1", n)
+	}
+	waitFrames(t, customer, 1)
+	settle()
+	if frames := otherBrand.Frames(); len(frames) != 0 {
+		t.Errorf("other brand received %q", frames)
+	}
+
+	target = Target{Brand: "b1", Filter: Filter{BrandID: "b2"}}
+	if n := m.Broadcast(target, []byte(`{"type":"notice"}`), ""); n != 0 {
+		t.Errorf("filter for another brand delivered to %d connections", n)
+	}
+}
+
+func TestManagerJoinLeave(t *testing.T) {
+	m := NewManager()
+	pumped(t, m, "a", "b1", "1")
+	pumped(t, m, "b", "b1", "2")
+	key := QualifiedRoom("b1", "chat:1")
+	rooms := m.GetStats()["total_rooms"]
+
+	if err := m.JoinRoom("a", "chat:1"); err != nil {
+		t.Fatal(err)
+	}
+	// The qualified name of a connection's own brand is the same room
+	if err := m.JoinRoom("b", key); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.RoomSize(key); got != 2 {
+		t.Errorf("RoomSize = %d, want 2", got)
+	}
+	if got := m.GetStats()["total_rooms"]; got != rooms+1 {
+		t.Errorf("total_rooms = %d, want %d", got, rooms+1)
+	}
+	if err := m.JoinRoom("a", QualifiedRoom("b2", "chat:1")); err != ErrCrossBrand {
+		t.Errorf("JoinRoom into another brand = %v, want ErrCrossBrand", err)
+	}
+
+	m.LeaveRoom("a", "chat:1")
+	if conn, _ := m.GetConnection("a"); conn.IsInRoom("chat:1") {
+		t.Error("connection still lists the room it left")
+	}
+	m.LeaveRoom("b", "chat:1")
+	if got := m.RoomSize(key); got != 0 {
+		t.Errorf("RoomSize after leaving = %d", got)
+	}
+	if got := m.GetStats()["total_rooms"]; got != rooms {
+		t.Errorf("empty room kept: total_rooms = %d, want %d", got, rooms)
+	}
+
+	// Unknown connections are ignored
+	if err := m.JoinRoom("gone", "chat:1"); err != nil {
+		t.Errorf("JoinRoom of unknown connection = %v", err)
+	}
+	m.LeaveRoom("gone", "chat:1")
+}
+
+func TestManagerDisconnect(t *testing.T) {
+	m := NewManager()
+	conn, mem := pumped(t, m, "a", "b1", "1", "chat:1")
+
+	// Frames queued before the close are still written, then the close
+	m.BroadcastToRoom("b1", "chat:1", []byte(`{"type":"last"}`), "")
+	if !m.Disconnect("a", ClosePolicyViolation, "kicked") {
+		t.Fatal("Disconnect of a known connection = false")
+	}
+	select {
+	case <-mem.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("transport not closed")
+	}
+	if frames := mem.Frames(); len(frames) != 1 || string(frames[0]) != `{"type":"last"}` {
+		t.Errorf("frames = %q", frames)
+	}
+	if _, code, reason := mem.CloseInfo(); code != ClosePolicyViolation || reason != "kicked" {
+		t.Errorf("closed with %d %q", code, reason)
+	}
+
+	if m.Disconnect("a", CloseNormal, "") {
+		t.Error("second Disconnect = true")
+	}
+	if m.Count() != 0 || m.RoomSize(QualifiedRoom("b1", "chat:1")) != 0 || m.UserConnectionCount("b1", "1") != 0 {
+		t.Errorf("disconnected connection still indexed: %v", m.GetStats())
+	}
+	if err := conn.Send([]byte(`{}`)); err != ErrConnectionClosed {
+		t.Errorf("Send after close = %v, want ErrConnectionClosed", err)
+	}
+	if n := m.BroadcastToRoom("b1", "chat:1", []byte(`{}`), ""); n != 0 {
+		t.Errorf("broadcast reached %d closed connections", n)
+	}
+}
+
+// Frames dropped for a full buffer or a closed connection are not counted
+// as delivered
+func TestManagerBroadcastCountsDrops(t *testing.T) {
+	m := NewManager()
+	slow := NewConnection("slow", "memory", "1", "b1", "", "customer")
+	m.AddConnection(slow)
+	m.JoinRoom("slow", "chat:1")
+	pumped(t, m, "ok", "b1", "2", "chat:1")
+
+	for i := 0; i < cap(slow.send); i++ {
+		if err := slow.Send([]byte(`{}`)); err != nil {
+			t.Fatalf("Send %d = %v", i, err)
+		}
+	}
+	if err := slow.Send([]byte(`{}`)); err != ErrSendBufferFull {
+		t.Errorf("Send to a full buffer = %v, want ErrSendBufferFull", err)
+	}
+	if n := m.BroadcastToRoom("b1", "chat:1", []byte(`{}`), ""); n != 1 {
+		t.Errorf("BroadcastToRoom counted %d deliveries, want 1", n)
+	}
+
+	slow.Close()
+	if n := m.Broadcast(Target{Brand: "b1", Rooms: []string{"chat:1"}}, []byte(`{}`), ""); n != 1 {
+		t.Errorf("Broadcast counted %d deliveries with a closed member, want 1", n)
+	}
+	m.RemoveConnection("slow")
+}
+
+// Brand counts and brand broadcasts follow the connections, not the
+// brand room, which an admin can take a connection out of
+func TestManagerBrandIndex(t *testing.T) {
+	m := NewManager()
+	_, mem := pumped(t, m, "a", "b1", "1")
+	pumped(t, m, "b", "b1", "2")
+	pumped(t, m, "c", "b2", "3")
+
+	m.LeaveRoom("a", "brand:b1")
+	if n := m.BrandConnectionCount("b1"); n != 2 {
+		t.Errorf("BrandConnectionCount after leaving the brand room = %d, want 2", n)
+	}
+	if n := len(m.GetBrandConnections("b1")); n != 2 {
+		t.Errorf("GetBrandConnections = %d connections, want 2", n)
+	}
+	if n := m.Broadcast(Target{Filter: Filter{BrandID: "b1"}}, []byte(`{"n":1}`), ""); n != 2 {
+		t.Errorf("brand filter broadcast reached %d connections, want 2", n)
+	}
+	waitFrames(t, mem, 1)
+
+	m.RemoveConnection("b")
+	if n := m.BrandConnectionCount("b1"); n != 1 {
+		t.Errorf("BrandConnectionCount after a disconnect = %d, want 1", n)
+	}
+}
+
+// Broadcasts, joins and leaves racing with disconnects must neither panic
+// (send on a closed channel) nor leave closed connections indexed.
+// Run with -race.
+func TestManagerCloseRaces(t *testing.T) {
+	m := NewManager()
+	const conns = 200
+	for i := 0; i < conns; i++ {
+		conn := NewConnection(fmt.Sprint("c", i), "memory", fmt.Sprint(i%20), "b1", "", "customer")
+		m.AddConnection(conn)
+		if err := m.JoinRoom(conn.ID, "chat:1"); err != nil {
+			t.Fatal(err)
+		}
+		go conn.Pump(transport.NewMemory(), 0)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				m.BroadcastToRoom("b1", "chat:1", []byte(`{"type":"message"}`), "")
+				m.Broadcast(Target{Brand: "b1", UserIDs: []string{"3"}, Filter: Filter{Types: []string{"customer"}}}, []byte(`{}`), "")
+				m.BroadcastAll([]byte(`{"type":"system"}`))
+			}
+		}()
+	}
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			m.JoinRoom(id, "chat:2")
+			m.LeaveRoom(id, "chat:1")
+			m.Disconnect(id, CloseGoingAway, "")
+			m.JoinRoom(id, "chat:3")
+		}(fmt.Sprint("c", i))
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	if m.Count() != 0 {
+		t.Errorf("Count = %d after every connection disconnected", m.Count())
+	}
+	for _, r := range []string{"chat:1", "chat:2", "chat:3"} {
+		if n := m.RoomSize(QualifiedRoom("b1", r)); n != 0 {
+			t.Errorf("%s still has %d members", r, n)
+		}
+	}
+	if rooms := m.ListRooms(""); len(rooms) != 0 {
+		t.Errorf("rooms left: %v", rooms)
+	}
+}
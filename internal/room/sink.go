@@ -0,0 +1,74 @@
+package room
+
+import (
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// Sink is where a connection's frames are written. Pump is its only
+// writer, so implementations need not be safe for concurrent use.
+type Sink interface {
+	// WriteFrame delivers one encoded frame
+	WriteFrame(frame []byte) error
+	// Ping checks the client is still there, if the transport supports it
+	Ping() error
+	// Close tells the client why the connection ends and releases it
+	Close(code int, reason string) error
+}
+
+// PreparedSink is a Sink that can share per-frame work, such as
+// compression, between the connections receiving the same frame
+type PreparedSink interface {
+	Sink
+	// WritePrepared writes frame, whose encoding for codec is data
+	WritePrepared(frame *protocol.Frame, codec protocol.Codec, data []byte) error
+}
+
+// Transport is a Sink that also reads frames sent by the client
+type Transport interface {
+	Sink
+	// ReadFrame blocks until the client sends a frame or the transport closes
+	ReadFrame() ([]byte, error)
+}
+
+// Pump encodes queued frames with the connection's codec and writes them
+// to sink until the connection is closed, then closes the sink with the
+// connection's close code and reason. A zero pingInterval disables pings. It returns the first write error, if any.
+func (c *Connection) Pump(sink Sink, pingInterval time.Duration) error {
+	var pings <-chan time.Time
+	if pingInterval > 0 {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+
+	for {
+		select {
+		case out, ok := <-c.send:
+			if !ok {
+				return sink.Close(c.CloseInfo())
+			}
+			data, err := c.Encode(out)
+			if err != nil {
+				log.Warn().Err(err).Str("conn_id", c.ID).Str("codec", c.Codec.Name()).Msg("Failed to encode frame")
+				continue
+			}
+			// Sequenced frames differ per connection, so there is nothing to share
+			if ps, ok := sink.(PreparedSink); ok && out.Seq == 0 {
+				err = ps.WritePrepared(out.Frame, c.Codec, data)
+			} else {
+				err = sink.WriteFrame(data)
+			}
+			if err != nil {
+				return err
+			}
+
+		case <-pings:
+			if err := sink.Ping(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package room
+
+import "strings"
+
+// Filter restricts delivery by connection metadata.
+// Empty fields match any connection; list fields match if any value matches.
+type Filter struct {
+	BrandID  string   `json:"brand_id,omitempty"`
+	Types    []string `json:"types,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	Devices  []string `json:"devices,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+	Tags     []string `json:"tags,omitempty"` // connection must carry at least one
+}
+
+// IsZero reports whether the filter matches every connection
+func (f Filter) IsZero() bool {
+	return f.BrandID == "" && len(f.Types) == 0 && len(f.Roles) == 0 &&
+		len(f.Devices) == 0 && len(f.Channels) == 0 && len(f.Tags) == 0
+}
+
+// Match checks a connection against the filter
+func (f Filter) Match(c *Connection) bool {
+	if f.BrandID != "" && f.BrandID != c.BrandID {
+		return false
+	}
+	if !matchAny(f.Types, c.Type) || !matchAny(f.Roles, c.Role) ||
+		!matchAny(f.Devices, c.Device) || !matchAny(f.Channels, c.Channel) {
+		return false
+	}
+	if len(f.Tags) > 0 {
+		for _, tag := range strings.Split(c.Tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" && matchAny(f.Tags, tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchAny(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, want := range values {
+		if strings.EqualFold(want, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Target selects the connections a message is delivered to: members of any
+// of Rooms plus connections of any of UserIDs, narrowed by Filter. With no
+// rooms or users the filter alone selects, scoped to the brand room when
+// Filter.BrandID is set. Brand is the tenant the rooms and users belong to;
+// empty is the platform scope, which may use brand-qualified IDs.
+type Target struct {
+	Brand   string
+	Rooms   []string
+	UserIDs []string
+	Filter  Filter
+}
+
+// IsZero reports whether the target selects nothing
+func (t Target) IsZero() bool {
+	return len(t.Rooms) == 0 && len(t.UserIDs) == 0 && t.Filter.IsZero()
+}
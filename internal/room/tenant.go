@@ -0,0 +1,69 @@
+package room
+
+import (
+	"errors"
+	"strings"
+)
+
+// BrandSeparator joins a brand and a room into a brand-qualified room ID,
+// e.g. "b1/chat:42". Plain room IDs are resolved in the brand of the
+// connection or event that uses them, so brands never share a room.
+const BrandSeparator = "/"
+
+// ErrCrossBrand is returned when a connection addresses another brand's room
+var ErrCrossBrand = errors.New("room belongs to another brand")
+
+// QualifiedRoom returns the brand-qualified ID of a plain room ID
+func QualifiedRoom(brandID, roomID string) string {
+	if brandID == "" {
+		return roomID
+	}
+	return brandID + BrandSeparator + roomID
+}
+
+// SplitRoom splits a brand-qualified room ID; qualified is false for a
+// plain room ID
+func SplitRoom(id string) (brandID, roomID string, qualified bool) {
+	brandID, roomID, qualified = strings.Cut(id, BrandSeparator)
+	if !qualified {
+		return "", id, false
+	}
+	return brandID, roomID, true
+}
+
+// scopedKey is the index key of a room or user ID within a brand. Qualified
+// IDs are kept as they are; connections and events without a brand use the
+// platform namespace.
+func scopedKey(brandID, id string) string {
+	if _, _, qualified := SplitRoom(id); qualified {
+		return id
+	}
+	return QualifiedRoom(brandID, id)
+}
+
+// CanAccess reports whether the connection may join or publish to a room:
+// plain rooms and rooms of its own brand always, other brands' rooms only
+// as a platform admin
+func (c *Connection) CanAccess(roomID string) bool {
+	brandID, _, qualified := SplitRoom(roomID)
+	return !qualified || brandID == c.BrandID || c.PlatformAdmin
+}
+
+// ownRoom strips the connection's own brand from a qualified room ID, so a
+// room has one name per connection
+func (c *Connection) ownRoom(roomID string) string {
+	if brandID, plain, qualified := SplitRoom(roomID); qualified && brandID == c.BrandID {
+		return plain
+	}
+	return roomID
+}
+
+// roomKey is the index key of one of the connection's rooms
+func (c *Connection) roomKey(roomID string) string {
+	return scopedKey(c.BrandID, roomID)
+}
+
+// userKey is the index key of the connection's user
+func (c *Connection) userKey() string {
+	return QualifiedRoom(c.BrandID, c.UserID)
+}
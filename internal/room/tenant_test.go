@@ -0,0 +1,124 @@
+package room
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSplitRoom(t *testing.T) {
+	tests := []struct {
+		id, brand, room string
+		qualified       bool
+	}{
+		{"chat:1", "", "chat:1", false},
+		{"b1/chat:1", "b1", "chat:1", true},
+		{QualifiedRoom("b1", "chat:1"), "b1", "chat:1", true},
+		{QualifiedRoom("", "chat:1"), "", "chat:1", false},
+	}
+	for _, tt := range tests {
+		brand, room, qualified := SplitRoom(tt.id)
+		if brand != tt.brand || room != tt.room || qualified != tt.qualified {
+			t.Errorf("SplitRoom(%q) = %q, %q, %v", tt.id, brand, room, qualified)
+		}
+	}
+}
+
+func TestScopedKey(t *testing.T) {
+	tests := []struct{ brand, id, want string }{
+		{"b1", "chat:1", "b1/chat:1"},
+		{"b2", "chat:1", "b2/chat:1"},
+		{"b1", "b1/chat:1", "b1/chat:1"},
+		{"b1", "b2/chat:1", "b2/chat:1"}, // callers check access first
+		{"", "chat:1", "chat:1"},
+		{"", "b2/chat:1", "b2/chat:1"},
+	}
+	for _, tt := range tests {
+		if got := scopedKey(tt.brand, tt.id); got != tt.want {
+			t.Errorf("scopedKey(%q, %q) = %q, want %q", tt.brand, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestCanAccessAndOwnRoom(t *testing.T) {
+	member := NewConnection("a", "memory", "1", "b1", "", "customer")
+	admin := NewConnection("b", "memory", "2", "b1", "admin", "cskh")
+	admin.PlatformAdmin = true
+
+	tests := []struct {
+		conn   *Connection
+		room   string
+		access bool
+		own    string
+	}{
+		{member, "chat:1", true, "chat:1"},
+		{member, "b1/chat:1", true, "chat:1"},
+		{member, "b2/chat:1", false, "b2/chat:1"},
+		{admin, "b2/chat:1", true, "b2/chat:1"},
+		{admin, "b1/chat:1", true, "chat:1"},
+	}
+	for _, tt := range tests {
+		if got := tt.conn.CanAccess(tt.room); got != tt.access {
+			t.Errorf("%s CanAccess(%q) = %v, want %v", tt.conn.ID, tt.room, got, tt.access)
+		}
+		if got := tt.conn.ownRoom(tt.room); got != tt.own {
+			t.Errorf("%s ownRoom(%q) = %q, want %q", tt.conn.ID, tt.room, got, tt.own)
+		}
+	}
+}
+
+// The same plain room ID in two brands is two rooms, and no brand can
+// reach the other's through a qualified ID
+func TestCrossBrandIsolation(t *testing.T) {
+	m := NewManager()
+	b1, mem1 := pumped(t, m, "a", "b1", "7", "chat:1")
+	_, mem2 := pumped(t, m, "b", "b2", "7", "chat:1")
+	admin, memAdmin := pumped(t, m, "c", "b1", "9")
+	admin.PlatformAdmin = true
+
+	if err := m.JoinRoom(b1.ID, "b2/chat:1"); err != ErrCrossBrand {
+		t.Errorf("JoinRoom into b2 = %v, want ErrCrossBrand", err)
+	}
+	if err := m.JoinRoom(admin.ID, "b2/chat:1"); err != nil {
+		t.Fatalf("platform admin JoinRoom into b2 = %v", err)
+	}
+	if m.RoomSize("b1/chat:1") != 1 || m.RoomSize("b2/chat:1") != 2 {
+		t.Errorf("room sizes b1 %d, b2 %d", m.RoomSize("b1/chat:1"), m.RoomSize("b2/chat:1"))
+	}
+
+	// A brand's event reaches only its own room and user, even with the
+	// same IDs in another brand, and cannot name the other brand's room
+	if n := m.Broadcast(Target{Brand: "b1", Rooms: []string{"chat:1"}}, []byte(`{"n":1}`), ""); n != 1 {
+		t.Errorf("b1 room broadcast reached %d connections, want 1", n)
+	}
+	if n := m.Broadcast(Target{Brand: "b1", UserIDs: []string{"7"}}, []byte(`{"n":2}`), ""); n != 1 {
+		t.Errorf("b1 user broadcast reached %d connections, want 1", n)
+	}
+	if n := m.Broadcast(Target{Brand: "b1", Rooms: []string{"b2/chat:1"}}, []byte(`{"n":3}`), ""); n != 0 {
+		t.Errorf("b1 broadcast to b2's room reached %d connections", n)
+	}
+	if n := m.BroadcastToRoom("b1", "b2/chat:1", []byte(`{"n":3}`), ""); n != 0 {
+		t.Errorf("b1 BroadcastToRoom to b2's room reached %d connections", n)
+	}
+
+	// The platform scope addresses qualified rooms of any brand
+	if n := m.Broadcast(Target{Rooms: []string{"b2/chat:1"}}, []byte(`{"n":4}`), ""); n != 2 {
+		t.Errorf("platform broadcast reached %d connections, want 2", n)
+	}
+
+	waitFrames(t, mem1, 2)
+	waitFrames(t, mem2, 1)
+	waitFrames(t, memAdmin, 1)
+	settle()
+	for name, tc := range map[string]struct {
+		frames []string
+		want   string
+	}{
+		"b1 member":      {waitFrames(t, mem1, 0), `[{"n":1} {"n":2}]`},
+		"b2 member":      {waitFrames(t, mem2, 0), `[{"n":4}]`},
+		"platform admin": {waitFrames(t, memAdmin, 0), `[{"n":4}]`},
+	} {
+		if got := fmt.Sprint(tc.frames); got != tc.want {
+			t.Errorf("%s received %s, want %s", name, got, tc.want)
+		}
+	}
+}
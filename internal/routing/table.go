@@ -0,0 +1,135 @@
+package routing
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/attchat/attchat-gateway/internal/config"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+func init() {
+	config.RegisterCheck(func(c *config.Config) error {
+		_, err := New(c.Routing.Routes)
+		return err
+	})
+}
+
+// Fields are the values available to subject templates
+type Fields struct {
+	Brand  string // {brand}
+	Room   string // {room}
+	User   string // {user}
+	Type   string // {type}: client message type
+	Stream string // {stream}: upper-cased JWT type claim, CHAT by default
+}
+
+func (f Fields) lookup(name string) string {
+	switch name {
+	case "brand":
+		return f.Brand
+	case "room":
+		return f.Room
+	case "user":
+		return f.User
+	case "type":
+		return f.Type
+	case "stream":
+		return f.Stream
+	}
+	return ""
+}
+
+type route struct {
+	pattern string
+	subject string
+}
+
+// Table maps client message types to NATS subjects.
+// Routes are evaluated in order; the first matching pattern wins.
+type Table struct {
+	routes []route
+}
+
+// New compiles routes from config, validating patterns and templates
+func New(routes []config.Route) (*Table, error) {
+	t := &Table{}
+	for i, r := range routes {
+		pattern := strings.TrimSpace(r.Type)
+		subject := strings.TrimSpace(r.Subject)
+		if pattern == "" || subject == "" {
+			return nil, fmt.Errorf("routing.routes[%d]: type and subject are required", i)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("routing.routes[%d]: invalid type pattern %q: %w", i, pattern, err)
+		}
+		for _, m := range placeholderPattern.FindAllStringSubmatch(subject, -1) {
+			switch m[1] {
+			case "brand", "room", "user", "type", "stream":
+			default:
+				return nil, fmt.Errorf("routing.routes[%d]: unknown placeholder %s in %q", i, m[0], subject)
+			}
+		}
+		// A template rendered with placeholder values must be a valid subject
+		if !validSubject(render(subject, Fields{Brand: "b", Room: "r", User: "u", Type: "t", Stream: "S"})) {
+			return nil, fmt.Errorf("routing.routes[%d]: invalid subject template %q", i, subject)
+		}
+		t.routes = append(t.routes, route{pattern: pattern, subject: subject})
+	}
+	return t, nil
+}
+
+// Resolve returns the subject for a client message type, or false if no route matches
+func (t *Table) Resolve(msgType string, f Fields) (string, bool) {
+	if msgType == "" {
+		return "", false
+	}
+	for _, r := range t.routes {
+		if ok, _ := path.Match(r.pattern, msgType); ok {
+			return render(r.subject, f), true
+		}
+	}
+	return "", false
+}
+
+// Render fills a subject template outside the route table, e.g. a default
+// publish subject
+func Render(tmpl string, f Fields) string {
+	return render(tmpl, f)
+}
+
+// render substitutes placeholders with subject-safe tokens
+func render(tmpl string, f Fields) string {
+	return placeholderPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		return sanitizeToken(f.lookup(m[1 : len(m)-1]))
+	})
+}
+
+// sanitizeToken makes a value usable as a single subject token
+func sanitizeToken(v string) string {
+	if v == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+func validSubject(subject string) bool {
+	if subject == "" {
+		return false
+	}
+	for _, tok := range strings.Split(subject, ".") {
+		if tok == "" || tok == "*" || tok == ">" || strings.ContainsAny(tok, " \t\r\n") {
+			return false
+		}
+	}
+	return true
+}
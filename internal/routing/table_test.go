@@ -0,0 +1,90 @@
+package routing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/attchat/attchat-gateway/internal/config"
+)
+
+func TestNewRejects(t *testing.T) {
+	tests := []struct {
+		route config.Route
+		want  string
+	}{
+		{config.Route{Type: "message"}, "type and subject are required"},
+		{config.Route{Subject: "CHAT.x"}, "type and subject are required"},
+		{config.Route{Type: "[bad", Subject: "CHAT.x"}, "invalid type pattern"},
+		{config.Route{Type: "*", Subject: "CHAT.{nope}"}, "unknown placeholder {nope}"},
+		{config.Route{Type: "*", Subject: "CHAT..{brand}"}, "invalid subject template"},
+		{config.Route{Type: "*", Subject: "CHAT.>"}, "invalid subject template"},
+		{config.Route{Type: "*", Subject: "CHAT.*.{type}"}, "invalid subject template"},
+	}
+	for _, tt := range tests {
+		_, err := New([]config.Route{tt.route})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("New(%+v) = %v, want %q", tt.route, err, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	table, err := New([]config.Route{
+		{Type: "message*", Subject: "CHAT.{brand}.{type}"},
+		{Type: "call.?", Subject: "CALL.{brand}.{room}"},
+		{Type: "*", Subject: "{stream}.{brand}.events"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := Fields{Brand: "b1", Room: "chat:1", User: "7", Stream: "CSKH"}
+
+	tests := []struct {
+		msgType string
+		want    string
+	}{
+		{"message", "CHAT.b1.message"},
+		{"message.edit", "CHAT.b1.message_edit"}, // first match wins; "." is not a token break
+		{"call.a", "CALL.b1.chat:1"},
+		{"call.ab", "CSKH.b1.events"},
+		{"read", "CSKH.b1.events"},
+	}
+	for _, tt := range tests {
+		f.Type = tt.msgType
+		if got, ok := table.Resolve(tt.msgType, f); !ok || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", tt.msgType, got, ok, tt.want)
+		}
+	}
+	if _, ok := table.Resolve("", f); ok {
+		t.Error("Resolve of an empty type matched")
+	}
+
+	// Without a catch-all, unmatched types are rejected
+	strict, err := New([]config.Route{{Type: "message", Subject: "CHAT.{brand}.message"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := strict.Resolve("system", f); ok {
+		t.Errorf("Resolve(system) = %q without a route", got)
+	}
+}
+
+// Client-controlled values cannot add or wildcard subject tokens
+func TestRenderSanitizes(t *testing.T) {
+	tests := []struct {
+		f    Fields
+		want string
+	}{
+		{Fields{Brand: "b1", Room: "chat:1"}, "ROOM.b1.chat:1"},
+		{Fields{Brand: "", Room: "chat:1"}, "ROOM._.chat:1"},
+		{Fields{Brand: "b1", Room: "a.b"}, "ROOM.b1.a_b"},
+		{Fields{Brand: "b1", Room: ">"}, "ROOM.b1._"},
+		{Fields{Brand: "b1", Room: "*"}, "ROOM.b1._"},
+		{Fields{Brand: "b 1", Room: "x\ny"}, "ROOM.b_1.x_y"},
+	}
+	for _, tt := range tests {
+		if got := Render("ROOM.{brand}.{room}", tt.f); got != tt.want {
+			t.Errorf("Render(%+v) = %q, want %q", tt.f, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const adminListLimit = 100
+
+// connectionView is the admin representation of a connection
+type connectionView struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	BrandID    string    `json:"brand_id,omitempty"`
+	Role       string    `json:"role,omitempty"`
+	Type       string    `json:"type,omitempty"`
+	Device     string    `json:"device,omitempty"`
+	Tags       string    `json:"tags,omitempty"`
+	Timezone   string    `json:"tz,omitempty"`
+	Channel    string    `json:"channel,omitempty"`
+	RemoteIP   string    `json:"remote_ip,omitempty"`
+	Rooms      []string  `json:"rooms"`
+	QueueDepth int       `json:"queue_depth"`
+	CreatedAt  time.Time `json:"created_at"`
+	Age        string    `json:"age"`
+	LastPing   time.Time `json:"last_ping"`
+}
+
+func newConnectionView(conn *room.Connection) connectionView {
+	rooms := conn.GetRooms()
+	sort.Strings(rooms)
+	return connectionView{
+		ID:         conn.ID,
+		UserID:     conn.UserID,
+		BrandID:    conn.BrandID,
+		Role:       conn.Role,
+		Type:       conn.Type,
+		Device:     conn.Device,
+		Tags:       conn.Tags,
+		Timezone:   conn.Timezone,
+		Channel:    conn.Channel,
+		RemoteIP:   conn.RemoteIP,
+		Rooms:      rooms,
+		QueueDepth: conn.QueueDepth(),
+		CreatedAt:  conn.CreatedAt,
+		Age:        time.Since(conn.CreatedAt).Round(time.Second).String(),
+		LastPing:   conn.LastPingTime(),
+	}
+}
+
+// setupAdminRoutes mounts the admin API on router
+func (s *Server) setupAdminRoutes(router fiber.Router) {
+	admin := router.Group(s.cfg().Admin.PathPrefix, s.adminAuth)
+
+	admin.Get("/connections", s.adminListConnections)
+	admin.Get("/connections/:id", s.adminGetConnection)
+	admin.Delete("/connections/:id", s.adminDisconnect)
+	admin.Post("/connections/:id/rooms", s.adminJoinRoom)
+	admin.Delete("/connections/:id/rooms/:room", s.adminLeaveRoom)
+	admin.Delete("/users/:user_id/connections", s.adminDisconnectUser)
+	admin.Get("/rooms", s.adminListRooms)
+	admin.Get("/rooms/:room", s.adminGetRoom)
+}
+
+// adminAuth accepts requests carrying one of admin.tokens as a bearer token
+func (s *Server) adminAuth(c *fiber.Ctx) error {
+	token := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	for _, t := range s.cfg().Admin.Tokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return c.Next()
+		}
+	}
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"code": "UNAUTHORIZED", "message": "invalid admin token"})
+}
+
+// adminListConnections lists connections matching the query filters
+// @Summary List connections
+// @Tags admin
+// @Produce json
+// @Param user_id query string false "User ID"
+// @Param brand_id query string false "Brand ID"
+// @Param room query string false "Room ID, brand-qualified (b1/chat:1) or plain within brand_id"
+// @Param device query string false "Device"
+// @Param type query string false "User type"
+// @Param limit query int false "Max results (default 100)"
+// @Success 200 {object} map[string]interface{}
+// @Router /admin/connections [get]
+func (s *Server) adminListConnections(c *fiber.Ctx) error {
+	userID := c.Query("user_id")
+	brandID := c.Query("brand_id")
+	roomID := c.Query("room")
+	device := c.Query("device")
+	userType := c.Query("type")
+	limit := c.QueryInt("limit", adminListLimit)
+
+	var candidates []*room.Connection
+	switch {
+	case roomID != "":
+		if _, _, qualified := room.SplitRoom(roomID); !qualified {
+			roomID = room.QualifiedRoom(brandID, roomID)
+		}
+		candidates = s.roomManager.GetRoomConnections(roomID)
+	case userID != "" && brandID != "":
+		candidates = s.roomManager.GetUserConnections(brandID, userID)
+	default:
+		candidates = s.roomManager.Connections()
+	}
+
+	views := make([]connectionView, 0)
+	total := 0
+	for _, conn := range candidates {
+		if (userID != "" && conn.UserID != userID) ||
+			(brandID != "" && conn.BrandID != brandID) ||
+			(device != "" && !strings.EqualFold(conn.Device, device)) ||
+			(userType != "" && !strings.EqualFold(conn.Type, userType)) {
+			continue
+		}
+		total++
+		if len(views) < limit {
+			views = append(views, newConnectionView(conn))
+		}
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].CreatedAt.Before(views[j].CreatedAt) })
+
+	return c.JSON(fiber.Map{"total": total, "connections": views})
+}
+
+// adminGetConnection shows one connection
+// @Summary Get connection
+// @Tags admin
+// @Produce json
+// @Param id path string true "Connection ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/connections/{id} [get]
+func (s *Server) adminGetConnection(c *fiber.Ctx) error {
+	conn, ok := s.roomManager.GetConnection(c.Params("id"))
+	if !ok {
+		return adminNotFound(c, "connection")
+	}
+	return c.JSON(newConnectionView(conn))
+}
+
+// adminDisconnect force-disconnects a connection
+// @Summary Disconnect connection
+// @Tags admin
+// @Produce json
+// @Param id path string true "Connection ID"
+// @Param reason query string false "Reason sent to the client"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/connections/{id} [delete]
+func (s *Server) adminDisconnect(c *fiber.Ctx) error {
+	conn, ok := s.roomManager.GetConnection(c.Params("id"))
+	if !ok {
+		return adminNotFound(c, "connection")
+	}
+	s.kick(conn, c.Query("reason", "disconnected by administrator"))
+	return c.JSON(fiber.Map{"disconnected": 1})
+}
+
+// adminDisconnectUser force-disconnects all of a user's connections
+// @Summary Disconnect user
+// @Tags admin
+// @Produce json
+// @Param user_id path string true "User ID"
+// @Param brand_id query string false "Brand of the user; all brands if empty"
+// @Param reason query string false "Reason sent to the client"
+// @Success 200 {object} map[string]interface{}
+// @Router /admin/users/{user_id}/connections [delete]
+func (s *Server) adminDisconnectUser(c *fiber.Ctx) error {
+	reason := c.Query("reason", "disconnected by administrator")
+	userID := c.Params("user_id")
+
+	var conns []*room.Connection
+	if brandID := c.Query("brand_id"); brandID != "" {
+		conns = s.roomManager.GetUserConnections(brandID, userID)
+	} else {
+		for _, conn := range s.roomManager.Connections() {
+			if conn.UserID == userID {
+				conns = append(conns, conn)
+			}
+		}
+	}
+	for _, conn := range conns {
+		s.kick(conn, reason)
+	}
+	return c.JSON(fiber.Map{"disconnected": len(conns)})
+}
+
+// adminJoinRoom forces a connection into a room
+// @Summary Force join room
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Connection ID"
+// @Param body body map[string]string true "{\"room\": \"chat:123\"}"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/connections/{id}/rooms [post]
+func (s *Server) adminJoinRoom(c *fiber.Ctx) error {
+	conn, ok := s.roomManager.GetConnection(c.Params("id"))
+	if !ok {
+		return adminNotFound(c, "connection")
+	}
+	var body struct {
+		Room string `json:"room"`
+	}
+	if err := json.Unmarshal(c.Body(), &body); err != nil || !isValidRoomID(body.Room) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"code": "INVALID_ROOM", "message": "invalid room"})
+	}
+
+	if err := s.roomManager.JoinRoom(conn.ID, body.Room); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"code": "FORBIDDEN_ROOM", "message": err.Error()})
+	}
+	s.sendFrame(conn, ServerMessage{Type: "joined", Room: body.Room, Timestamp: time.Now()})
+	return c.JSON(newConnectionView(conn))
+}
+
+// adminLeaveRoom forces a connection out of a room
+// @Summary Force leave room
+// @Tags admin
+// @Produce json
+// @Param id path string true "Connection ID"
+// @Param room path string true "Room ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/connections/{id}/rooms/{room} [delete]
+func (s *Server) adminLeaveRoom(c *fiber.Ctx) error {
+	conn, ok := s.roomManager.GetConnection(c.Params("id"))
+	if !ok {
+		return adminNotFound(c, "connection")
+	}
+	roomID, err := url.PathUnescape(c.Params("room"))
+	if err != nil || !conn.IsInRoom(roomID) {
+		return adminNotFound(c, "room")
+	}
+
+	s.roomManager.LeaveRoom(conn.ID, roomID)
+	s.sendFrame(conn, ServerMessage{Type: "left", Room: roomID, Timestamp: time.Now()})
+	return c.JSON(newConnectionView(conn))
+}
+
+// adminListRooms lists rooms with member counts
+// @Summary List rooms
+// @Tags admin
+// @Produce json
+// @Param prefix query string false "Brand-qualified room ID prefix, e.g. b1/chat:"
+// @Param limit query int false "Max results (default 100)"
+// @Success 200 {object} map[string]interface{}
+// @Router /admin/rooms [get]
+func (s *Server) adminListRooms(c *fiber.Ctx) error {
+	rooms := s.roomManager.ListRooms(c.Query("prefix"))
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Members != rooms[j].Members {
+			return rooms[i].Members > rooms[j].Members
+		}
+		return rooms[i].ID < rooms[j].ID
+	})
+	total := len(rooms)
+	if limit := c.QueryInt("limit", adminListLimit); len(rooms) > limit {
+		rooms = rooms[:limit]
+	}
+	return c.JSON(fiber.Map{"total": total, "rooms": rooms})
+}
+
+// adminGetRoom lists the members of a room
+// @Summary Get room
+// @Tags admin
+// @Produce json
+// @Param room path string true "Brand-qualified room ID, e.g. b1/chat:1 (URL-encoded)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/rooms/{room} [get]
+func (s *Server) adminGetRoom(c *fiber.Ctx) error {
+	roomID, err := url.PathUnescape(c.Params("room"))
+	if err != nil {
+		return adminNotFound(c, "room")
+	}
+	conns := s.roomManager.GetRoomConnections(roomID)
+	if len(conns) == 0 {
+		return adminNotFound(c, "room")
+	}
+	views := make([]connectionView, 0, len(conns))
+	for _, conn := range conns {
+		views = append(views, newConnectionView(conn))
+	}
+	return c.JSON(fiber.Map{"id": roomID, "members": len(views), "connections": views})
+}
+
+// kick tells the client why it is being disconnected and closes the connection
+func (s *Server) kick(conn *room.Connection, reason string) {
+	payload, _ := json.Marshal(map[string]string{"reason": reason})
+	s.sendFrame(conn, ServerMessage{Type: "disconnected", Payload: payload, Timestamp: time.Now()})
+	s.roomManager.Disconnect(conn.ID, room.ClosePolicyViolation, reason)
+
+	log.Info().
+		Str("conn_id", conn.ID).
+		Str("user_id", conn.UserID).
+		Str("reason", reason).
+		Msg("Connection disconnected by admin")
+}
+
+func adminNotFound(c *fiber.Ctx, what string) error {
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"code": "NOT_FOUND", "message": what + " not found"})
+}
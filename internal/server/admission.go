@@ -0,0 +1,259 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/attchat/attchat-gateway/internal/metrics"
+	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// User tab policies once admission.max_per_user is reached
+const (
+	policyRejectNew   = "reject_new"
+	policyEvictOldest = "evict_oldest"
+)
+
+// Locals set by admitWebSocket for handleWebSocket
+const (
+	localParams    = "connect_params"
+	localAuthError = "connect_auth_error"
+)
+
+func init() {
+	config.RegisterCheck(func(c *config.Config) error {
+		_, err := newAdmission(c.Admission)
+		return err
+	})
+}
+
+// admission holds the parsed admission config and the brand quotas
+type admission struct {
+	trusted  []*net.IPNet
+	mu       sync.RWMutex
+	quotas   map[string]int // from config
+	kvQuotas map[string]int // from admission.quota_bucket, override config
+}
+
+func newAdmission(cfg config.AdmissionConfig) (*admission, error) {
+	// Brand overlays may set max_tabs even when max_per_user is 0
+	if cfg.UserPolicy != policyRejectNew && cfg.UserPolicy != policyEvictOldest {
+		return nil, fmt.Errorf("admission.user_policy must be %q or %q", policyRejectNew, policyEvictOldest)
+	}
+
+	a := &admission{
+		quotas:   make(map[string]int, len(cfg.BrandQuotas)),
+		kvQuotas: make(map[string]int),
+	}
+	for _, p := range cfg.TrustedProxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admission.trusted_proxies entry %q: %w", p, err)
+		}
+		a.trusted = append(a.trusted, network)
+	}
+	for _, q := range cfg.BrandQuotas {
+		if q.Brand == "" || q.Max < 0 {
+			return nil, fmt.Errorf("invalid admission.brand_quotas entry %+v", q)
+		}
+		a.quotas[q.Brand] = q.Max
+	}
+	return a, nil
+}
+
+func (a *admission) isTrusted(ip net.IP) bool {
+	for _, network := range a.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// brandQuota returns the connection limit of a brand, 0 = unlimited
+func (a *admission) brandQuota(brandID string, defaultQuota int) int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if q, ok := a.kvQuotas[brandID]; ok {
+		return q
+	}
+	if q, ok := a.quotas[brandID]; ok {
+		return q
+	}
+	return defaultQuota
+}
+
+// setKVQuota applies an entry of the quota bucket; nil value removes it
+func (a *admission) setKVQuota(brandID string, value []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if value == nil {
+		delete(a.kvQuotas, brandID)
+		return
+	}
+	q, err := strconv.Atoi(strings.TrimSpace(string(value)))
+	if err != nil || q < 0 {
+		log.Warn().Str("brand_id", brandID).Str("value", string(value)).Msg("Ignoring invalid brand quota")
+		return
+	}
+	a.kvQuotas[brandID] = q
+}
+
+// clientIP returns the peer address, or for requests through a trusted
+// proxy the right-most X-Forwarded-For entry that is not a trusted proxy
+func (s *Server) clientIP(c *fiber.Ctx) string {
+	ip := c.Context().RemoteIP()
+	if !s.admission.isTrusted(ip) {
+		return ip.String()
+	}
+	hops := strings.Split(c.Get(fiber.HeaderXForwardedFor), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !s.admission.isTrusted(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// authenticateRequest authenticates an HTTP request and records its client IP
+func (s *Server) authenticateRequest(c *fiber.Ctx) (*connectParams, error) {
+	p, err := s.authenticate(c.Query, c.Get)
+	if err != nil {
+		return nil, err
+	}
+	p.RemoteIP = s.clientIP(c)
+	return p, nil
+}
+
+// admissionLimit returns the limit a new connection would exceed and the
+// HTTP status to reject it with. p is nil for unauthenticated requests,
+// which are only checked against the node and IP limits.
+func (s *Server) admissionLimit(ip string, p *connectParams) (limit string, status int) {
+	cfg := s.cfg().Admission
+	if max := s.cfg().WS.MaxConnections; max > 0 && s.roomManager.Count() >= max {
+		return "node", fiber.StatusServiceUnavailable
+	}
+	if cfg.MaxPerIP > 0 && s.roomManager.IPConnectionCount(ip) >= cfg.MaxPerIP {
+		return "ip", fiber.StatusTooManyRequests
+	}
+	if p == nil {
+		return "", 0
+	}
+	if p.BrandID != "" {
+		if quota := s.admission.brandQuota(p.BrandID, cfg.DefaultBrandQuota); quota > 0 &&
+			s.roomManager.BrandConnectionCount(p.BrandID) >= quota {
+			return "brand", fiber.StatusTooManyRequests
+		}
+	}
+	if maxTabs := s.brands.Resolve(p.BrandID).MaxTabs; maxTabs > 0 && cfg.UserPolicy == policyRejectNew &&
+		p.UserID != "" && s.roomManager.UserConnectionCount(p.BrandID, p.UserID) >= maxTabs {
+		return "user", fiber.StatusTooManyRequests
+	}
+	return "", 0
+}
+
+// admit runs the origin and admission checks and writes the rejection, if
+// any. The counts are read without a reservation, so concurrent connects
+// may overshoot a limit by a few connections.
+func (s *Server) admit(c *fiber.Ctx, ip string, p *connectParams) (bool, error) {
+	if ok, err := s.checkOrigin(c, p); !ok {
+		return false, err
+	}
+	limit, status := s.admissionLimit(ip, p)
+	if limit == "" {
+		return true, nil
+	}
+	metrics.AdmissionRejected.WithLabelValues(limit).Inc()
+
+	ev := log.Warn().Str("ip", ip).Str("limit", limit)
+	if p != nil {
+		ev = ev.Str("user_id", p.UserID).Str("brand_id", p.BrandID)
+	}
+	ev.Msg("Connection rejected by admission limit")
+
+	code, message := "CONNECTION_LIMIT", "too many connections ("+limit+")"
+	if status == fiber.StatusServiceUnavailable {
+		code, message = "SERVER_FULL", "server is at capacity"
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(s.cfg().Admission.RetryAfter.Seconds()))))
+	return false, c.Status(status).JSON(fiber.Map{"code": code, "message": message, "limit": limit})
+}
+
+// admitWebSocket authenticates the upgrade request and runs the admission
+// checks before the handshake, so rejected clients get a plain HTTP status.
+// A bad token is still upgraded and reported as AUTH_FAILED on the socket.
+func (s *Server) admitWebSocket(c *fiber.Ctx) error {
+	ip := s.clientIP(c)
+	p, err := s.authenticate(c.Query, c.Get)
+	if err != nil {
+		c.Locals(localAuthError, err)
+	}
+	if ok, err := s.admit(c, ip, p); !ok {
+		return err
+	}
+	if p != nil {
+		p.RemoteIP = ip
+		c.Locals(localParams, p)
+	}
+	return c.Next()
+}
+
+// evictOldest closes a user's oldest connections beyond the brand's max_tabs
+// to make room for conn (policy evict_oldest)
+func (s *Server) evictOldest(conn *room.Connection) {
+	maxTabs := conn.Settings().MaxTabs
+	if maxTabs <= 0 || s.cfg().Admission.UserPolicy != policyEvictOldest || conn.UserID == "" {
+		return
+	}
+	conns := s.roomManager.GetUserConnections(conn.BrandID, conn.UserID)
+	if len(conns) <= maxTabs {
+		return
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].CreatedAt.Before(conns[j].CreatedAt) })
+
+	excess := len(conns) - maxTabs
+	for _, old := range conns {
+		if excess == 0 {
+			break
+		}
+		if old.ID == conn.ID {
+			continue
+		}
+		s.sendFrame(old, ServerMessage{
+			Type:      "disconnected",
+			Payload:   []byte(`{"reason":"replaced by a newer connection"}`),
+			Timestamp: time.Now(),
+		})
+		if s.roomManager.Disconnect(old.ID, room.ClosePolicyViolation, "too many connections") {
+			metrics.AdmissionEvictions.Inc()
+		}
+		excess--
+
+		log.Info().
+			Str("conn_id", old.ID).
+			Str("user_id", old.UserID).
+			Str("new_conn_id", conn.ID).
+			Msg("Evicted oldest connection of user")
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/attchat/attchat-gateway/internal/config"
+)
+
+// loadConfig loads the defaults with a generated JWT public key and returns
+// the PEM private key it belongs to
+func loadConfig(t *testing.T) (*config.Config, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GATEWAY_JWT_PUBLIC_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})))
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg, string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+// Validate, and so "config check", rejects what New rejects at startup
+func TestValidateRunsStartupChecks(t *testing.T) {
+	base, _ := loadConfig(t)
+
+	tests := []struct {
+		name   string
+		modify func(c *config.Config)
+		want   string
+	}{
+		{"route pattern", func(c *config.Config) {
+			c.Routing.Routes = []config.Route{{Type: "[bad", Subject: "CHAT.{brand}.x"}}
+		}, "routing.routes[0]: invalid type pattern"},
+		{"route template", func(c *config.Config) {
+			c.Routing.Routes = []config.Route{{Type: "message", Subject: "CHAT.{nope}"}}
+		}, "routing.routes[0]: unknown placeholder {nope}"},
+		{"route without subject", func(c *config.Config) {
+			c.Routing.Routes = []config.Route{{Type: "message"}}
+		}, "routing.routes[0]: type and subject are required"},
+		{"rate-limit rule", func(c *config.Config) {
+			c.RateLimit.Enabled = true
+			c.RateLimit.Rules = []config.RateLimitRule{{Type: "typing", Rate: 0, Burst: 1}}
+		}, "ratelimit.rules[0]"},
+		{"origin", func(c *config.Config) {
+			c.Origins.Allowed = []string{"ftp//nohost"}
+		}, `invalid origins.allowed entry "ftp//nohost"`},
+		{"credentials with any origin", func(c *config.Config) {
+			c.Origins.Allowed = []string{"*"}
+			c.Origins.AllowCredentials = true
+		}, "origins.allow_credentials"},
+		{"trusted proxy", func(c *config.Config) {
+			c.Admission.TrustedProxies = []string{"10.0.0.0/99"}
+		}, "invalid admission.trusted_proxies entry"},
+		{"user policy", func(c *config.Config) {
+			c.Admission.UserPolicy = "drop_all"
+		}, "admission.user_policy"},
+		{"brand quota", func(c *config.Config) {
+			c.Admission.BrandQuotas = []config.BrandQuota{{Brand: "", Max: 5}}
+		}, "invalid admission.brand_quotas entry"},
+		{"jwt key", func(c *config.Config) {
+			c.JWT.PublicKeyPEM = "garbage"
+		}, "jwt.public_key: failed to parse"},
+	}
+	for _, tt := range tests {
+		c := *base
+		tt.modify(&c)
+
+		var invalid *config.ValidationError
+		if err := c.Validate(); !errors.As(err, &invalid) {
+			t.Errorf("%s: Validate = %v, want a ValidationError", tt.name, err)
+			continue
+		}
+		found := false
+		for _, p := range invalid.Problems {
+			found = found || strings.Contains(p, tt.want)
+		}
+		if !found {
+			t.Errorf("%s: problems %q, want one containing %q", tt.name, invalid.Problems, tt.want)
+		}
+	}
+
+	// Rules are only built when rate limiting is on, as at startup
+	c := *base
+	c.RateLimit.Enabled = false
+	c.RateLimit.Rules = []config.RateLimitRule{{Type: "typing"}}
+	if err := c.Validate(); err != nil {
+		t.Errorf("disabled rate limiting: Validate = %v", err)
+	}
+}
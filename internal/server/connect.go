@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/auth"
+	"github.com/attchat/attchat-gateway/internal/brand"
+	"github.com/attchat/attchat-gateway/internal/metrics"
+	"github.com/attchat/attchat-gateway/internal/protocol"
+	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var errInvalidRoom = errors.New("invalid room_id")
+
+// connectParams are the identity and metadata of a connecting client,
+// shared by every transport
+type connectParams struct {
+	UserID    string
+	BrandID   string
+	Role      string
+	UserType  string
+	ClaimType string // user type claim; UserType may come from ?type=
+	Device    string
+	Tags      string
+	TZ        string
+	Channel   string
+	RoomID    string
+	Rooms     []string // rooms from the JWT
+	RemoteIP  string
+}
+
+// lookupFunc reads a query parameter or header, like fiber's Query and Get
+type lookupFunc func(key string, defaultValue ...string) string
+
+// bearerToken returns the token from ?token= or the Authorization header
+func bearerToken(query, header lookupFunc) string {
+	token := query("token")
+	if token == "" {
+		token = strings.TrimPrefix(header("Authorization"), "Bearer ")
+	}
+	return token
+}
+
+// authenticate validates the JWT and merges claims over the query parameters.
+// The brand and role, which grant tenant access, come from the JWT only.
+func (s *Server) authenticate(query, header lookupFunc) (*connectParams, error) {
+	token := bearerToken(query, header)
+	claims, err := s.jwtValidator.Validate(token)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("token_prefix", prefixToken(token)).
+			Str("iss", claimsIssuer(token)).
+			Strs("allowed_issuers", s.cfg().JWT.AllowedIssuers).
+			Msg("JWT validation failed")
+		metrics.AuthFailure.Inc()
+		return nil, err
+	}
+
+	p := &connectParams{
+		UserID:   query("user_id"),
+		UserType: query("user_type"),
+		Device:   query("device"),
+		Tags:     query("tags"),
+		TZ:       query("tz"),
+		Channel:  query("channel"),
+		RoomID:   query("room_id"),
+	}
+	p.applyClaims(claims)
+	// Override type from query ?type=<stream>
+	if streamType := query("type"); streamType != "" {
+		p.UserType = streamType
+	}
+	if !auth.ValidBrandID(p.BrandID) {
+		log.Warn().Str("brand_id", p.BrandID).Str("user_id", p.UserID).Msg("Rejected invalid brand_id")
+		metrics.AuthFailure.Inc()
+		return nil, auth.ErrInvalidBrand
+	}
+	metrics.AuthSuccess.Inc()
+	return p, nil
+}
+
+// applyClaims gives JWT claims priority over query parameters
+func (p *connectParams) applyClaims(claims *auth.Claims) {
+	if claims.UserID != 0 {
+		p.UserID = fmt.Sprintf("%d", claims.UserID)
+	}
+	p.BrandID = claims.BrandID
+	p.Role = claims.Role
+	if claims.Type != "" {
+		p.UserType = claims.Type
+	}
+	p.ClaimType = claims.Type
+	p.Rooms = claims.Rooms
+}
+
+// openConnection registers a connection for an authenticated client, joins
+// its initial rooms and queues the "connected" frame
+func (s *Server) openConnection(p *connectParams, transport string, codec protocol.Codec) (*room.Connection, error) {
+	if p.RoomID != "" && !isValidRoomID(p.RoomID) {
+		return nil, errInvalidRoom
+	}
+
+	connID := uuid.New().String()
+	conn := room.NewConnection(connID, transport, p.UserID, p.BrandID, p.Role, p.UserType)
+	conn.ClaimType = p.ClaimType
+	conn.Device = p.Device
+	conn.Tags = p.Tags
+	conn.Timezone = p.TZ
+	conn.Channel = p.Channel
+	conn.RemoteIP = p.RemoteIP
+	conn.Codec = codec
+	conn.Sequenced = s.cfg().WS.SequenceNumbers
+	conn.PlatformAdmin = s.isPlatformAdmin(p.Role)
+	conn.SetSettings(s.brands.Resolve(p.BrandID))
+	if p.RoomID != "" && !conn.CanAccess(p.RoomID) {
+		return nil, room.ErrCrossBrand
+	}
+
+	s.roomManager.AddConnection(conn)
+	s.evictOldest(conn)
+
+	if p.RoomID != "" {
+		s.roomManager.JoinRoom(connID, p.RoomID)
+	}
+	for _, r := range p.Rooms {
+		if !isValidRoomID(r) {
+			continue
+		}
+		if err := s.roomManager.JoinRoom(connID, r); err != nil {
+			log.Warn().Err(err).Str("conn_id", connID).Str("room", r).Msg("Skipped room from JWT")
+		}
+	}
+
+	welcome, _ := json.Marshal(map[string]interface{}{
+		"conn_id":   connID,
+		"transport": transport,
+		"protocol":  codec.Name(),
+		"user_id":   p.UserID,
+		"brand_id":  p.BrandID,
+		"role":      p.Role,
+		"user_type": p.UserType,
+		"device":    p.Device,
+		"tags":      p.Tags,
+		"tz":        p.TZ,
+		"channel":   p.Channel,
+		"room_id":   p.RoomID,
+		"settings":  conn.Settings(),
+	})
+	s.sendFrame(conn, ServerMessage{
+		Type:      "connected",
+		Payload:   welcome,
+		Timestamp: time.Now(),
+	})
+	return conn, nil
+}
+
+// applyBrandSettings swaps changed brand settings into the brand's open
+// connections and tells their clients. A lower max_tabs applies to new
+// connections only.
+func (s *Server) applyBrandSettings(brandID string, settings *brand.Settings) {
+	payload, _ := json.Marshal(settings)
+	conns := s.roomManager.GetBrandConnections(brandID)
+	for _, conn := range conns {
+		conn.SetSettings(settings)
+		s.sendFrame(conn, ServerMessage{
+			Type:      "settings",
+			Payload:   payload,
+			Timestamp: time.Now(),
+		})
+	}
+	log.Info().Str("brand_id", brandID).Int("connections", len(conns)).Msg("Applied brand settings")
+}
+
+// isPlatformAdmin reports whether a JWT role may use other brands' rooms
+func (s *Server) isPlatformAdmin(role string) bool {
+	for _, r := range s.cfg().Tenancy.AdminRoles {
+		if role != "" && role == r {
+			return true
+		}
+	}
+	return false
+}
+
+// roomScope resolves a room named by a client to the brand and plain room ID
+// it belongs to; qualified rooms of other brands need a platform admin
+func roomScope(conn *room.Connection, roomID string) (brandID, plain string, err error) {
+	if !conn.CanAccess(roomID) {
+		return "", "", room.ErrCrossBrand
+	}
+	if brandID, plain, qualified := room.SplitRoom(roomID); qualified {
+		return brandID, plain, nil
+	}
+	return conn.BrandID, roomID, nil
+}
+
+// connectError is the error frame for a failed authenticate/openConnection
+func connectError(err error) ServerMessage {
+	code, message := "AUTH_FAILED", "Invalid token"
+	switch {
+	case errors.Is(err, auth.ErrInvalidBrand):
+		message = "invalid brand_id"
+	case errors.Is(err, errInvalidRoom):
+		code, message = "INVALID_ROOM", "invalid room_id"
+	case errors.Is(err, room.ErrCrossBrand):
+		code, message = "FORBIDDEN_ROOM", err.Error()
+	}
+	return ServerMessage{
+		Type:      "error",
+		Payload:   errorPayload(code, message),
+		Timestamp: time.Now(),
+	}
+}
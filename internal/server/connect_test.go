@@ -0,0 +1,127 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/auth"
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/attchat/attchat-gateway/internal/protocol"
+	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestServer is a server without NATS and a signer for its tokens
+func newTestServer(t *testing.T, modify func(c *config.Config)) (*Server, func(claims auth.Claims) string) {
+	t.Helper()
+	cfg, key := loadConfig(t)
+	if modify != nil {
+		modify(cfg)
+	}
+	srv, err := New(config.NewLive(cfg), room.NewManager(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(claims auth.Claims) string {
+		t.Helper()
+		claims.Issuer = "attchat"
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		token, err := auth.GenerateToken(key, &claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	return srv, sign
+}
+
+// query looks up fixed query parameters, like fiber's Query
+func query(params map[string]string) lookupFunc {
+	return func(key string, defaultValue ...string) string { return params[key] }
+}
+
+func noHeaders(string, ...string) string { return "" }
+
+// Tenant access comes from the token: ?role= and ?brand_id= grant nothing
+func TestAuthenticateIgnoresTenantQuery(t *testing.T) {
+	srv, sign := newTestServer(t, nil)
+
+	tests := []struct {
+		name   string
+		claims auth.Claims
+		brand  string
+		role   string
+	}{
+		{"token without brand or role", auth.Claims{UserID: 7}, "", ""},
+		{"token with brand and role", auth.Claims{UserID: 7, BrandID: "b1", Role: "cskh"}, "b1", "cskh"},
+	}
+	for _, tt := range tests {
+		p, err := srv.authenticate(query(map[string]string{
+			"token":    sign(tt.claims),
+			"brand_id": "b2",
+			"role":     "platform_admin",
+		}), noHeaders)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if p.BrandID != tt.brand || p.Role != tt.role {
+			t.Errorf("%s: brand %q, role %q, want %q, %q", tt.name, p.BrandID, p.Role, tt.brand, tt.role)
+		}
+
+		conn, err := srv.openConnection(p, room.TransportPoll, protocol.JSON)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if conn.PlatformAdmin || conn.CanAccess("b2/chat:1") {
+			t.Errorf("%s: ?role=platform_admin granted cross-brand access", tt.name)
+		}
+	}
+
+	// The role claim still makes a platform admin
+	p, err := srv.authenticate(query(map[string]string{"token": sign(auth.Claims{UserID: 7, BrandID: "b1", Role: "platform_admin"})}), noHeaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := srv.openConnection(p, room.TransportPoll, protocol.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conn.PlatformAdmin {
+		t.Error("platform_admin role claim is not a platform admin")
+	}
+}
+
+// Clients cannot leave the rooms that brand quotas and user events rely on
+func TestLeaveDefaultRoom(t *testing.T) {
+	srv, sign := newTestServer(t, nil)
+	p, err := srv.authenticate(query(map[string]string{"token": sign(auth.Claims{UserID: 7, BrandID: "b1", Type: "cskh"})}), noHeaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := srv.openConnection(p, room.TransportPoll, protocol.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.pollFrames(conn, 0) // connected
+
+	for _, r := range []string{"user:7", "brand:b1", "b1/brand:b1", "folder:b1:waiting"} {
+		srv.handleClientMessage(conn, &ClientMessage{Type: "leave", ID: "l1", Room: r})
+		frames, _ := srv.pollFrames(conn, 0)
+		if len(frames) != 1 || !strings.Contains(string(frames[0]), `"FORBIDDEN_ROOM"`) {
+			t.Errorf("leave %s: frames %s, want FORBIDDEN_ROOM", r, frames)
+		}
+		if !conn.IsInRoom(strings.TrimPrefix(r, "b1/")) {
+			t.Errorf("leave %s: connection left its default room", r)
+		}
+	}
+	if n := srv.roomManager.BrandConnectionCount("b1"); n != 1 {
+		t.Errorf("BrandConnectionCount = %d, want 1", n)
+	}
+
+	srv.roomManager.JoinRoom(conn.ID, "chat:1")
+	srv.handleClientMessage(conn, &ClientMessage{Type: "leave", ID: "l2", Room: "chat:1"})
+	if frames, _ := srv.pollFrames(conn, 0); len(frames) != 1 || !strings.Contains(string(frames[0]), `"left"`) {
+		t.Errorf("leave chat:1: frames %s", frames)
+	}
+}
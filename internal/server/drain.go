@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/rs/zerolog/log"
+)
+
+// reconnectHint is the payload of the "reconnect" frame sent while draining
+type reconnectHint struct {
+	Reason  string `json:"reason"`
+	DelayMS int64  `json:"delay_ms"`
+	URL     string `json:"url,omitempty"`
+}
+
+// Drain takes the node out of service: readiness turns not-ready, new
+// upgrades are refused, every client is told to reconnect after a jittered
+// delay and, after the drain grace, connections are closed in paced batches
+// over the drain window. Connections still open when ctx expires are closed
+// immediately.
+func (s *Server) Drain(ctx context.Context) {
+	if s.draining.Swap(true) {
+		return
+	}
+
+	cfg := s.cfg().Shutdown
+	conns := s.roomManager.Connections()
+	log.Info().
+		Int("connections", len(conns)).
+		Dur("window", cfg.DrainWindow).
+		Msg("Draining connections")
+
+	for _, conn := range conns {
+		payload, _ := json.Marshal(reconnectHint{
+			Reason:  "server_shutdown",
+			DelayMS: jitter(cfg.ReconnectDelayMin, cfg.ReconnectDelayMax).Milliseconds(),
+			URL:     cfg.ReconnectURL,
+		})
+		s.sendFrame(conn, ServerMessage{
+			Type:      "reconnect",
+			Payload:   payload,
+			Timestamp: time.Now(),
+		})
+	}
+
+	// Give load balancers time to see the node not ready before closing
+	select {
+	case <-ctx.Done():
+	case <-time.After(cfg.DrainGrace):
+	}
+
+	interval := cfg.BatchInterval
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+	batches := int(cfg.DrainWindow / interval)
+	if batches < 1 {
+		batches = 1
+	}
+	batchSize := (len(conns) + batches - 1) / batches
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for len(conns) > 0 {
+		n := min(batchSize, len(conns))
+		for _, conn := range conns[:n] {
+			s.roomManager.Disconnect(conn.ID, room.CloseGoingAway, "server shutting down")
+		}
+		conns = conns[n:]
+		if len(conns) == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Warn().Int("remaining", len(conns)).Msg("Drain deadline reached, closing remaining connections")
+			for _, conn := range conns {
+				s.roomManager.Disconnect(conn.ID, room.CloseGoingAway, "server shutting down")
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+
+	log.Info().Msg("All connections drained")
+}
+
+// Draining reports whether the node is draining
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// jitter returns a random duration in [lo, hi]
+func jitter(lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	return lo + rand.N(hi-lo+1)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/auth"
+	"github.com/attchat/attchat-gateway/internal/config"
+)
+
+// A draining node stays open for the drain grace, and a long-poll client
+// between polls still gets its reconnect hint and close info
+func TestDrainPollSession(t *testing.T) {
+	srv, sign := newTestServer(t, func(c *config.Config) {
+		c.Fallback.Enabled = true
+		c.Fallback.PollTimeout = 10 * time.Millisecond
+		c.Shutdown.DrainGrace = 200 * time.Millisecond
+		c.Shutdown.DrainWindow = 0
+	})
+	token := sign(auth.Claims{UserID: 7, BrandID: "b1"})
+
+	poll := func(connID string) (int, string) {
+		t.Helper()
+		resp, err := srv.app.Test(httptest.NewRequest("GET", "/poll?token="+token+"&conn_id="+connID, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := poll("")
+	var opened pollResponse
+	json.Unmarshal([]byte(body), &opened)
+	if status != 200 || opened.ConnID == "" {
+		t.Fatalf("open: status %d, %s", status, body)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		srv.Drain(context.Background())
+		close(drained)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := srv.readiness()["drain"]; !ok {
+		t.Error("readiness does not report the drain")
+	}
+	if _, ok := srv.roomManager.GetConnection(opened.ConnID); !ok {
+		t.Error("connection closed before the drain grace passed")
+	}
+
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain did not return")
+	}
+	if srv.roomManager.Count() != 0 {
+		t.Errorf("%d connections left after the drain", srv.roomManager.Count())
+	}
+
+	// The session was closed between polls: the next poll returns the
+	// reconnect frame, the one after the close info
+	if status, body := poll(opened.ConnID); status != 200 || !strings.Contains(body, `"type":"reconnect"`) {
+		t.Errorf("poll after drain: status %d, %s, want the reconnect frame", status, body)
+	}
+	status, body = poll(opened.ConnID)
+	if status != 410 || !strings.Contains(body, "SESSION_CLOSED") || !strings.Contains(body, `"close":1001`) {
+		t.Errorf("second poll after drain: status %d, %s, want SESSION_CLOSED 1001", status, body)
+	}
+	if status, body := poll(opened.ConnID); status != 410 || !strings.Contains(body, "SESSION_GONE") {
+		t.Errorf("poll after the close info: status %d, %s, want SESSION_GONE", status, body)
+	}
+
+	if _, ok := srv.closedPolls.Load(opened.ConnID); ok {
+		t.Error("closed session kept after its close info was polled")
+	}
+}
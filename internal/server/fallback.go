@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/metrics"
+	"github.com/attchat/attchat-gateway/internal/protocol"
+	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/attchat/attchat-gateway/internal/transport"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// pollResponse is the body of a long-poll response
+type pollResponse struct {
+	ConnID   string            `json:"conn_id"`
+	Messages []json.RawMessage `json:"messages"`
+}
+
+// refuseWhileDraining rejects new connections once the node is draining
+func (s *Server) refuseWhileDraining(c *fiber.Ctx) error {
+	if s.draining.Load() {
+		c.Set(fiber.HeaderRetryAfter, "5")
+		return fiber.NewError(fiber.StatusServiceUnavailable, "server is draining")
+	}
+	return c.Next()
+}
+
+// sseHandler streams frames as Server-Sent Events
+// @Summary SSE transport
+// @Description Streams the same frames as /ws, one per "data:" event. The connection closes with an "close" event carrying {code, reason}. Client messages go to POST /send with the conn_id from the "connected" frame.
+// @Tags transport
+// @Produce text/event-stream
+// @Param token query string false "JWT (or Authorization header)"
+// @Param room_id query string false "Room to join"
+// @Success 200 {string} string "event stream"
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /sse [get]
+func (s *Server) sseHandler(c *fiber.Ctx) error {
+	params, err := s.authenticateRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(connectError(err))
+	}
+	if ok, err := s.admit(c, params.RemoteIP, params); !ok {
+		return err
+	}
+	conn, err := s.openConnection(params, room.TransportSSE, protocol.JSON)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(connectError(err))
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	netConn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer s.roomManager.RemoveConnection(conn.ID)
+		s.writeLoop(transport.NewSSE(w, netConn, s.cfg().WS.WriteTimeout), conn)
+	})
+	return nil
+}
+
+// pollHandler opens a long-poll session or waits for its next frames
+// @Summary Long-poll transport
+// @Description Without conn_id, opens a session and returns the "connected" frame. With conn_id, waits up to fallback.poll_timeout for frames. A closed or expired session returns 410.
+// @Tags transport
+// @Produce json
+// @Param token query string false "JWT (or Authorization header)"
+// @Param conn_id query string false "Session from the connected frame"
+// @Param room_id query string false "Room to join when opening a session"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 410 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /poll [get]
+func (s *Server) pollHandler(c *fiber.Ctx) error {
+	params, err := s.authenticateRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(connectError(err))
+	}
+
+	connID := c.Query("conn_id")
+	if connID == "" {
+		if s.draining.Load() {
+			c.Set(fiber.HeaderRetryAfter, "5")
+			return fiber.NewError(fiber.StatusServiceUnavailable, "server is draining")
+		}
+		if ok, err := s.admit(c, params.RemoteIP, params); !ok {
+			return err
+		}
+		conn, err := s.openConnection(params, room.TransportPoll, protocol.JSON)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(connectError(err))
+		}
+		frames, _ := s.pollFrames(conn, 0)
+		return c.JSON(pollResponse{ConnID: conn.ID, Messages: frames})
+	}
+
+	// Every poll reads the session's frames, so the origin is checked on
+	// each one, as on /send
+	if ok, err := s.checkOrigin(c, params); !ok {
+		return err
+	}
+	conn, ok := s.sessionConnection(connID, params, room.TransportPoll)
+	if !ok {
+		conn, ok = s.closedPoll(connID, params)
+	}
+	if !ok {
+		return sessionGone(c)
+	}
+	conn.UpdateLastPing()
+
+	frames, closed := s.pollFrames(conn, s.cfg().Fallback.PollTimeout)
+	if closed && len(frames) == 0 {
+		s.closedPolls.Delete(conn.ID)
+		code, reason := conn.CloseInfo()
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"code":    "SESSION_CLOSED",
+			"message": reason,
+			"close":   code,
+		})
+	}
+	return c.JSON(pollResponse{ConnID: conn.ID, Messages: frames})
+}
+
+// pollFrames collects queued frames, waiting up to wait for the first one
+func (s *Server) pollFrames(conn *room.Connection, wait time.Duration) (frames []json.RawMessage, closed bool) {
+	frames = make([]json.RawMessage, 0)
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case out, ok := <-conn.SendChannel():
+			if !ok {
+				return frames, true
+			}
+			frames = appendPollFrame(frames, conn, out)
+		case <-timer.C:
+			return frames, false
+		}
+	}
+
+	max := s.cfg().Fallback.MaxBatch
+	for max <= 0 || len(frames) < max {
+		select {
+		case out, ok := <-conn.SendChannel():
+			if !ok {
+				return frames, true
+			}
+			frames = appendPollFrame(frames, conn, out)
+		default:
+			return frames, false
+		}
+	}
+	return frames, false
+}
+
+func appendPollFrame(frames []json.RawMessage, conn *room.Connection, out room.Outgoing) []json.RawMessage {
+	data, err := conn.Encode(out)
+	if err != nil {
+		log.Warn().Err(err).Str("conn_id", conn.ID).Msg("Failed to encode frame")
+		return frames
+	}
+	return append(frames, data)
+}
+
+// sendHandler accepts a client message for an SSE or long-poll session
+// @Summary Send client message
+// @Description Accepts the same messages a WebSocket client sends. Replies arrive on the session's SSE stream or poll.
+// @Tags transport
+// @Accept json
+// @Produce json
+// @Param token query string false "JWT (or Authorization header)"
+// @Param conn_id query string true "Session from the connected frame"
+// @Success 202 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 410 {object} map[string]interface{}
+// @Failure 413 {object} map[string]interface{}
+// @Router /send [post]
+func (s *Server) sendHandler(c *fiber.Ctx) error {
+	params, err := s.authenticate(c.Query, c.Get)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(connectError(err))
+	}
+	if ok, err := s.checkOrigin(c, params); !ok {
+		return err
+	}
+	conn, ok := s.sessionConnection(c.Query("conn_id"), params, room.TransportSSE, room.TransportPoll)
+	if !ok {
+		return sessionGone(c)
+	}
+
+	if int64(len(c.Body())) > s.cfg().WS.MaxMessageSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"code": "MESSAGE_TOO_LARGE", "message": "message exceeds ws.max_message_size"})
+	}
+
+	var msg ClientMessage
+	if err := json.Unmarshal(c.Body(), &msg); err != nil || msg.Type == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"code": "INVALID_MESSAGE", "message": "invalid message format"})
+	}
+
+	metrics.MessagesReceived.Inc()
+	conn.UpdateLastPing()
+	s.handleClientMessage(conn, &msg)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"status": "accepted"})
+}
+
+// sessionConnection finds a fallback session owned by the authenticated user
+func (s *Server) sessionConnection(connID string, p *connectParams, transports ...string) (*room.Connection, bool) {
+	conn, ok := s.roomManager.GetConnection(connID)
+	if !ok || conn.UserID != p.UserID || conn.BrandID != p.BrandID {
+		return nil, false
+	}
+	for _, t := range transports {
+		if conn.Transport == t {
+			return conn, true
+		}
+	}
+	return nil, false
+}
+
+// keepClosedPoll keeps a closed long-poll session readable until the client
+// has polled its last frames and close info, or fallback.session_timeout
+// passes
+func (s *Server) keepClosedPoll(conn *room.Connection) {
+	if conn.Transport == room.TransportPoll {
+		s.closedPolls.Store(conn.ID, conn)
+	}
+}
+
+// closedPoll finds a closed long-poll session of the client
+func (s *Server) closedPoll(connID string, p *connectParams) (*room.Connection, bool) {
+	v, ok := s.closedPolls.Load(connID)
+	if !ok {
+		return nil, false
+	}
+	conn := v.(*room.Connection)
+	if conn.UserID != p.UserID || conn.BrandID != p.BrandID {
+		return nil, false
+	}
+	return conn, true
+}
+
+func sessionGone(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusGone).JSON(fiber.Map{"code": "SESSION_GONE", "message": "session not found, reconnect"})
+}
+
+// expirePollSessions closes long-poll sessions the client stopped polling
+func (s *Server) expirePollSessions() {
+	timeout := s.cfg().Fallback.SessionTimeout
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, conn := range s.roomManager.Connections() {
+			if conn.Transport != room.TransportPoll || time.Since(conn.LastPingTime()) < timeout {
+				continue
+			}
+			s.roomManager.Disconnect(conn.ID, room.CloseGoingAway, "poll session expired")
+			log.Debug().Str("conn_id", conn.ID).Str("user_id", conn.UserID).Msg("Poll session expired")
+		}
+		// Closed sessions whose client stopped polling too
+		s.closedPolls.Range(func(id, v interface{}) bool {
+			if time.Since(v.(*room.Connection).LastPingTime()) >= timeout {
+				s.closedPolls.Delete(id)
+			}
+			return true
+		})
+	}
+}
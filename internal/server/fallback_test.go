@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/auth"
+	"github.com/attchat/attchat-gateway/internal/config"
+)
+
+// A poll session's frames are only handed out to an allowed origin
+func TestPollChecksOrigin(t *testing.T) {
+	srv, sign := newTestServer(t, func(c *config.Config) {
+		c.Fallback.Enabled = true
+		c.Fallback.PollTimeout = 10 * time.Millisecond
+		c.Origins.Allowed = []string{"https://app.example.com"}
+	})
+	token := sign(auth.Claims{UserID: 7, BrandID: "b1"})
+
+	poll := func(connID, origin string) (int, pollResponse) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/poll?token="+token+"&conn_id="+connID, nil)
+		req.Header.Set("Origin", origin)
+		resp, err := srv.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body pollResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	status, opened := poll("", "https://app.example.com")
+	if status != 200 || opened.ConnID == "" {
+		t.Fatalf("open: status %d, %+v", status, opened)
+	}
+	if status, _ := poll(opened.ConnID, "https://evil.example.com"); status != 403 {
+		t.Errorf("poll from another origin: status %d, want 403", status)
+	}
+	if status, _ := poll(opened.ConnID, "https://app.example.com"); status != 200 {
+		t.Errorf("poll from the allowed origin: status %d, want 200", status)
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// componentStatus is one entry of the readiness/liveness breakdown
+type componentStatus struct {
+	Status string `json:"status"` // "ok" or "fail"
+	Detail string `json:"detail,omitempty"`
+}
+
+func okStatus() componentStatus { return componentStatus{Status: "ok"} }
+
+func failStatus(format string, args ...interface{}) componentStatus {
+	return componentStatus{Status: "fail", Detail: fmt.Sprintf(format, args...)}
+}
+
+// readyHandler reports whether this node should receive traffic
+// @Summary Readiness probe
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /ready [get]
+func (s *Server) readyHandler(c *fiber.Ctx) error {
+	components := s.readiness()
+	return probeResponse(c, "ready", "not_ready", components)
+}
+
+// liveHandler reports whether the process is making progress
+// @Summary Liveness probe
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /live [get]
+func (s *Server) liveHandler(c *fiber.Ctx) error {
+	components := s.liveness()
+	return probeResponse(c, "alive", "wedged", components)
+}
+
+func probeResponse(c *fiber.Ctx, okLabel, failLabel string, components map[string]componentStatus) error {
+	for _, cs := range components {
+		if cs.Status != "ok" {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"status":     failLabel,
+				"components": components,
+			})
+		}
+	}
+	return c.JSON(fiber.Map{
+		"status":     okLabel,
+		"components": components,
+	})
+}
+
+// readiness checks NATS, every stream consumer and draining
+func (s *Server) readiness() map[string]componentStatus {
+	components := map[string]componentStatus{}
+
+	if s.nats.Connected() {
+		components["nats"] = okStatus()
+	} else {
+		components["nats"] = failStatus("not connected")
+	}
+
+	maxLag := s.cfg().Health.MaxConsumerLag
+	for _, st := range s.nats.StreamStatuses() {
+		key := "consumer:" + st.Stream
+		switch {
+		case !st.Running:
+			components[key] = failStatus("consumer not running")
+		case maxLag > 0 && st.Pending > maxLag:
+			components[key] = failStatus("lagging: %d pending > %d", st.Pending, maxLag)
+		default:
+			components[key] = okStatus()
+		}
+	}
+
+	if s.draining.Load() {
+		components["drain"] = failStatus("node is draining")
+	} else {
+		components["drain"] = okStatus()
+	}
+
+	return components
+}
+
+// liveness detects a starved scheduler or a stuck NATS message handler
+func (s *Server) liveness() map[string]componentStatus {
+	components := map[string]componentStatus{}
+
+	interval := s.livenessInterval()
+	since := time.Since(time.Unix(0, s.heartbeat.Load()))
+	if since > 3*interval {
+		components["watchdog"] = failStatus("no heartbeat for %s", since.Round(time.Millisecond))
+	} else {
+		components["watchdog"] = okStatus()
+	}
+
+	stall := s.cfg().Health.StallTimeout
+	for _, st := range s.nats.StreamStatuses() {
+		key := "handler:" + st.Stream
+		if stall > 0 && st.BusyFor > stall {
+			components[key] = failStatus("message handler busy for %s", st.BusyFor.Round(time.Millisecond))
+		} else {
+			components[key] = okStatus()
+		}
+	}
+
+	return components
+}
+
+// watchdog ticks a heartbeat the liveness probe checks for freshness
+func (s *Server) watchdog() {
+	interval := s.livenessInterval()
+	s.heartbeat.Store(time.Now().UnixNano())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.heartbeat.Store(time.Now().UnixNano())
+	}
+}
+
+func (s *Server) livenessInterval() time.Duration {
+	if s.cfg().Health.LivenessInterval > 0 {
+		return s.cfg().Health.LivenessInterval
+	}
+	return time.Second
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/attchat/attchat-gateway/internal/metrics"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	config.RegisterCheck(func(c *config.Config) error {
+		_, err := newOriginPolicy(c.Origins)
+		return err
+	})
+}
+
+// originPattern is an allowed origin: an exact scheme://host[:port] or a
+// wildcard scheme://*.domain[:port] matching any subdomain
+type originPattern struct {
+	scheme   string
+	host     string // exact host, or ".domain" suffix for wildcards
+	wildcard bool
+}
+
+func parseOriginPattern(s string) (originPattern, error) {
+	scheme, host, err := splitOrigin(s)
+	if err != nil {
+		return originPattern{}, err
+	}
+	if strings.HasPrefix(host, "*.") {
+		host = host[1:]
+		if strings.Contains(host, "*") {
+			return originPattern{}, fmt.Errorf("only a leading *. wildcard is supported")
+		}
+		return originPattern{scheme: scheme, host: host, wildcard: true}, nil
+	}
+	if strings.Contains(host, "*") {
+		return originPattern{}, fmt.Errorf("only a leading *. wildcard is supported")
+	}
+	return originPattern{scheme: scheme, host: host}, nil
+}
+
+func (p originPattern) match(scheme, host string) bool {
+	if scheme != p.scheme {
+		return false
+	}
+	if p.wildcard {
+		return len(host) > len(p.host) && strings.HasSuffix(host, p.host)
+	}
+	return host == p.host
+}
+
+// splitOrigin lower-cases an origin and splits it into scheme and host[:port]
+func splitOrigin(origin string) (scheme, host string, err error) {
+	u, err := url.Parse(strings.ToLower(strings.TrimSpace(origin)))
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return "", "", fmt.Errorf("origin must be scheme://host[:port]")
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// originPolicy decides which browser origins may connect and call the API
+type originPolicy struct {
+	any          bool // origins.allowed contains "*"
+	allowMissing bool
+	global       []originPattern
+	brands       map[string][]originPattern
+}
+
+func newOriginPolicy(cfg config.OriginsConfig) (*originPolicy, error) {
+	p := &originPolicy{
+		allowMissing: cfg.AllowMissing,
+		brands:       make(map[string][]originPattern, len(cfg.Brands)),
+	}
+	for _, o := range cfg.Allowed {
+		if o == "*" {
+			p.any = true
+			continue
+		}
+		pattern, err := parseOriginPattern(o)
+		if err != nil {
+			return nil, fmt.Errorf("invalid origins.allowed entry %q: %w", o, err)
+		}
+		p.global = append(p.global, pattern)
+	}
+	for _, b := range cfg.Brands {
+		if b.Brand == "" {
+			return nil, fmt.Errorf("origins.brands entry without brand")
+		}
+		for _, o := range b.Origins {
+			pattern, err := parseOriginPattern(o)
+			if err != nil {
+				return nil, fmt.Errorf("invalid origins.brands origin %q for brand %s: %w", o, b.Brand, err)
+			}
+			p.brands[b.Brand] = append(p.brands[b.Brand], pattern)
+		}
+	}
+	if p.any && cfg.AllowCredentials {
+		return nil, fmt.Errorf("origins.allow_credentials requires an explicit origins.allowed list")
+	}
+	return p, nil
+}
+
+// allowed reports whether origin may connect as a client of brandID: it
+// matches origins.allowed or the brand's own origins
+func (p *originPolicy) allowed(origin, brandID string) bool {
+	if origin == "" {
+		return p.allowMissing
+	}
+	if p.any {
+		return true
+	}
+	scheme, host, err := splitOrigin(origin)
+	if err != nil {
+		return false
+	}
+	if matchOrigin(p.global, scheme, host) {
+		return true
+	}
+	return brandID != "" && matchOrigin(p.brands[brandID], scheme, host)
+}
+
+// allowedForAnyBrand is the CORS check, which runs before the brand is known
+func (p *originPolicy) allowedForAnyBrand(origin string) bool {
+	if p.allowed(origin, "") {
+		return true
+	}
+	scheme, host, err := splitOrigin(origin)
+	if err != nil {
+		return false
+	}
+	for _, patterns := range p.brands {
+		if matchOrigin(patterns, scheme, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOrigin(patterns []originPattern, scheme, host string) bool {
+	for _, pattern := range patterns {
+		if pattern.match(scheme, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin rejects a request whose Origin is not allowed for the client's
+// brand with 403 ORIGIN_NOT_ALLOWED. p is nil before authentication; only
+// origins allowed for no brand at all are rejected then.
+func (s *Server) checkOrigin(c *fiber.Ctx, p *connectParams) (bool, error) {
+	origin := c.Get(fiber.HeaderOrigin)
+	var ok bool
+	if p == nil {
+		ok = s.origins.Load().allowedForAnyBrand(origin)
+	} else {
+		ok = s.origins.Load().allowed(origin, p.BrandID)
+	}
+	if ok {
+		return true, nil
+	}
+	metrics.AdmissionRejected.WithLabelValues("origin").Inc()
+
+	ev := log.Warn().Str("origin", origin).Str("path", c.Path())
+	if p != nil {
+		ev = ev.Str("user_id", p.UserID).Str("brand_id", p.BrandID)
+	}
+	ev.Msg("Request rejected: origin not allowed")
+
+	return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"code":    "ORIGIN_NOT_ALLOWED",
+		"message": "origin not allowed",
+		"origin":  origin,
+	})
+}
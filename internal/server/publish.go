@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/nats"
+	"github.com/attchat/attchat-gateway/internal/routing"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const publishTimeout = 5 * time.Second
+
+// publishItem is one event of a publish request, with an optional subject
+type publishItem struct {
+	Subject string `json:"subject,omitempty"`
+	nats.Event
+}
+
+// publishResult reports what happened to one event of a publish request
+type publishResult struct {
+	Index     int    `json:"index"`
+	ID        string `json:"id,omitempty"`
+	Delivered *int   `json:"delivered,omitempty"` // local mode
+	Stream    string `json:"stream,omitempty"`    // stream mode
+	Sequence  uint64 `json:"seq,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// publishAuth identifies the calling service from X-Api-Key or a bearer token
+func (s *Server) publishAuth(c *fiber.Ctx) error {
+	key := c.Get("X-Api-Key")
+	if key == "" {
+		key = strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	}
+	for _, svc := range s.cfg().Publish.Services {
+		if svc.Key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(svc.Key)) == 1 {
+			c.Locals("service", svc.Name)
+			return c.Next()
+		}
+	}
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"code": "UNAUTHORIZED", "message": "invalid api key"})
+}
+
+// publishHandler accepts events from backends that cannot speak NATS
+// @Summary Publish events
+// @Description Accepts one event, an array of events or {"events": [...]}. In stream mode events are stored in JetStream and delivered by every node; in local mode they are delivered by this node only.
+// @Tags publish
+// @Accept json
+// @Produce json
+// @Param mode query string false "stream or local (default from publish.mode)"
+// @Success 200 {object} map[string]interface{}
+// @Success 207 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /api/publish [post]
+func (s *Server) publishHandler(c *fiber.Ctx) error {
+	items, err := decodePublishItems(c.Body())
+	if err != nil || len(items) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"code": "INVALID_BODY", "message": "expected an event, an array of events or {\"events\": [...]}"})
+	}
+	if max := s.cfg().Publish.MaxEvents; max > 0 && len(items) > max {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"code": "TOO_MANY_EVENTS", "message": "too many events in one request"})
+	}
+
+	mode := c.Query("mode", s.cfg().Publish.Mode)
+	if mode != "stream" && mode != "local" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"code": "INVALID_MODE", "message": "mode must be stream or local"})
+	}
+
+	service, _ := c.Locals("service").(string)
+	correlationID := c.Get("X-Request-Id")
+	if correlationID == "" {
+		correlationID = uuid.New().String()
+	}
+	traceParent := c.Get("traceparent")
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), publishTimeout)
+	defer cancel()
+
+	results := make([]publishResult, len(items))
+	failed := 0
+	for i := range items {
+		item := &items[i]
+		event := &item.Event
+		if event.Source == "" {
+			event.Source = "/http/" + service
+		}
+		if event.ID == "" {
+			event.ID = uuid.New().String()
+		}
+		results[i] = publishResult{Index: i, ID: event.ID}
+
+		if event.Type == "" || event.Target().IsZero() {
+			results[i].Error = "event needs a type and a routing target"
+			failed++
+			continue
+		}
+
+		if mode == "local" {
+			delivered := s.nats.RouteLocal(event)
+			results[i].Delivered = &delivered
+			continue
+		}
+
+		subject := item.Subject
+		if subject == "" {
+			subject = routing.Render(s.cfg().Publish.DefaultSubject, routing.Fields{Brand: event.BrandID})
+		}
+		if !s.nats.HasStream(subject) {
+			results[i].Error = "subject is not part of a configured stream"
+			failed++
+			continue
+		}
+
+		ack, err := s.nats.PublishEvent(ctx, subject, event, nats.Meta{
+			CorrelationID: correlationID,
+			TraceParent:   traceParent,
+		})
+		if err != nil {
+			log.Warn().Err(err).Str("service", service).Str("subject", subject).Msg("HTTP publish failed")
+			results[i].Error = "publish failed"
+			failed++
+			continue
+		}
+		results[i].Stream = ack.Stream
+		results[i].Sequence = ack.Sequence
+		results[i].Duplicate = ack.Duplicate
+	}
+
+	log.Debug().
+		Str("service", service).
+		Str("mode", mode).
+		Int("events", len(items)).
+		Int("failed", failed).
+		Msg("HTTP publish")
+
+	status := fiber.StatusOK
+	switch {
+	case failed == len(items):
+		status = fiber.StatusUnprocessableEntity
+	case failed > 0:
+		status = fiber.StatusMultiStatus
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"accepted": len(items) - failed,
+		"failed":   failed,
+		"results":  results,
+	})
+}
+
+// decodePublishItems accepts a single event, an array or {"events": [...]}
+func decodePublishItems(body []byte) ([]publishItem, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var items []publishItem
+		err := json.Unmarshal(body, &items)
+		return items, err
+	}
+
+	var wrapper struct {
+		Events []publishItem `json:"events"`
+	}
+	if err := json.Unmarshal(body, &wrapper); err != nil {
+		return nil, err
+	}
+	if wrapper.Events != nil {
+		return wrapper.Events, nil
+	}
+
+	var item publishItem
+	if err := json.Unmarshal(body, &item); err != nil {
+		return nil, err
+	}
+	return []publishItem{item}, nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/metrics"
+	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/rs/zerolog/log"
+)
+
+// rateLimitedPayload is the payload of a RATE_LIMITED error frame
+type rateLimitedPayload struct {
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	Limit        string `json:"limit"`
+	RetryAfterMS int64  `json:"retry_after_ms"`
+}
+
+// allowMessage applies rate limits. Rejected messages get a RATE_LIMITED
+// error frame; sustained abuse closes the connection.
+func (s *Server) allowMessage(conn *room.Connection, msg *ClientMessage) bool {
+	limiter := s.limiter.Load()
+	if limiter == nil {
+		return true
+	}
+	d := limiter.Allow(conn.ID, conn.BrandID, conn.UserID, conn.ClaimType, msg.Type)
+	if d.Allowed {
+		return true
+	}
+	metrics.RateLimited.WithLabelValues(d.Limit).Inc()
+
+	if d.Disconnect {
+		metrics.RateLimitDisconnects.Inc()
+		payload, _ := json.Marshal(map[string]string{"reason": "rate limit exceeded"})
+		s.sendFrame(conn, ServerMessage{Type: "disconnected", Payload: payload, Timestamp: time.Now()})
+		s.roomManager.Disconnect(conn.ID, room.ClosePolicyViolation, "rate limit exceeded")
+
+		log.Warn().
+			Str("conn_id", conn.ID).
+			Str("user_id", conn.UserID).
+			Str("limit", d.Limit).
+			Msg("Connection disconnected for sustained rate limit violations")
+		return false
+	}
+
+	payload, _ := json.Marshal(rateLimitedPayload{
+		Code:         "RATE_LIMITED",
+		Message:      "too many messages",
+		Limit:        d.Limit,
+		RetryAfterMS: d.RetryAfter.Milliseconds(),
+	})
+	s.sendFrame(conn, ServerMessage{
+		Type:      "error",
+		ID:        msg.ID,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	})
+	return false
+}
+
+// forgetRateLimits drops the rate limit state of a closed connection
+func (s *Server) forgetRateLimits(conn *room.Connection) {
+	if limiter := s.limiter.Load(); limiter != nil {
+		limiter.Forget(conn.ID)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/attchat/attchat-gateway/internal/auth"
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/attchat/attchat-gateway/internal/protocol"
+	"github.com/attchat/attchat-gateway/internal/room"
+)
+
+// Per-user-type rules follow the JWT type claim, and a closed connection's
+// state is dropped
+func TestRateLimitClaimType(t *testing.T) {
+	srv, sign := newTestServer(t, func(c *config.Config) {
+		c.RateLimit = config.RateLimitConfig{
+			Enabled: true,
+			Rules: []config.RateLimitRule{
+				{Type: "*", Rate: 0.001, Burst: 1},
+				{Type: "*", UserType: "cskh", Rate: 0.001, Burst: 100},
+			},
+		}
+	})
+	token := sign(auth.Claims{UserID: 7, BrandID: "b1", Type: "customer"})
+	p, err := srv.authenticate(query(map[string]string{"token": token, "type": "cskh"}), noHeaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := srv.openConnection(p, room.TransportPoll, protocol.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &ClientMessage{Type: "typing", ID: "c1"}
+	if !srv.allowMessage(conn, msg) {
+		t.Fatal("first message limited")
+	}
+	if srv.allowMessage(conn, msg) {
+		t.Error("?type=cskh selected the cskh rule")
+	}
+
+	srv.roomManager.Disconnect(conn.ID, room.CloseNormal, "")
+	if d := srv.limiter.Load().Allow(conn.ID, conn.BrandID, conn.UserID, conn.ClaimType, "typing"); !d.Allowed {
+		t.Errorf("state kept after disconnect: %+v", d)
+	}
+}
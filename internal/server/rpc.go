@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/attchat/attchat-gateway/internal/nats"
+	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// rpcRequest is the body sent to backend services for a client RPC
+type rpcRequest struct {
+	Method   string          `json:"method"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	ConnID   string          `json:"conn_id"`
+	UserID   string          `json:"user_id"`
+	BrandID  string          `json:"brand_id,omitempty"`
+	Role     string          `json:"role,omitempty"`
+	UserType string          `json:"user_type,omitempty"`
+}
+
+// rpcRequester sends RPC requests to backend services
+type rpcRequester interface {
+	Request(ctx context.Context, subject string, data []byte, meta nats.Meta) (*nats.Reply, error)
+}
+
+// handleRPC turns a client "rpc" message into a NATS request and replies with
+// an rpc_result or rpc_error frame carrying the same id
+func (s *Server) handleRPC(conn *room.Connection, msg *ClientMessage) {
+	if msg.ID == "" || msg.Method == "" {
+		s.sendRPCError(conn, msg.ID, "INVALID_RPC", "id and method are required")
+		return
+	}
+
+	method, ok := s.rpcMethod(msg.Method)
+	if !ok {
+		s.sendRPCError(conn, msg.ID, "UNKNOWN_METHOD", "unknown method")
+		return
+	}
+	if !rpcAllowed(method, conn.ClaimType) {
+		s.sendRPCError(conn, msg.ID, "FORBIDDEN", "method not allowed for user type")
+		return
+	}
+	if s.rpc == nil {
+		s.sendRPCError(conn, msg.ID, "UNAVAILABLE", "backend not available")
+		return
+	}
+
+	data, err := json.Marshal(rpcRequest{
+		Method:   msg.Method,
+		Payload:  msg.Payload,
+		ConnID:   conn.ID,
+		UserID:   conn.UserID,
+		BrandID:  conn.BrandID,
+		Role:     conn.Role,
+		UserType: conn.ClaimType,
+	})
+	if err != nil {
+		s.sendRPCError(conn, msg.ID, "INVALID_RPC", "invalid payload")
+		return
+	}
+
+	timeout := method.Timeout
+	if timeout <= 0 {
+		timeout = s.cfg().RPC.Timeout
+	}
+	meta := nats.Meta{
+		CorrelationID: msg.ID,
+		ConnID:        conn.ID,
+		UserID:        conn.UserID,
+		BrandID:       conn.BrandID,
+		TraceParent:   traceParent(msg),
+	}
+
+	if !conn.StartRPC(s.cfg().RPC.MaxInFlight) {
+		s.sendRPCError(conn, msg.ID, "TOO_MANY_REQUESTS", "too many requests in flight")
+		return
+	}
+
+	// Wait for the reply off the read loop so the connection stays responsive
+	go func() {
+		defer conn.EndRPC()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		start := time.Now()
+		reply, err := s.rpc.Request(ctx, method.Subject, data, meta)
+		if err != nil {
+			code, message := "RPC_FAILED", "request failed"
+			switch {
+			case errors.Is(err, nats.ErrRequestTimeout):
+				code, message = "TIMEOUT", "request timed out"
+			case errors.Is(err, nats.ErrNoResponders):
+				code, message = "UNAVAILABLE", "no service available"
+			}
+			log.Warn().
+				Err(err).
+				Str("conn_id", conn.ID).
+				Str("method", msg.Method).
+				Str("subject", method.Subject).
+				Msg("RPC request failed")
+			s.sendRPCError(conn, msg.ID, code, message)
+			return
+		}
+
+		if reply.Error != "" {
+			code := reply.ErrorCode
+			if code == "" {
+				code = "RPC_FAILED"
+			}
+			s.sendRPCError(conn, msg.ID, code, reply.Error)
+			return
+		}
+
+		payload := json.RawMessage(reply.Data)
+		if len(payload) == 0 || !json.Valid(payload) {
+			payload = json.RawMessage("null")
+		}
+		s.sendFrame(conn, ServerMessage{
+			Type:      "rpc_result",
+			ID:        msg.ID,
+			Payload:   payload,
+			Timestamp: time.Now(),
+		})
+
+		log.Debug().
+			Str("conn_id", conn.ID).
+			Str("method", msg.Method).
+			Dur("latency", time.Since(start)).
+			Msg("RPC completed")
+	}()
+}
+
+// rpcMethod finds a configured RPC method by name
+func (s *Server) rpcMethod(name string) (config.RPCMethod, bool) {
+	for _, m := range s.cfg().RPC.Methods {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return config.RPCMethod{}, false
+}
+
+// rpcAllowed checks the method's allow-list against the user type claim
+func rpcAllowed(method config.RPCMethod, userType string) bool {
+	if len(method.AllowedTypes) == 0 {
+		return true
+	}
+	for _, t := range method.AllowedTypes {
+		if strings.EqualFold(t, userType) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) sendRPCError(conn *room.Connection, id, code, message string) {
+	s.sendFrame(conn, ServerMessage{
+		Type:      "rpc_error",
+		ID:        id,
+		Payload:   errorPayload(code, message),
+		Timestamp: time.Now(),
+	})
+}
+
+// sendFrame marshals a server message and queues it on the connection
+func (s *Server) sendFrame(conn *room.Connection, msg ServerMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Error().Err(err).Str("conn_id", conn.ID).Str("type", msg.Type).Msg("Failed to marshal frame")
+		return
+	}
+	conn.Send(data)
+}
+
+// errorPayload builds the {"code","message"} payload used by error frames
+func errorPayload(code, message string) json.RawMessage {
+	data, _ := json.Marshal(map[string]string{"code": code, "message": message})
+	return data
+}
+
+// newCorrelationID returns the client-supplied id or a fresh one
+func newCorrelationID(id string) string {
+	if id != "" {
+		return id
+	}
+	return uuid.New().String()
+}
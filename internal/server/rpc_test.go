@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/auth"
+	"github.com/attchat/attchat-gateway/internal/config"
+	"github.com/attchat/attchat-gateway/internal/nats"
+	"github.com/attchat/attchat-gateway/internal/protocol"
+	"github.com/attchat/attchat-gateway/internal/room"
+)
+
+// fakeRequester answers RPCs in place of NATS
+type fakeRequester func(ctx context.Context, subject string) (*nats.Reply, error)
+
+func (f fakeRequester) Request(ctx context.Context, subject string, data []byte, meta nats.Meta) (*nats.Reply, error) {
+	return f(ctx, subject)
+}
+
+// rpcServer is a server with the methods "cskh.only" (cskh users) and
+// "slow" (20ms timeout)
+func rpcServer(t *testing.T, maxInFlight int, requester fakeRequester) (*Server, func(claims auth.Claims, params map[string]string) *room.Connection) {
+	t.Helper()
+	srv, sign := newTestServer(t, func(c *config.Config) {
+		c.RPC.MaxInFlight = maxInFlight
+		c.RPC.Methods = []config.RPCMethod{
+			{Name: "cskh.only", Subject: "rpc.cskh", AllowedTypes: []string{"cskh"}},
+			{Name: "slow", Subject: "rpc.slow", Timeout: 20 * time.Millisecond},
+		}
+	})
+	srv.rpc = requester
+	open := func(claims auth.Claims, params map[string]string) *room.Connection {
+		t.Helper()
+		if params == nil {
+			params = map[string]string{}
+		}
+		params["token"] = sign(claims)
+		p, err := srv.authenticate(query(params), noHeaders)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := srv.openConnection(p, room.TransportPoll, protocol.JSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.pollFrames(conn, 0) // connected
+		return conn
+	}
+	return srv, open
+}
+
+// rpcReply is the type and error code of a reply frame
+type rpcReply struct{ Type, Code string }
+
+// rpcReplies waits for the replies to the RPCs with ids
+func rpcReplies(t *testing.T, srv *Server, conn *room.Connection, ids ...string) map[string]rpcReply {
+	t.Helper()
+	replies := make(map[string]rpcReply)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(replies) < len(ids) {
+		if time.Now().After(deadline) {
+			t.Fatalf("replies %v, want %v", replies, ids)
+		}
+		frames, _ := srv.pollFrames(conn, 100*time.Millisecond)
+		for _, f := range frames {
+			var msg struct {
+				Type    string `json:"type"`
+				ID      string `json:"id"`
+				Payload struct {
+					Code string `json:"code"`
+				} `json:"payload"`
+			}
+			json.Unmarshal(f, &msg)
+			for _, id := range ids {
+				if msg.ID == id {
+					replies[id] = rpcReply{msg.Type, msg.Payload.Code}
+				}
+			}
+		}
+	}
+	return replies
+}
+
+// The allow-list is checked against the JWT type claim, not ?type=
+func TestRPCAllowList(t *testing.T) {
+	srv, open := rpcServer(t, 16, func(ctx context.Context, subject string) (*nats.Reply, error) {
+		return &nats.Reply{Data: []byte(`{"ok":true}`)}, nil
+	})
+
+	tests := []struct {
+		name   string
+		claims auth.Claims
+		params map[string]string
+		want   string
+	}{
+		{"cskh claim", auth.Claims{UserID: 1, BrandID: "b1", Type: "cskh"}, nil, ""},
+		{"customer claim", auth.Claims{UserID: 2, BrandID: "b1", Type: "customer"}, nil, "FORBIDDEN"},
+		{"customer claim, ?type=cskh", auth.Claims{UserID: 3, BrandID: "b1", Type: "customer"}, map[string]string{"type": "cskh"}, "FORBIDDEN"},
+		{"no claim, ?type=cskh", auth.Claims{UserID: 4, BrandID: "b1"}, map[string]string{"type": "cskh"}, "FORBIDDEN"},
+	}
+	for _, tt := range tests {
+		conn := open(tt.claims, tt.params)
+		srv.handleClientMessage(conn, &ClientMessage{Type: "rpc", ID: "r1", Method: "cskh.only"})
+		r := rpcReplies(t, srv, conn, "r1")["r1"]
+		if r.Code != tt.want || (tt.want == "" && r.Type != "rpc_result") {
+			t.Errorf("%s: %+v, want %q", tt.name, r, tt.want)
+		}
+	}
+}
+
+// A method's timeout bounds the request and is reported as TIMEOUT
+func TestRPCTimeout(t *testing.T) {
+	srv, open := rpcServer(t, 16, func(ctx context.Context, subject string) (*nats.Reply, error) {
+		<-ctx.Done()
+		return nil, nats.ErrRequestTimeout
+	})
+	conn := open(auth.Claims{UserID: 1, BrandID: "b1"}, nil)
+
+	start := time.Now()
+	srv.handleClientMessage(conn, &ClientMessage{Type: "rpc", ID: "r1", Method: "slow"})
+	if r := rpcReplies(t, srv, conn, "r1")["r1"]; r != (rpcReply{"rpc_error", "TIMEOUT"}) {
+		t.Errorf("reply = %+v, want rpc_error TIMEOUT", r)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("timed out after %s, want the method's 20ms", d)
+	}
+}
+
+// A connection has at most rpc.max_in_flight RPCs waiting
+func TestRPCMaxInFlight(t *testing.T) {
+	release := make(chan struct{})
+	srv, open := rpcServer(t, 2, func(ctx context.Context, subject string) (*nats.Reply, error) {
+		<-release
+		return &nats.Reply{Data: []byte(`{}`)}, nil
+	})
+	conn := open(auth.Claims{UserID: 1, BrandID: "b1", Type: "cskh"}, nil)
+
+	for _, id := range []string{"r1", "r2", "r3"} {
+		srv.handleClientMessage(conn, &ClientMessage{Type: "rpc", ID: id, Method: "cskh.only"})
+	}
+	if r := rpcReplies(t, srv, conn, "r3")["r3"]; r != (rpcReply{"rpc_error", "TOO_MANY_REQUESTS"}) {
+		t.Errorf("third RPC = %+v, want TOO_MANY_REQUESTS", r)
+	}
+
+	close(release)
+	for id, r := range rpcReplies(t, srv, conn, "r1", "r2") {
+		if r.Type != "rpc_result" {
+			t.Errorf("%s = %+v, want rpc_result", id, r)
+		}
+	}
+	srv.handleClientMessage(conn, &ClientMessage{Type: "rpc", ID: "r4", Method: "cskh.only"})
+	if r := rpcReplies(t, srv, conn, "r4")["r4"]; r.Type != "rpc_result" {
+		t.Errorf("RPC after the others replied = %+v, want rpc_result", r)
+	}
+}
@@ -4,17 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	docs "github.com/attchat/attchat-gateway/docs"
 	"github.com/attchat/attchat-gateway/internal/auth"
+	"github.com/attchat/attchat-gateway/internal/brand"
 	"github.com/attchat/attchat-gateway/internal/config"
 	"github.com/attchat/attchat-gateway/internal/metrics"
 	"github.com/attchat/attchat-gateway/internal/nats"
+	"github.com/attchat/attchat-gateway/internal/protocol"
+	"github.com/attchat/attchat-gateway/internal/ratelimit"
 	"github.com/attchat/attchat-gateway/internal/room"
+	"github.com/attchat/attchat-gateway/internal/routing"
+	"github.com/attchat/attchat-gateway/internal/transport"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -32,10 +39,20 @@ import (
 // Server represents the WebSocket server
 type Server struct {
 	app          *fiber.App
-	cfg          *config.Config
+	adminApp     *fiber.App // admin API listener when admin.port is set
+	live         *config.Live
 	roomManager  *room.Manager
 	jwtValidator *auth.JWTValidator
 	nats         *nats.Consumer
+	rpc          rpcRequester // nats, unless there is none
+	routes       atomic.Pointer[routing.Table]
+	limiter      atomic.Pointer[ratelimit.Limiter] // nil when rate limiting is off
+	admission    *admission
+	origins      atomic.Pointer[originPolicy]
+	brands       *brand.Store
+	draining     atomic.Bool
+	closedPolls  sync.Map     // conn ID -> *room.Connection, see keepClosedPoll
+	heartbeat    atomic.Int64 // unix nanos of the last watchdog tick
 }
 
 type netSample struct {
@@ -51,22 +68,14 @@ var (
 var roomIDPattern = regexp.MustCompile(`^[A-Za-z0-9:_-]{1,128}$`)
 
 // ClientMessage represents a message from client
-type ClientMessage struct {
-	Type    string          `json:"type"`
-	Room    string          `json:"room,omitempty"`
-	Payload json.RawMessage `json:"payload,omitempty"`
-}
+type ClientMessage = protocol.ClientMessage
 
 // ServerMessage represents a message to client
-type ServerMessage struct {
-	Type      string          `json:"type"`
-	Room      string          `json:"room,omitempty"`
-	Payload   json.RawMessage `json:"payload,omitempty"`
-	Timestamp time.Time       `json:"timestamp"`
-}
+type ServerMessage = protocol.ServerMessage
 
 // New creates a new server
-func New(cfg *config.Config, roomManager *room.Manager, natsConsumer *nats.Consumer) (*Server, error) {
+func New(live *config.Live, roomManager *room.Manager, natsConsumer *nats.Consumer) (*Server, error) {
+	cfg := live.Get()
 	app := fiber.New(fiber.Config{
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
@@ -102,29 +111,121 @@ func New(cfg *config.Config, roomManager *room.Manager, natsConsumer *nats.Consu
 			},
 		},
 	}))
-	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
-	}))
-
 	validator, err := auth.NewJWTValidator(cfg.JWT)
 	if err != nil {
 		return nil, err
 	}
 
+	admission, err := newAdmission(cfg.Admission)
+	if err != nil {
+		return nil, err
+	}
+	brands, err := brand.NewStore(brand.Settings{
+		MaxTabs: cfg.Admission.MaxPerUser,
+		Typing:  true,
+	}, cfg.Brands.File)
+	if err != nil {
+		return nil, fmt.Errorf("invalid brands.file: %w", err)
+	}
+
 	s := &Server{
 		app:          app,
-		cfg:          cfg,
+		live:         live,
 		roomManager:  roomManager,
 		jwtValidator: validator,
 		nats:         natsConsumer,
+		admission:    admission,
+		brands:       brands,
+	}
+	if natsConsumer != nil {
+		s.rpc = natsConsumer
+	}
+	roomManager.OnDisconnect(s.keepClosedPoll)
+	roomManager.OnDisconnect(s.forgetRateLimits)
+	apply, err := s.Reload(cfg)
+	if err != nil {
+		return nil, err
+	}
+	apply()
+	app.Use(cors.New(cors.Config{
+		AllowOriginsFunc: func(origin string) bool { return s.origins.Load().allowedForAnyBrand(origin) },
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
+		AllowCredentials: cfg.Origins.AllowCredentials,
+	}))
+
+	live.OnReload("jwt", validator.Reload)
+	live.OnReload("server", s.Reload)
+	if natsConsumer != nil {
+		live.OnReload("nats", natsConsumer.Reload)
+	}
+
+	if bucket := cfg.Admission.QuotaBucket; bucket != "" {
+		if natsConsumer != nil {
+			natsConsumer.WatchKeyValue(bucket, admission.setKVQuota)
+		} else {
+			log.Warn().Str("bucket", bucket).Msg("NATS unavailable, brand quotas from admission.quota_bucket are not loaded")
+		}
+	}
+
+	brands.OnChange(s.applyBrandSettings)
+	if err := brands.WatchFile(); err != nil {
+		log.Warn().Err(err).Str("file", cfg.Brands.File).Msg("Cannot watch brands.file, overlay changes need a restart")
+	}
+	if bucket := cfg.Brands.Bucket; bucket != "" {
+		if natsConsumer != nil {
+			natsConsumer.WatchKeyValue(bucket, brands.SetKV)
+		} else {
+			log.Warn().Str("bucket", bucket).Msg("NATS unavailable, overlays from brands.bucket are not loaded")
+		}
 	}
 
 	s.setupRoutes()
+	go s.watchdog()
+	if cfg.Fallback.Enabled {
+		go s.expirePollSessions()
+	}
 
 	return s, nil
 }
 
+// cfg returns the current configuration
+func (s *Server) cfg() *config.Config {
+	return s.live.Get()
+}
+
+// Reload builds the routing table, rate limiter and origin policy of a
+// configuration (config.Reloader). A new limiter starts with full buckets.
+func (s *Server) Reload(next *config.Config) (func(), error) {
+	routes, err := routing.New(next.Routing.Routes)
+	if err != nil {
+		return nil, err
+	}
+	var limiter *ratelimit.Limiter
+	if next.RateLimit.Enabled {
+		if limiter, err = ratelimit.New(next.RateLimit); err != nil {
+			return nil, err
+		}
+	}
+	origins, err := newOriginPolicy(next.Origins)
+	if err != nil {
+		if limiter != nil {
+			limiter.Close()
+		}
+		return nil, err
+	}
+
+	return func() {
+		if origins.any {
+			log.Warn().Msg("origins.allowed contains \"*\": any web origin may connect")
+		}
+		s.routes.Store(routes)
+		s.origins.Store(origins)
+		if old := s.limiter.Swap(limiter); old != nil {
+			old.Close()
+		}
+	}, nil
+}
+
 // setupRoutes configures all routes
 func (s *Server) setupRoutes() {
 	// Root endpoint trả về thông tin health
@@ -134,163 +235,108 @@ func (s *Server) setupRoutes() {
 	// Health check
 	s.app.Get("/health", s.healthHandler)
 
-	// Ready check
-	s.app.Get("/ready", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"status": "ready"})
-	})
+	// Readiness and liveness probes
+	s.app.Get("/ready", s.readyHandler)
+	s.app.Get("/live", s.liveHandler)
 
 	// Stats endpoint
 	s.app.Get("/stats", func(c *fiber.Ctx) error {
 		return c.JSON(s.roomManager.GetStats())
 	})
 
-	// WebSocket upgrade middleware
-	s.app.Use("/ws", func(c *fiber.Ctx) error {
+	// HTTP publish for backends without NATS access
+	if s.cfg().Publish.Enabled {
+		s.app.Post("/api/publish", s.publishAuth, s.publishHandler)
+	}
+
+	// SSE and long-poll for clients behind proxies that block upgrades
+	if s.cfg().Fallback.Enabled {
+		s.app.Get("/sse", s.refuseWhileDraining, s.sseHandler)
+		s.app.Get("/poll", s.pollHandler)
+		s.app.Post("/send", s.sendHandler)
+	}
+
+	// WebSocket upgrade middleware; admission runs before the handshake
+	s.app.Use("/ws", s.refuseWhileDraining, func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
-	})
+	}, s.admitWebSocket)
 
 	// WebSocket endpoint
 	s.app.Get("/ws", websocket.New(s.handleWebSocket, websocket.Config{
-		ReadBufferSize:  s.cfg.WS.ReadBufferSize,
-		WriteBufferSize: s.cfg.WS.WriteBufferSize,
+		ReadBufferSize:    s.cfg().WS.ReadBufferSize,
+		WriteBufferSize:   s.cfg().WS.WriteBufferSize,
+		Subprotocols:      protocol.Subprotocols(),
+		EnableCompression: s.cfg().WS.EnableCompression,
 	}))
+
+	// Admin API, on its own port if configured
+	if s.cfg().Admin.Enabled {
+		if s.cfg().Admin.Port != "" {
+			s.adminApp = fiber.New(fiber.Config{
+				ReadTimeout:  s.cfg().Server.ReadTimeout,
+				WriteTimeout: s.cfg().Server.WriteTimeout,
+			})
+			s.adminApp.Use(recovermw.New())
+			s.setupAdminRoutes(s.adminApp)
+		} else {
+			s.setupAdminRoutes(s.app)
+		}
+	}
 }
 
 // handleWebSocket handles WebSocket connections
 func (s *Server) handleWebSocket(c *websocket.Conn) {
-	// Parse all connection params
-	token := c.Query("token")
-	if token == "" {
-		token = c.Headers("Authorization")
-		if len(token) > 7 && token[:7] == "Bearer " {
-			token = token[7:]
-		}
+	params, ok := c.Locals(localParams).(*connectParams)
+	if !ok {
+		err, _ := c.Locals(localAuthError).(error)
+		c.WriteJSON(connectError(err))
+		c.Close()
+		return
 	}
 
-	userType := c.Query("user_type")
-	streamType := c.Query("type")
-	role := c.Query("role")
-	device := c.Query("device")
-	tags := c.Query("tags")
-	tz := c.Query("tz")
-	brandID := c.Query("brand_id")
-	userID := c.Query("user_id")
-	roomID := c.Query("room_id")
-	channel := c.Query("channel")
-
-	// Validate JWT
-	claims, err := s.jwtValidator.Validate(token)
+	codec := protocol.ForName(c.Subprotocol())
+	conn, err := s.openConnection(params, room.TransportWebSocket, codec)
 	if err != nil {
-		log.Warn().
-			Err(err).
-			Str("token_prefix", prefixToken(token)).
-			Str("iss", claimsIssuer(token)).
-			Strs("allowed_issuers", s.cfg.JWT.AllowedIssuers).
-			Msg("JWT validation failed")
-		metrics.AuthFailure.Inc()
-		c.WriteJSON(ServerMessage{
-			Type:      "error",
-			Payload:   json.RawMessage(`{"code": "AUTH_FAILED", "message": "Invalid token"}`),
-			Timestamp: time.Now(),
-		})
+		c.WriteJSON(connectError(err))
 		c.Close()
 		return
 	}
-	metrics.AuthSuccess.Inc()
 
-	// Ưu tiên lấy từ JWT claims, nếu không có thì lấy từ query
-	if claims.UserID != 0 {
-		userID = fmt.Sprintf("%d", claims.UserID)
-	}
-	if claims.BrandID != "" {
-		brandID = claims.BrandID
-	}
-	if claims.Role != "" {
-		role = claims.Role
-	}
-	if claims.Type != "" {
-		userType = claims.Type
-	}
-	// Override type from query ?type=<stream>
-	if streamType != "" {
-		userType = streamType
-	}
-
-	// Create connection với metadata mở rộng
-	connID := uuid.New().String()
-	conn := room.NewConnection(connID, c, userID, brandID, role, userType)
-	// Lưu thêm các metadata mới
-	conn.Device = device
-	conn.Tags = tags
-	conn.Timezone = tz
-	conn.Channel = channel
-
-	// Add to manager
-	s.roomManager.AddConnection(conn)
-	defer s.roomManager.RemoveConnection(connID)
+	// Larger frames close the connection with 1009 before they are buffered
+	c.SetReadLimit(s.cfg().WS.MaxMessageSize)
+	t := transport.NewWebSocket(c, s.cfg().WS.WriteTimeout, codec.Binary())
 
-	// Join room từ query hoặc JWT
-	if roomID != "" {
-		if !isValidRoomID(roomID) {
-			c.WriteJSON(ServerMessage{
-				Type:      "error",
-				Payload:   json.RawMessage(`{"code": "INVALID_ROOM", "message": "invalid room_id"}`),
-				Timestamp: time.Now(),
-			})
-			c.Close()
-			return
-		}
-		s.roomManager.JoinRoom(connID, roomID)
-	}
-	for _, r := range claims.Rooms {
-		if !isValidRoomID(r) {
-			continue
-		}
-		s.roomManager.JoinRoom(connID, r)
-	}
-
-	// Send welcome message kèm metadata
-	welcome := map[string]interface{}{
-		"conn_id":   connID,
-		"user_id":   userID,
-		"brand_id":  brandID,
-		"role":      role,
-		"user_type": userType,
-		"device":    device,
-		"tags":      tags,
-		"tz":        tz,
-		"channel":   channel,
-		"room_id":   roomID,
-	}
-	welcomeBytes, _ := json.Marshal(welcome)
-	c.WriteJSON(ServerMessage{
-		Type:      "connected",
-		Payload:   welcomeBytes,
-		Timestamp: time.Now(),
-	})
-
-	// Start writer goroutine
-	go s.writeLoop(conn)
+	// Start writer goroutine. c is released for reuse when this handler
+	// returns, so the writer must be done with it by then.
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		s.writeLoop(t, conn)
+	}()
+	defer func() {
+		s.roomManager.RemoveConnection(conn.ID)
+		<-writerDone
+	}()
 
 	// Read loop
-	s.readLoop(conn)
+	s.readLoop(t, conn)
 }
 
-func (s *Server) jetStreamCounts() (streams int64, consumers int64) {
+func (s *Server) jetStreamCounts() (streams int64, consumers int64, ok bool) {
 	if s.nats == nil {
-		return 0, 0
+		return 0, 0, false
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 	streams, consumers, err := s.nats.AccountStats(ctx)
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to get JetStream account info")
-		return 0, 0
+		return 0, 0, false
 	}
-	return streams, consumers
+	return streams, consumers, true
 }
 
 // rootHandler returns root info
@@ -300,18 +346,19 @@ func (s *Server) jetStreamCounts() (streams int64, consumers int64) {
 // @Success 200 {object} map[string]interface{}
 // @Router / [get]
 func (s *Server) rootHandler(c *fiber.Ctx) error {
-	streams, consumers := s.jetStreamCounts()
+	streams, consumers, jsOK := s.jetStreamCounts()
 	sys := systemMetrics()
+	natsStatus, jsStatus, status := s.natsStatus(jsOK)
 	return c.JSON(fiber.Map{
 		"architecture": "MVC Enterprise",
-		"jetstream":    "ok",
+		"jetstream":    jsStatus,
 		"jetstream_info": fiber.Map{
 			"consumers": consumers,
 			"streams":   streams,
 		},
 		"message": "ATTChat Gateway WebSocket is running",
-		"nats":    "ok",
-		"status":  "healthy",
+		"nats":    natsStatus,
+		"status":  status,
 		"version": "2.0",
 		"stats":   s.roomManager.GetStats(),
 		"system":  sys,
@@ -325,24 +372,37 @@ func (s *Server) rootHandler(c *fiber.Ctx) error {
 // @Success 200 {object} map[string]interface{}
 // @Router /health [get]
 func (s *Server) healthHandler(c *fiber.Ctx) error {
-	streams, consumers := s.jetStreamCounts()
+	streams, consumers, jsOK := s.jetStreamCounts()
 	sys := systemMetrics()
+	natsStatus, jsStatus, status := s.natsStatus(jsOK)
 	return c.JSON(fiber.Map{
 		"architecture": "MVC Enterprise",
-		"jetstream":    "ok",
+		"jetstream":    jsStatus,
 		"jetstream_info": fiber.Map{
 			"consumers": consumers,
 			"streams":   streams,
 		},
 		"message": "ATTChat Gateway WebSocket is running",
-		"nats":    "ok",
-		"status":  "healthy",
+		"nats":    natsStatus,
+		"status":  status,
 		"version": "2.0",
 		"stats":   s.roomManager.GetStats(),
 		"system":  sys,
 	})
 }
 
+// natsStatus reports the NATS and JetStream state and the resulting overall status
+func (s *Server) natsStatus(jetStreamOK bool) (natsStatus, jsStatus, status string) {
+	natsStatus, jsStatus, status = "ok", "ok", "healthy"
+	if !jetStreamOK {
+		jsStatus, status = "unavailable", "degraded"
+	}
+	if !s.nats.Connected() {
+		natsStatus, status = "disconnected", "degraded"
+	}
+	return natsStatus, jsStatus, status
+}
+
 type systemInfo struct {
 	CPUPercent string `json:"cpu_used_percent"`
 	RAMPercent string `json:"ram_used_percent"`
@@ -434,8 +494,12 @@ func prefixToken(token string) string {
 	return token[:12] + "..."
 }
 
-func isValidRoomID(room string) bool {
-	return roomIDPattern.MatchString(room)
+// isValidRoomID accepts plain and brand-qualified room IDs
+func isValidRoomID(roomID string) bool {
+	if brandID, plain, qualified := room.SplitRoom(roomID); qualified {
+		return roomIDPattern.MatchString(brandID) && roomIDPattern.MatchString(plain)
+	}
+	return roomIDPattern.MatchString(roomID)
 }
 
 // claimsIssuer extracts issuer without verifying signature (best effort for logging)
@@ -450,7 +514,7 @@ func claimsIssuer(tokenString string) string {
 }
 
 // readLoop reads messages from client
-func (s *Server) readLoop(conn *room.Connection) {
+func (s *Server) readLoop(t room.Transport, conn *room.Connection) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Error().Interface("panic", r).Str("conn_id", conn.ID).Msg("Panic in read loop")
@@ -458,7 +522,7 @@ func (s *Server) readLoop(conn *room.Connection) {
 	}()
 
 	for {
-		_, msg, err := conn.Conn.ReadMessage()
+		msg, err := t.ReadFrame()
 		if err != nil {
 			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
 				log.Debug().Str("conn_id", conn.ID).Msg("Connection closed normally")
@@ -473,7 +537,7 @@ func (s *Server) readLoop(conn *room.Connection) {
 
 		// Parse message
 		var clientMsg ClientMessage
-		if err := json.Unmarshal(msg, &clientMsg); err != nil {
+		if err := conn.Codec.DecodeClient(msg, &clientMsg); err != nil {
 			log.Warn().Err(err).Str("conn_id", conn.ID).Msg("Invalid message format")
 			continue
 		}
@@ -483,83 +547,147 @@ func (s *Server) readLoop(conn *room.Connection) {
 }
 
 // writeLoop writes messages to client
-func (s *Server) writeLoop(conn *room.Connection) {
-	pingTicker := time.NewTicker(s.cfg.WS.PingInterval)
-	defer pingTicker.Stop()
-
-	for {
-		select {
-		case msg, ok := <-conn.SendChannel():
-			if !ok {
-				// Channel closed
-				return
-			}
-
-			conn.Conn.SetWriteDeadline(time.Now().Add(s.cfg.WS.WriteTimeout))
-			if err := conn.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-				log.Debug().Err(err).Str("conn_id", conn.ID).Msg("Write error")
-				return
-			}
-
-		case <-pingTicker.C:
-			conn.Conn.SetWriteDeadline(time.Now().Add(s.cfg.WS.WriteTimeout))
-			if err := conn.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Debug().Err(err).Str("conn_id", conn.ID).Msg("Ping error")
-				return
-			}
-		}
+func (s *Server) writeLoop(sink room.Sink, conn *room.Connection) {
+	if err := conn.Pump(sink, s.cfg().WS.PingInterval); err != nil {
+		log.Debug().Err(err).Str("conn_id", conn.ID).Msg("Write error")
 	}
 }
 
 // handleClientMessage processes a message from client
 func (s *Server) handleClientMessage(conn *room.Connection, msg *ClientMessage) {
+	if !s.allowMessage(conn, msg) {
+		return
+	}
+
 	switch msg.Type {
 	case "ping":
 		// Respond with pong
-		conn.Send([]byte(`{"type":"pong","timestamp":"` + time.Now().Format(time.RFC3339) + `"}`))
+		s.sendFrame(conn, ServerMessage{Type: "pong", ID: msg.ID, Timestamp: time.Now()})
 
 	case "join":
 		// Join a room
 		if msg.Room != "" {
 			if !isValidRoomID(msg.Room) {
-				conn.Send([]byte(`{"type":"error","code":"INVALID_ROOM","message":"invalid room_id"}`))
+				s.sendFrame(conn, ServerMessage{
+					Type:      "error",
+					ID:        msg.ID,
+					Payload:   errorPayload("INVALID_ROOM", "invalid room_id"),
+					Timestamp: time.Now(),
+				})
+				return
+			}
+			if err := s.roomManager.JoinRoom(conn.ID, msg.Room); err != nil {
+				s.sendFrame(conn, ServerMessage{
+					Type:      "error",
+					ID:        msg.ID,
+					Room:      msg.Room,
+					Payload:   errorPayload("FORBIDDEN_ROOM", err.Error()),
+					Timestamp: time.Now(),
+				})
 				return
 			}
-			s.roomManager.JoinRoom(conn.ID, msg.Room)
-			conn.Send([]byte(`{"type":"joined","room":"` + msg.Room + `"}`))
+			s.sendFrame(conn, ServerMessage{Type: "joined", ID: msg.ID, Room: msg.Room, Timestamp: time.Now()})
 		}
 
 	case "leave":
 		// Leave a room
 		if msg.Room != "" {
+			if conn.IsDefaultRoom(msg.Room) {
+				s.sendFrame(conn, ServerMessage{
+					Type:      "error",
+					ID:        msg.ID,
+					Room:      msg.Room,
+					Payload:   errorPayload("FORBIDDEN_ROOM", "default rooms cannot be left"),
+					Timestamp: time.Now(),
+				})
+				return
+			}
 			s.roomManager.LeaveRoom(conn.ID, msg.Room)
-			conn.Send([]byte(`{"type":"left","room":"` + msg.Room + `"}`))
+			s.sendFrame(conn, ServerMessage{Type: "left", ID: msg.ID, Room: msg.Room, Timestamp: time.Now()})
 		}
 
+	case "rpc":
+		// Request-reply to a backend service
+		s.handleRPC(conn, msg)
+
 	case "typing":
-		// Broadcast typing indicator to room
-		if msg.Room != "" {
+		// Broadcast typing indicator to room; brands may turn them off,
+		// in which case they are dropped without an error frame
+		if msg.Room != "" && conn.Settings().Typing {
+			brandID, roomID, err := roomScope(conn, msg.Room)
+			if err != nil {
+				return
+			}
+			payload, _ := json.Marshal(map[string]string{"user_id": conn.UserID, "type": conn.Type})
 			typingMsg := ServerMessage{
 				Type:      "typing",
 				Room:      msg.Room,
-				Payload:   json.RawMessage(`{"user_id":"` + conn.UserID + `","type":"` + conn.Type + `"}`),
+				Payload:   payload,
 				Timestamp: time.Now(),
 			}
 			data, _ := json.Marshal(typingMsg)
-			s.roomManager.BroadcastToRoom(msg.Room, data, conn.ID)
+			s.roomManager.BroadcastToRoom(brandID, roomID, data, conn.ID)
 		}
 
 	default:
 		// Forward other message types to NATS for backend consumers
-		stream := strings.ToLower(conn.Type)
+		if !conn.Settings().AllowsType(msg.Type) {
+			s.sendFrame(conn, ServerMessage{
+				Type:      "error",
+				ID:        msg.ID,
+				Payload:   errorPayload("FORBIDDEN_TYPE", "message type not allowed for this brand"),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+
+		// Publishes are scoped to the connection's brand, or for platform
+		// admins to the brand of a qualified room
+		brandID, roomID := conn.BrandID, msg.Room
+		if msg.Room != "" {
+			var err error
+			if brandID, roomID, err = roomScope(conn, msg.Room); err != nil {
+				s.sendFrame(conn, ServerMessage{
+					Type:      "error",
+					ID:        msg.ID,
+					Room:      msg.Room,
+					Payload:   errorPayload("FORBIDDEN_ROOM", err.Error()),
+					Timestamp: time.Now(),
+				})
+				return
+			}
+		}
+
+		stream := strings.ToUpper(conn.ClaimType)
 		if stream == "" {
-			stream = "chat"
+			stream = "CHAT"
+		}
+		subject, ok := s.routes.Load().Resolve(msg.Type, routing.Fields{
+			Brand:  brandID,
+			Room:   roomID,
+			User:   conn.UserID,
+			Type:   msg.Type,
+			Stream: stream,
+		})
+		if !ok {
+			s.sendFrame(conn, ServerMessage{
+				Type:      "error",
+				ID:        msg.ID,
+				Payload:   errorPayload("UNKNOWN_TYPE", "unknown message type"),
+				Timestamp: time.Now(),
+			})
+			return
 		}
+
+		msgID := uuid.New().String()
 		event := nats.Event{
+			SpecVersion:   nats.SpecVersion,
+			ID:            msgID,
+			Source:        nats.ClientSourcePrefix + s.cfg().NATS.ClientID,
 			Type:          msg.Type,
-			Room:          msg.Room,
+			Room:          roomID,
 			UserID:        conn.UserID,
-			BrandID:       conn.BrandID,
+			BrandID:       brandID,
 			Payload:       msg.Payload,
 			Timestamp:     time.Now(),
 			ExcludeConnID: conn.ID,
@@ -576,8 +704,15 @@ func (s *Server) handleClientMessage(conn *room.Connection, msg *ClientMessage)
 			return
 		}
 
-		subject := fmt.Sprintf("%s.events", stream)
-		if err := s.nats.Publish(subject, data); err != nil {
+		meta := nats.Meta{
+			MsgID:         msgID,
+			CorrelationID: newCorrelationID(msg.ID),
+			ConnID:        conn.ID,
+			UserID:        conn.UserID,
+			BrandID:       conn.BrandID,
+			TraceParent:   traceParent(msg),
+		}
+		if err := s.nats.Publish(subject, data, meta); err != nil {
 			log.Error().Err(err).Str("conn_id", conn.ID).Str("subject", subject).Msg("Failed to publish to NATS")
 			return
 		}
@@ -586,19 +721,51 @@ func (s *Server) handleClientMessage(conn *room.Connection, msg *ClientMessage)
 			Str("conn_id", conn.ID).
 			Str("subject", subject).
 			Str("type", msg.Type).
+			Str("msg_id", meta.MsgID).
+			Str("correlation_id", meta.CorrelationID).
+			Str("traceparent", meta.TraceParent).
 			Msg("Forwarded message to NATS")
 	}
 }
 
+// traceParent continues the client's trace context, or starts one when it
+// sent none or an invalid one
+func traceParent(msg *ClientMessage) string {
+	if nats.ValidTraceParent(msg.TraceParent) {
+		return msg.TraceParent
+	}
+	return nats.NewTraceParent()
+}
+
 // Start starts the server
 func (s *Server) Start() {
-	log.Info().Str("port", s.cfg.Server.Port).Msg("Starting WebSocket server")
-	if err := s.app.Listen(":" + s.cfg.Server.Port); err != nil {
+	if s.adminApp != nil {
+		go func() {
+			log.Info().Str("port", s.cfg().Admin.Port).Msg("Starting admin API server")
+			if err := s.adminApp.Listen(":" + s.cfg().Admin.Port); err != nil {
+				log.Error().Err(err).Msg("Admin API server error")
+			}
+		}()
+	}
+
+	log.Info().Str("port", s.cfg().Server.Port).Msg("Starting WebSocket server")
+	if err := s.app.Listen(":" + s.cfg().Server.Port); err != nil {
 		log.Fatal().Err(err).Msg("Server failed to start")
 	}
 }
 
+// Serve serves the client API on ln instead of server.port, e.g. in tests;
+// the admin API is not started
+func (s *Server) Serve(ln net.Listener) error {
+	return s.app.Listener(ln)
+}
+
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.adminApp != nil {
+		if err := s.adminApp.ShutdownWithContext(ctx); err != nil {
+			log.Error().Err(err).Msg("Admin API shutdown error")
+		}
+	}
 	return s.app.ShutdownWithContext(ctx)
 }
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/attchat/attchat-gateway/internal/nats"
+)
+
+func TestTraceParent(t *testing.T) {
+	const client = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	if got := traceParent(&ClientMessage{TraceParent: client}); got != client {
+		t.Errorf("valid client traceparent = %q, want it continued", got)
+	}
+	for _, sent := range []string{"", "garbage", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331"} {
+		got := traceParent(&ClientMessage{TraceParent: sent})
+		if got == sent || !nats.ValidTraceParent(got) {
+			t.Errorf("traceparent %q = %q, want a new valid one", sent, got)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrClosed is returned when writing to a closed Memory transport
+var ErrClosed = errors.New("transport closed")
+
+// Memory is an in-memory room.Transport for tests and tools. Frames the
+// gateway writes are recorded; frames passed to Inject are returned by
+// ReadFrame, as if the client had sent them.
+type Memory struct {
+	mu      sync.Mutex
+	frames  [][]byte
+	pings   int
+	closed  bool
+	code    int
+	reason  string
+	inbound chan []byte
+	written chan struct{}
+	done    chan struct{}
+}
+
+// NewMemory creates an open in-memory transport
+func NewMemory() *Memory {
+	return &Memory{
+		inbound: make(chan []byte, 64),
+		written: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// WriteFrame records a copy of frame
+func (m *Memory) WriteFrame(frame []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.frames = append(m.frames, append([]byte(nil), frame...))
+	select {
+	case m.written <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Ping counts a ping
+func (m *Memory) Ping() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.pings++
+	return nil
+}
+
+// Close records the close code and reason and unblocks ReadFrame
+func (m *Memory) Close(code int, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.closed = true
+	m.code = code
+	m.reason = reason
+	close(m.done)
+	return nil
+}
+
+// ReadFrame returns the next injected frame, or io.EOF once closed
+func (m *Memory) ReadFrame() ([]byte, error) {
+	select {
+	case frame := <-m.inbound:
+		return frame, nil
+	case <-m.done:
+		return nil, io.EOF
+	}
+}
+
+// Inject queues a frame for ReadFrame, as if sent by the client
+func (m *Memory) Inject(frame []byte) {
+	m.inbound <- frame
+}
+
+// Frames returns the frames written so far
+func (m *Memory) Frames() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]byte(nil), m.frames...)
+}
+
+// Pings returns the number of pings written
+func (m *Memory) Pings() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pings
+}
+
+// CloseInfo reports whether the transport was closed, and with what
+func (m *Memory) CloseInfo() (closed bool, code int, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed, m.code, m.reason
+}
+
+// Written is signalled after frames are written; use Frames to read them
+func (m *Memory) Written() <-chan struct{} {
+	return m.written
+}
+
+// Done is closed when the transport is closed
+func (m *Memory) Done() <-chan struct{} {
+	return m.done
+}
@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// SSE is a room.Sink writing Server-Sent Events to a streamed response
+type SSE struct {
+	w            *bufio.Writer
+	conn         net.Conn
+	writeTimeout time.Duration
+}
+
+// NewSSE wraps a response body stream. conn is the underlying connection,
+// whose write deadline is extended before every flush so the server's
+// write timeout does not cut the stream.
+func NewSSE(w *bufio.Writer, conn net.Conn, writeTimeout time.Duration) *SSE {
+	return &SSE{w: w, conn: conn, writeTimeout: writeTimeout}
+}
+
+// WriteFrame writes a frame as an unnamed event
+func (s *SSE) WriteFrame(frame []byte) error {
+	s.writeEvent("", frame)
+	return s.flush()
+}
+
+// Ping writes a comment line
+func (s *SSE) Ping() error {
+	s.w.WriteString(": ping\n\n")
+	return s.flush()
+}
+
+// Close writes a "close" event carrying {code, reason}
+func (s *SSE) Close(code int, reason string) error {
+	payload, _ := json.Marshal(map[string]interface{}{"code": code, "reason": reason})
+	s.writeEvent("close", payload)
+	return s.flush()
+}
+
+// writeEvent writes one event; multi-line data becomes several data: lines
+func (s *SSE) writeEvent(event string, data []byte) {
+	if event != "" {
+		s.w.WriteString("event: " + event + "\n")
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		s.w.WriteString("data: ")
+		s.w.Write(line)
+		s.w.WriteByte('\n')
+	}
+	s.w.WriteByte('\n')
+}
+
+func (s *SSE) flush() error {
+	if s.conn != nil {
+		s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	}
+	return s.w.Flush()
+}
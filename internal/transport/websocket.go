@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"strings"
+	"time"
+
+	"github.com/attchat/attchat-gateway/internal/protocol"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+)
+
+// maxCloseReason is the longest reason that fits a WebSocket close frame
+const maxCloseReason = 123
+
+// WebSocket is a room.Transport over a WebSocket connection
+type WebSocket struct {
+	conn         *websocket.Conn
+	writeTimeout time.Duration
+	messageType  int
+}
+
+// NewWebSocket wraps an upgraded connection. Frames are sent as binary
+// messages when binary is set, text otherwise.
+func NewWebSocket(conn *websocket.Conn, writeTimeout time.Duration, binary bool) *WebSocket {
+	messageType := websocket.TextMessage
+	if binary {
+		messageType = websocket.BinaryMessage
+	}
+	return &WebSocket{conn: conn, writeTimeout: writeTimeout, messageType: messageType}
+}
+
+// WriteFrame writes a data message
+func (w *WebSocket) WriteFrame(frame []byte) error {
+	w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	return w.conn.WriteMessage(w.messageType, frame)
+}
+
+// WritePrepared writes a frame through a websocket.PreparedMessage shared
+// by every connection receiving it, so compression happens once per frame
+func (w *WebSocket) WritePrepared(frame *protocol.Frame, codec protocol.Codec, data []byte) error {
+	v, err := frame.Prepared(codec, "websocket", func(data []byte) (interface{}, error) {
+		return fastws.NewPreparedMessage(w.messageType, data)
+	})
+	if err != nil {
+		return w.WriteFrame(data)
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	return w.conn.WritePreparedMessage(v.(*fastws.PreparedMessage))
+}
+
+// Ping writes a ping control frame
+func (w *WebSocket) Ping() error {
+	w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	return w.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+// Close sends a close frame and closes the socket, which also unblocks ReadFrame
+func (w *WebSocket) Close(code int, reason string) error {
+	if len(reason) > maxCloseReason {
+		reason = strings.ToValidUTF8(reason[:maxCloseReason], "")
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	w.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+	return w.conn.Close()
+}
+
+// ReadFrame reads the next data message
+func (w *WebSocket) ReadFrame() ([]byte, error) {
+	_, msg, err := w.conn.ReadMessage()
+	return msg, err
+}
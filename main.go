@@ -2,10 +2,11 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/attchat/attchat-gateway/internal/config"
 	"github.com/attchat/attchat-gateway/internal/metrics"
@@ -16,7 +17,50 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+const usage = `ATTChat Gateway
+
+Usage:
+  gateway [serve]                  run the gateway (default)
+  gateway config check|print       validate or print the configuration
+  gateway token mint|inspect       create a dev JWT or explain why one is rejected
+  gateway connect                  interactive WebSocket client
+  gateway stats                    show the stats of a running node
+  gateway health                   probe a running node (exit 1 when not alive)
+  gateway loadgen                  capacity test: connections, NATS events, latency report
+
+Run "gateway <command> -h" for the flags of a command.`
+
+// commands are the subcommands; each returns the process exit code
+var commands = map[string]func(args []string) int{
+	"serve":   runServe,
+	"config":  runConfig,
+	"token":   runToken,
+	"connect": runConnect,
+	"stats":   runStats,
+	"health":  runHealth,
+	"loadgen": runLoadgen,
+}
+
 func main() {
+	name, args := "serve", []string(nil)
+	if len(os.Args) > 1 {
+		name, args = os.Args[1], os.Args[2:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		if name != "help" && name != "-h" && name != "--help" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		}
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	os.Exit(cmd(args))
+}
+
+// runServe runs the gateway until SIGINT/SIGTERM
+func runServe(args []string) int {
+	flag.NewFlagSet("serve", flag.ExitOnError).Parse(args)
+
 	// Setup logger
 	setupLogger()
 
@@ -28,10 +72,14 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
 
+	setLogLevel(cfg.Log.Level)
+	config.LogWarnings()
+
 	log.Info().
 		Str("port", cfg.Server.Port).
 		Str("nats_url", cfg.NATS.URL).
 		Msg("Configuration loaded")
+	live := config.NewLive(cfg)
 
 	// Initialize metrics
 	metricsServer := metrics.NewServer(cfg.Metrics.Port)
@@ -45,18 +93,34 @@ func main() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to connect to NATS")
 	}
-	defer natsConsumer.Close()
 
 	// Start NATS consumer
 	go natsConsumer.Start()
 
 	// Initialize and start HTTP/WebSocket server
-	srv, err := server.New(cfg, roomManager, natsConsumer)
+	srv, err := server.New(live, roomManager, natsConsumer)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to init server")
 	}
 	go srv.Start()
 
+	// Hot reload on config file changes and SIGHUP
+	live.OnReload("log", func(next *config.Config) (func(), error) {
+		level, err := parseLogLevel(next.Log.Level)
+		if err != nil {
+			return nil, err
+		}
+		return func() { zerolog.SetGlobalLevel(level) }, nil
+	})
+	live.Watch()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			live.Reload("sighup")
+		}
+	}()
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -64,14 +128,24 @@ func main() {
 
 	log.Info().Msg("Shutting down gracefully...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
 	defer cancel()
 
+	// Move clients elsewhere while NATS keeps delivering to those still connected
+	srv.Drain(ctx)
+
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Error().Err(err).Msg("Server shutdown error")
 	}
 
+	// Publish what clients sent last, then stop consumers
+	if err := natsConsumer.Flush(ctx); err != nil {
+		log.Error().Err(err).Msg("NATS flush error")
+	}
+	natsConsumer.Close()
+
 	log.Info().Msg("Gateway stopped")
+	return 0
 }
 
 func setupLogger() {
@@ -80,16 +154,26 @@ func setupLogger() {
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	}
 
-	// Set log level
-	level := os.Getenv("LOG_LEVEL")
-	switch level {
-	case "debug":
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	case "warn":
-		zerolog.SetGlobalLevel(zerolog.WarnLevel)
-	case "error":
-		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
-	default:
+	// Set log level until log.level is loaded
+	if setLogLevel(os.Getenv("LOG_LEVEL")) != nil {
 		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	}
 }
+
+// setLogLevel applies a level name
+func setLogLevel(name string) error {
+	level, err := parseLogLevel(name)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(level)
+	return nil
+}
+
+// parseLogLevel parses debug, info, warn or error; empty means info
+func parseLogLevel(name string) (zerolog.Level, error) {
+	if name == "" {
+		return zerolog.InfoLevel, nil
+	}
+	return zerolog.ParseLevel(name)
+}